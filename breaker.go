@@ -0,0 +1,175 @@
+package pkg_ai
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrorCircuitOpen = errors.New("服务熔断中, 暂停请求")
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"    // 正常
+	BreakerOpen     BreakerState = "open"      // 熔断, 请求直接返回 ErrorCircuitOpen
+	BreakerHalfOpen BreakerState = "half_open" // 冷却结束, 放行少量探测请求
+
+	DefaultBreakerFailureRatio     = 0.5
+	DefaultBreakerMinRequests      = 10
+	DefaultBreakerWindow           = time.Minute
+	DefaultBreakerCooldown         = 30 * time.Second
+	DefaultBreakerHalfOpenRequests = 1
+)
+
+// BreakerConf 熔断配置, 限流、服务端错误、网络错误等可重试的错误计为失败, 参数错误等不计入
+type BreakerConf struct {
+	FailureRatio     float64       `json:"failure_ratio"`      // 统计窗口内失败率达到该值时熔断, 默认 0.5
+	MinRequests      int           `json:"min_requests"`       // 统计窗口内请求数达到该值才判断失败率, 默认 10
+	Window           time.Duration `json:"window"`             // 统计窗口, 默认 1 分钟
+	Cooldown         time.Duration `json:"cooldown"`           // 熔断后等待该时长进入半开状态, 默认 30 秒
+	HalfOpenRequests int           `json:"half_open_requests"` // 半开状态同时放行的探测请求数, 默认 1
+}
+
+// breaker 熔断器, 为 nil 时不熔断
+type breaker struct {
+	lock     sync.Mutex
+	conf     BreakerConf
+	state    BreakerState
+	start    time.Time // 当前统计窗口开始时间
+	total    int
+	failures int
+	opened   time.Time
+	probes   int // 半开状态进行中的探测请求数
+}
+
+func newBreaker(conf BreakerConf) *breaker {
+	if conf.FailureRatio <= 0 {
+		conf.FailureRatio = DefaultBreakerFailureRatio
+	}
+	if conf.MinRequests <= 0 {
+		conf.MinRequests = DefaultBreakerMinRequests
+	}
+	if conf.Window <= 0 {
+		conf.Window = DefaultBreakerWindow
+	}
+	if conf.Cooldown <= 0 {
+		conf.Cooldown = DefaultBreakerCooldown
+	}
+	if conf.HalfOpenRequests <= 0 {
+		conf.HalfOpenRequests = DefaultBreakerHalfOpenRequests
+	}
+
+	return &breaker{conf: conf, state: BreakerClosed, start: time.Now()}
+}
+
+// State 当前状态, 熔断冷却结束后返回半开
+func (b *breaker) State() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.advance(time.Now())
+	return b.state
+}
+
+func (b *breaker) advance(now time.Time) {
+	if b.state == BreakerOpen && now.Sub(b.opened) >= b.conf.Cooldown {
+		b.state = BreakerHalfOpen
+		b.probes = 0
+	}
+	if b.state == BreakerClosed && now.Sub(b.start) >= b.conf.Window {
+		b.start, b.total, b.failures = now, 0, 0
+	}
+}
+
+// allow 是否放行请求, probe 表示半开状态下的探测请求, 放行后需调用 record 记录结果
+func (b *breaker) allow() (probe bool, err error) {
+	if b == nil {
+		return false, nil
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.advance(time.Now())
+	switch b.state {
+	case BreakerOpen:
+		return false, ErrorCircuitOpen
+	case BreakerHalfOpen:
+		if b.probes >= b.conf.HalfOpenRequests {
+			return false, ErrorCircuitOpen
+		}
+		b.probes++
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// record 记录请求结果, counted 为 false 表示请求未实际完成(如调用方取消), 不计入统计
+func (b *breaker) record(probe bool, err error, counted bool) {
+	if b == nil {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	failed, _ := retryable(err)
+	failed = failed && !errors.Is(err, ErrorAuth) && !errors.Is(err, ErrorQuota) // 凭证问题不代表服务不可用
+
+	if probe {
+		if b.state != BreakerHalfOpen {
+			return
+		}
+		b.probes--
+		if !counted {
+			return
+		}
+		if failed {
+			b.state, b.opened = BreakerOpen, now
+		} else {
+			b.state, b.start, b.total, b.failures = BreakerClosed, now, 0, 0
+		}
+		return
+	}
+
+	if !counted || b.state != BreakerClosed {
+		return
+	}
+
+	b.advance(now)
+	b.total++
+	if failed {
+		b.failures++
+	}
+	if b.total >= b.conf.MinRequests && float64(b.failures)/float64(b.total) >= b.conf.FailureRatio {
+		b.state, b.opened = BreakerOpen, now
+	}
+}
+
+// BreakerState 服务商的熔断状态, 未配置熔断时总是返回 closed
+func (s *Server) BreakerState() BreakerState {
+	return s.breaker.State()
+}
+
+// BreakerStates 已配置熔断的服务商(名称小写)及其状态, 可用于健康检查
+func (c *Client) BreakerStates() map[string]BreakerState {
+	states := make(map[string]BreakerState, len(c.breakers))
+	for name, item := range c.breakers {
+		states[name] = item.State()
+	}
+
+	return states
+}
+
+// breakerFor 服务商的熔断器, 同一实例下同名服务商共享
+func (c *Client) breakerFor(name string) *breaker {
+	return c.breakers[strings.ToLower(name)]
+}
@@ -0,0 +1,110 @@
+package pkg_ai
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreakerOpenAndRecover(t *testing.T) {
+	var hits, healthy int32
+	upstream := newDeepSeekUpstream(t, func(r *http.Request) (int, string) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			return http.StatusServiceUnavailable, `{"error":{"message":"busy","type":"error"}}`
+		}
+		return http.StatusOK, deepSeekReply
+	})
+
+	conf := &Config{}
+	client := NewClient(conf, WithDeepSeekConfig(upstream.URL, "sk"), WithBreaker("DeepSeek", BreakerConf{MinRequests: 2, Cooldown: 30 * time.Millisecond}))
+	if len(conf.Breakers) != 0 {
+		t.Errorf("conf.Breakers modified: %v", conf.Breakers)
+	}
+	server, err := client.NewServer(ImplementDeepSeek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := RequestData{Model: "deepseek-chat", UserQuery: "你好"}
+
+	for i := 0; i < 2; i++ {
+		if _, err := server.Chat(data); err == nil {
+			t.Fatal("want error")
+		}
+	}
+	if state := server.BreakerState(); state != BreakerOpen {
+		t.Fatalf("state = %s, want open", state)
+	}
+
+	// 同一实例下同名服务共享熔断器
+	other, err := client.NewServerByName("deepseek")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Chat(data); !errors.Is(err, ErrorCircuitOpen) {
+		t.Errorf("err = %v, want ErrorCircuitOpen", err)
+	}
+	if hits != 2 {
+		t.Errorf("hits = %d, want 2", hits)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if state := client.BreakerStates()["deepseek"]; state != BreakerHalfOpen {
+		t.Fatalf("state = %s, want half_open", state)
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	if _, err := server.Chat(data); err != nil {
+		t.Fatal(err)
+	}
+	if state := server.BreakerState(); state != BreakerClosed {
+		t.Errorf("state = %s, want closed", state)
+	}
+}
+
+func TestBreakerProbeFailure(t *testing.T) {
+	b := newBreaker(BreakerConf{MinRequests: 1, Cooldown: 10 * time.Millisecond})
+	failure := &APIError{StatusCode: http.StatusBadGateway, Category: CategoryServer, retryable: true}
+
+	probe, err := b.allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.record(probe, failure, true)
+	if b.State() != BreakerOpen {
+		t.Fatalf("state = %s, want open", b.State())
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	probe, err = b.allow()
+	if err != nil || !probe {
+		t.Fatalf("probe = %v, err = %v", probe, err)
+	}
+	// 半开状态只放行一个探测请求
+	if _, err := b.allow(); !errors.Is(err, ErrorCircuitOpen) {
+		t.Errorf("err = %v, want ErrorCircuitOpen", err)
+	}
+
+	b.record(probe, failure, true)
+	if b.State() != BreakerOpen {
+		t.Errorf("state = %s, want open", b.State())
+	}
+}
+
+func TestBreakerIgnoresInvalidRequest(t *testing.T) {
+	b := newBreaker(BreakerConf{MinRequests: 2})
+	invalid := &APIError{StatusCode: http.StatusBadRequest, Category: CategoryInvalidRequest}
+
+	for i := 0; i < 5; i++ {
+		probe, err := b.allow()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.record(probe, invalid, true)
+	}
+	if b.State() != BreakerClosed {
+		t.Errorf("state = %s, want closed", b.State())
+	}
+}
@@ -0,0 +1,305 @@
+package pkg_ai
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache 响应缓存存储, 实现该接口即可接入 Redis 等外部存储; ttl 为 0 时不过期
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// CacheConf 响应缓存配置, Cache 为空时不缓存
+// 缓存键由服务商、模型及请求体计算, 阻塞式与流式请求共用缓存, 流式请求命中时按缓存内容模拟推送
+type CacheConf struct {
+	Cache Cache         `json:"-"`
+	TTL   time.Duration `json:"ttl"` // 缓存有效期, 为 0 时不过期
+}
+
+// cachedResponse 缓存内容, 不含请求头等鉴权信息
+type cachedResponse struct {
+	ResponseText     string                 `json:"response_text"`
+	Chunks           []string               `json:"chunks,omitempty"` // 流式请求的分段内容, 命中时按原分段推送
+	PromptTokens     int64                  `json:"prompt_tokens"`
+	CompletionTokens int64                  `json:"completion_tokens"`
+	Usage            Usage                  `json:"usage"`
+	RequestId        string                 `json:"request_id"`
+	ToolCalls        []ToolCall             `json:"tool_calls,omitempty"`
+	FinishReason     FinishReason           `json:"finish_reason,omitempty"`
+	Metadata         map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// responseCache 服务商使用的缓存
+type responseCache struct {
+	conf CacheConf
+}
+
+func newResponseCache(conf CacheConf) *responseCache {
+	if conf.Cache == nil {
+		return nil
+	}
+
+	return &responseCache{conf: conf}
+}
+
+// key 请求体按 JSON 规范化(键排序)后计算, 并忽略 stream、stream_options 字段; 无法解析时使用原始请求体
+func (c *responseCache) key(supplier string, payload []byte) string {
+	model, body := "", payload
+
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(payload, &fields); err == nil {
+		for field, value := range fields {
+			switch strings.ToLower(field) {
+			case "stream", "stream_options":
+				delete(fields, field)
+			case "model":
+				model, _ = value.(string)
+			}
+		}
+		body, _ = json.Marshal(fields)
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(strings.ToLower(supplier) + "\n" + model + "\n"))
+	hash.Write(body)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// get 读取缓存, 存储出错时视为未命中
+func (c *responseCache) get(ctx context.Context, key string) (*Response, []string, bool) {
+	if c == nil {
+		return nil, nil, false
+	}
+
+	value, ok, err := c.conf.Cache.Get(ctx, key)
+	if err != nil || !ok {
+		return nil, nil, false
+	}
+
+	item := cachedResponse{}
+	if err := json.Unmarshal(value, &item); err != nil {
+		return nil, nil, false
+	}
+
+	response := &Response{
+		RequestHeader:    make([]byte, 0),
+		ResponseData:     make([][]byte, 0),
+		ResponseText:     item.ResponseText,
+		PromptTokens:     item.PromptTokens,
+		CompletionTokens: item.CompletionTokens,
+		Usage:            item.Usage,
+		RequestId:        item.RequestId,
+		ToolCalls:        item.ToolCalls,
+		FinishReason:     item.FinishReason,
+		Metadata:         item.Metadata,
+		CacheHit:         true,
+	}
+
+	chunks := item.Chunks
+	if len(chunks) == 0 && len(item.ResponseText) > 0 {
+		chunks = []string{item.ResponseText}
+	}
+
+	return response, chunks, true
+}
+
+// set 写入缓存, 存储出错时忽略
+func (c *responseCache) set(ctx context.Context, key string, response *Response, chunks []string) {
+	if c == nil || response == nil {
+		return
+	}
+
+	value, err := json.Marshal(cachedResponse{
+		ResponseText:     response.ResponseText,
+		Chunks:           chunks,
+		PromptTokens:     response.PromptTokens,
+		CompletionTokens: response.CompletionTokens,
+		Usage:            response.Usage,
+		RequestId:        response.RequestId,
+		ToolCalls:        response.ToolCalls,
+		FinishReason:     response.FinishReason,
+		Metadata:         response.Metadata,
+	})
+	if err != nil {
+		return
+	}
+
+	_ = c.conf.Cache.Set(ctx, key, value, c.conf.TTL)
+}
+
+// replay 按缓存的分段内容模拟流式推送
+func replay(chunks []string, handler StreamHandler) error {
+	for _, chunk := range chunks {
+		if err := handler(chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const DefaultMemoryCacheSize = 1000
+
+// MemoryCache 进程内 LRU 缓存, 超过容量时淘汰最久未使用的数据
+type MemoryCache struct {
+	lock     sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List
+}
+
+type memoryCacheItem struct {
+	key    string
+	value  []byte
+	expire time.Time
+}
+
+// NewMemoryCache capacity 为缓存条数, 小于等于 0 时使用 DefaultMemoryCacheSize
+func NewMemoryCache(capacity int) *MemoryCache {
+	if capacity <= 0 {
+		capacity = DefaultMemoryCacheSize
+	}
+
+	return &MemoryCache{capacity: capacity, items: make(map[string]*list.Element), order: list.New()}
+}
+
+func (m *MemoryCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	element, ok := m.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	item := element.Value.(*memoryCacheItem)
+	if !item.expire.IsZero() && time.Now().After(item.expire) {
+		m.order.Remove(element)
+		delete(m.items, key)
+		return nil, false, nil
+	}
+	m.order.MoveToFront(element)
+
+	return item.value, true, nil
+}
+
+func (m *MemoryCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	item := &memoryCacheItem{key: key, value: value}
+	if ttl > 0 {
+		item.expire = time.Now().Add(ttl)
+	}
+
+	if element, ok := m.items[key]; ok {
+		element.Value = item
+		m.order.MoveToFront(element)
+		return nil
+	}
+
+	m.items[key] = m.order.PushFront(item)
+	for m.order.Len() > m.capacity {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.items, oldest.Value.(*memoryCacheItem).key)
+	}
+
+	return nil
+}
+
+// Len 当前缓存条数, 含已过期但未淘汰的数据
+func (m *MemoryCache) Len() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.order.Len()
+}
+
+// FileCache 文件缓存, 每条数据保存为目录下的一个文件, 适用于多进程共享或重启后保留缓存
+type FileCache struct {
+	dir string
+}
+
+type fileCacheItem struct {
+	Expire int64  `json:"expire"` // 过期时间戳(纳秒), 为 0 时不过期
+	Value  []byte `json:"value"`
+}
+
+// NewFileCache dir 不存在时自动创建
+func NewFileCache(dir string) (*FileCache, error) {
+	if len(dir) == 0 {
+		return nil, errors.New("缓存目录不能为空")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileCache{dir: dir}, nil
+}
+
+func (f *FileCache) path(key string) string {
+	return filepath.Join(f.dir, filepath.Base(key)+".json")
+}
+
+func (f *FileCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	content, err := os.ReadFile(f.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	item := fileCacheItem{}
+	if err := json.Unmarshal(content, &item); err != nil {
+		return nil, false, err
+	}
+	if item.Expire > 0 && time.Now().UnixNano() > item.Expire {
+		_ = os.Remove(f.path(key))
+		return nil, false, nil
+	}
+
+	return item.Value, true, nil
+}
+
+// Set 先写入临时文件再重命名, 避免并发读取到不完整的内容
+func (f *FileCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	item := fileCacheItem{Value: value}
+	if ttl > 0 {
+		item.Expire = time.Now().Add(ttl).UnixNano()
+	}
+
+	content, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(f.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(content); err != nil {
+		_ = temp.Close()
+		_ = os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		_ = os.Remove(temp.Name())
+		return err
+	}
+
+	return os.Rename(temp.Name(), f.path(key))
+}
@@ -0,0 +1,181 @@
+package pkg_ai
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheChat(t *testing.T) {
+	var hits int32
+	upstream := newDeepSeekUpstream(t, func(r *http.Request) (int, string) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			return http.StatusServiceUnavailable, `{"error":{"message":"busy","type":"error"}}`
+		}
+		return http.StatusOK, deepSeekReply
+	})
+
+	client := NewClient(&Config{}, WithDeepSeekConfig(upstream.URL, "sk"), WithCache(NewMemoryCache(0), time.Minute))
+	server, err := client.NewServer(ImplementDeepSeek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := RequestData{Model: "deepseek-chat", UserQuery: "你好"}
+
+	// 出错的响应不缓存
+	if _, err := server.Chat(data); err == nil {
+		t.Fatal("want error")
+	}
+	first, err := server.Chat(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := server.Chat(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hits != 2 {
+		t.Errorf("hits = %d, want 2", hits)
+	}
+	if first.CacheHit || !second.CacheHit {
+		t.Errorf("cache hit = %v, %v", first.CacheHit, second.CacheHit)
+	}
+	if second.ResponseText != first.ResponseText || second.Usage.TotalTokens != first.Usage.TotalTokens || second.FinishReason != FinishStop {
+		t.Errorf("cached = %+v", second)
+	}
+
+	if _, err := server.Chat(RequestData{Model: "deepseek-reasoner", UserQuery: "你好"}); err != nil {
+		t.Fatal(err)
+	}
+	if hits != 3 {
+		t.Errorf("hits = %d, want 3", hits)
+	}
+}
+
+func TestCacheStreamReplay(t *testing.T) {
+	var hits int32
+	upstream := newDeepSeekUpstream(t, func(r *http.Request) (int, string) {
+		atomic.AddInt32(&hits, 1)
+		return http.StatusOK, "data: {\"id\":\"ds-1\",\"choices\":[{\"delta\":{\"content\":\"你\"}}]}\n\n" +
+			"data: {\"id\":\"ds-1\",\"choices\":[{\"delta\":{\"content\":\"好\"},\"finish_reason\":\"stop\"}],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":2,\"total_tokens\":5}}\n\n" +
+			"data: [DONE]\n\n"
+	})
+
+	client := NewClient(&Config{}, WithDeepSeekConfig(upstream.URL, "sk"), WithCache(NewMemoryCache(0), 0))
+	server, err := client.NewServer(ImplementDeepSeek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := RequestData{Model: "deepseek-chat", UserQuery: "你好"}
+
+	read := func() ([]string, *Response) {
+		stream, err := server.Stream(context.Background(), data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks := make([]string, 0)
+		for stream.Next() {
+			chunks = append(chunks, stream.Current())
+		}
+		if err := stream.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return chunks, stream.Response()
+	}
+
+	chunks, _ := read()
+	replayed, response := read()
+	if hits != 1 {
+		t.Errorf("hits = %d, want 1", hits)
+	}
+	if strings.Join(replayed, "|") != strings.Join(chunks, "|") || strings.Join(replayed, "") != "你好" {
+		t.Errorf("replayed = %q, want %q", replayed, chunks)
+	}
+	if !response.CacheHit || response.Usage.TotalTokens != 5 {
+		t.Errorf("response = %+v", response)
+	}
+
+	// 阻塞式请求与流式请求共用缓存
+	blocking, err := server.Chat(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !blocking.CacheHit || blocking.ResponseText != "你好" || hits != 1 {
+		t.Errorf("blocking = %+v, hits = %d", blocking, hits)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	cache := newResponseCache(CacheConf{Cache: NewMemoryCache(0)})
+
+	a := cache.key("deepseek", []byte(`{"model":"m","messages":[],"stream":true,"stream_options":{"include_usage":true}}`))
+	b := cache.key("DeepSeek", []byte(`{"messages":[],"model":"m","stream":false}`))
+	if a != b {
+		t.Error("key differs by stream flag or field order")
+	}
+	if c := cache.key("deepseek", []byte(`{"messages":[],"model":"n"}`)); c == a {
+		t.Error("key ignores model")
+	}
+	if c := cache.key("moonshot", []byte(`{"messages":[],"model":"m"}`)); c == a {
+		t.Error("key ignores supplier")
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCache(2)
+
+	_ = cache.Set(ctx, "a", []byte("1"), 0)
+	_ = cache.Set(ctx, "b", []byte("2"), 0)
+	if _, ok, _ := cache.Get(ctx, "a"); !ok {
+		t.Fatal("a missing")
+	}
+	// 超过容量时淘汰最久未使用的 b
+	_ = cache.Set(ctx, "c", []byte("3"), 0)
+	if _, ok, _ := cache.Get(ctx, "b"); ok {
+		t.Error("b not evicted")
+	}
+	if cache.Len() != 2 {
+		t.Errorf("len = %d, want 2", cache.Len())
+	}
+
+	_ = cache.Set(ctx, "d", []byte("4"), 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if _, ok, _ := cache.Get(ctx, "d"); ok {
+		t.Error("d not expired")
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	ctx := context.Background()
+	cache, err := NewFileCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, err := cache.Get(ctx, "missing"); ok || err != nil {
+		t.Errorf("ok = %v, err = %v", ok, err)
+	}
+	if err := cache.Set(ctx, "a", []byte("value"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, err := cache.Get(ctx, "a"); !ok || err != nil || string(value) != "value" {
+		t.Errorf("value = %q, ok = %v, err = %v", value, ok, err)
+	}
+
+	if err := cache.Set(ctx, "b", []byte("value"), 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, ok, _ := cache.Get(ctx, "b"); ok {
+		t.Error("b not expired")
+	}
+
+	if _, err := NewFileCache(""); err == nil {
+		t.Error("want error for empty dir")
+	}
+}
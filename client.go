@@ -0,0 +1,336 @@
+package pkg_ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Client 独立的服务实例, 持有各自的配置、鉴权 token 缓存及 HTTP 连接池, 多个 Client 之间互不影响
+// 适用于多租户等需要同时使用多套密钥的场景, 包级别的 Init / NewServer 等方法等同于操作默认 Client
+type Client struct {
+	config       Config
+	http         *httpScope            // 全局 HTTP 配置对应的请求客户端
+	providerHttp map[string]*httpScope // 服务商名称(小写) => 单独配置的请求客户端
+	tokens       *tokenCache
+	breakers     map[string]*breaker // 服务商名称(小写) => 熔断器
+	telemetry    *telemetry
+}
+
+// NewClient 创建独立的服务实例, options 只作用于当前实例, 不会修改 conf
+func NewClient(conf *Config, options ...Options) *Client {
+	c := Config{}
+	if conf != nil {
+		c = *conf
+		c.OpenAICompatible = append([]OpenAICompatibleConf(nil), conf.OpenAICompatible...)
+		c.Middlewares = append([]Middleware(nil), conf.Middlewares...)
+		c.ProviderHttp = make(map[string]HttpConf, len(conf.ProviderHttp))
+		for name, item := range conf.ProviderHttp {
+			c.ProviderHttp[name] = item
+		}
+		c.Credentials = make(map[string]CredentialPool, len(conf.Credentials))
+		for name, pool := range conf.Credentials {
+			pool.Credentials = append([]Credential(nil), pool.Credentials...)
+			c.Credentials[name] = pool
+		}
+		c.ProviderRetry = make(map[string]RetryConf, len(conf.ProviderRetry))
+		for name, item := range conf.ProviderRetry {
+			c.ProviderRetry[name] = item
+		}
+		c.Limits = make(map[string]LimitConf, len(conf.Limits))
+		for name, item := range conf.Limits {
+			c.Limits[name] = item
+		}
+		c.Breakers = make(map[string]BreakerConf, len(conf.Breakers))
+		for name, item := range conf.Breakers {
+			c.Breakers[name] = item
+		}
+	}
+	for _, option := range options {
+		option.Apply(&c)
+	}
+
+	return newClient(c)
+}
+
+func newClient(conf Config) *Client {
+	c := &Client{
+		config:       conf,
+		http:         newHttpScope(conf.Http),
+		providerHttp: make(map[string]*httpScope, len(conf.ProviderHttp)),
+		tokens:       &tokenCache{items: make(map[string]cachedToken)},
+		breakers:     make(map[string]*breaker, len(conf.Breakers)),
+		telemetry:    newTelemetry(conf.TracerProvider, conf.MeterProvider),
+	}
+	for name, item := range conf.ProviderHttp {
+		c.providerHttp[strings.ToLower(name)] = newHttpScope(conf.Http.merge(item))
+	}
+	for name, item := range conf.Breakers {
+		c.breakers[strings.ToLower(name)] = newBreaker(item)
+	}
+
+	return c
+}
+
+// newServer 实例化服务商并绑定到当前实例, 带上该服务商的重试、限流及熔断配置; 配置了凭证池时为每组凭证各实例化一个服务商
+// build 的 credential 为空时使用配置中的凭证
+func (c *Client) newServer(implementId int8, name string, build func(credential *Credential) (Provider, error)) (*Server, error) {
+	pool, pooled := c.poolFor(name)
+
+	var first *Credential
+	if pooled {
+		first = &pool.Credentials[0]
+	}
+	client, err := c.build(name, first, build)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &Server{client: client, ImplementId: implementId, Name: name, retry: c.retryFor(name), limit: newLimiter(c.limitFor(name)), breaker: c.breakerFor(name), prices: c.config.Prices, cache: newResponseCache(c.config.Cache)}
+	if c.config.Logger != nil {
+		server.middlewares = append(server.middlewares, LogMiddleware(c.config.Logger))
+	}
+	if c.telemetry.enabled {
+		server.middlewares = append(server.middlewares, c.telemetry.middleware)
+	}
+	server.middlewares = append(server.middlewares, c.config.Middlewares...)
+	if pooled {
+		server.pool, err = newCredentialPool(name, pool, func(credential Credential) (Provider, error) {
+			return c.build(name, &credential, build)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return server, nil
+}
+
+func (c *Client) build(name string, credential *Credential, build func(credential *Credential) (Provider, error)) (Provider, error) {
+	client, err := build(credential)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, errors.New("服务商【" + name + "】实例化结果为空")
+	}
+	if binder, ok := client.(clientBinder); ok {
+		binder.bindClient(c, name)
+	}
+
+	return client, nil
+}
+
+// limitFor 服务商的限流配置
+func (c *Client) limitFor(name string) LimitConf {
+	for key, conf := range c.config.Limits {
+		if strings.EqualFold(key, name) {
+			return conf
+		}
+	}
+
+	return LimitConf{}
+}
+
+// poolFor 服务商的凭证池
+func (c *Client) poolFor(name string) (CredentialPool, bool) {
+	for key, pool := range c.config.Credentials {
+		if strings.EqualFold(key, name) && len(pool.Credentials) > 0 {
+			return pool, true
+		}
+	}
+
+	return CredentialPool{}, false
+}
+
+// retryFor 服务商使用的重试策略, 未单独配置时使用全局配置
+func (c *Client) retryFor(name string) RetryConf {
+	for key, conf := range c.config.ProviderRetry {
+		if strings.EqualFold(key, name) {
+			return conf
+		}
+	}
+
+	return c.config.Retry
+}
+
+// httpFor 服务商使用的请求客户端, 未单独配置时使用全局配置
+func (c *Client) httpFor(name string) *httpScope {
+	if scope, ok := c.providerHttp[strings.ToLower(name)]; ok {
+		return scope
+	}
+
+	return c.http
+}
+
+// Config 当前实例的配置副本
+func (c *Client) Config() Config {
+	return c.config
+}
+
+// NewServer 按实现ID实例化服务
+func (c *Client) NewServer(implementId int8) (*Server, error) {
+	item, ok := lookupId(implementId)
+	if !ok {
+		return nil, ErrorNoImplement
+	}
+
+	return item.newServer(c)
+}
+
+// NewServerByName 按名称实例化服务, 名称不区分大小写
+// Config.OpenAICompatible 中存在同名配置时优先使用该配置(如通过代理接入 deepseek), 否则使用注册的服务商
+func (c *Client) NewServerByName(name string) (*Server, error) {
+	for _, conf := range c.config.OpenAICompatible {
+		if strings.EqualFold(conf.Name, name) {
+			return c.NewOpenAICompatibleServer(name)
+		}
+	}
+
+	if item, ok := lookupName(name); ok {
+		return item.newServer(c)
+	}
+
+	return nil, ErrorNoImplement
+}
+
+// NewOpenAICompatibleServer 按名称实例化 Config.OpenAICompatible 中配置的 OpenAI 兼容服务
+func (c *Client) NewOpenAICompatibleServer(name string) (*Server, error) {
+	for _, conf := range c.config.OpenAICompatible {
+		if !strings.EqualFold(conf.Name, name) {
+			continue
+		}
+		if len(conf.Url) == 0 {
+			return nil, ErrorNoConfig
+		}
+
+		return c.newServer(ImplementOpenAICompatible, conf.Name, func(credential *Credential) (Provider, error) {
+			conf := conf
+			if credential != nil {
+				conf.Url = credential.url(conf.Url)
+				conf.Key = credential.Key
+			}
+			return newOpenAICompatibleServer(conf), nil
+		})
+	}
+
+	return nil, ErrorNoConfig
+}
+
+// standaloneClient 未绑定 Client 的服务商使用的兜底实例
+var standaloneClient = newClient(Config{})
+
+// clientScope 内置服务商嵌入该结构, 实例化时绑定所属 Client, 请求及 token 缓存均走所属 Client
+type clientScope struct {
+	client *Client
+	http   *httpScope
+	name   string
+}
+
+func (c *clientScope) bindClient(client *Client, name string) {
+	c.client = client
+	c.http = client.httpFor(name)
+	c.name = name
+}
+
+func (c *clientScope) instance() *Client {
+	if c.client == nil {
+		return standaloneClient
+	}
+
+	return c.client
+}
+
+func (c *clientScope) post(ctx context.Context, url string, payload string, headers map[string]string) (*http.Response, error) {
+	scope := c.http
+	if scope == nil {
+		scope = c.instance().http
+	}
+
+	ctx, span := c.instance().telemetry.httpSpan(ctx, http.MethodPost, url)
+	response, err := postBase(ctx, scope, url, payload, headers)
+	endHttpSpan(span, response, redactError(err))
+	if err != nil && ctx.Err() == nil {
+		return nil, newTransportError(c.name, err)
+	}
+
+	return response, redactError(err)
+}
+
+// clientBinder 需要绑定 Client 的服务商
+type clientBinder interface {
+	bindClient(client *Client, name string)
+}
+
+type cachedToken struct {
+	token  string
+	expire int64
+}
+
+// tokenCache 鉴权 token 缓存, 按凭证区分; 同一凭证同时只发起一次获取, 获取期间不阻塞其他凭证
+type tokenCache struct {
+	lock     sync.Mutex
+	items    map[string]cachedToken
+	fetching map[string]*tokenFetch
+}
+
+// tokenFetch 进行中的 token 获取, 结束后关闭 done
+type tokenFetch struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// get 读取未过期的 token, 不存在或已过期时调用 fetch 重新获取, fetch 返回 token 及过期时间戳(秒)
+// 同一凭证已在获取时等待其结果, 等待可通过 ctx 取消; 发起方被取消时由等待方重新获取
+func (t *tokenCache) get(ctx context.Context, key string, now int64, fetch func() (string, int64, error)) (string, error) {
+	for {
+		t.lock.Lock()
+		if item, ok := t.items[key]; ok && now < item.expire {
+			t.lock.Unlock()
+			return item.token, nil
+		}
+
+		if call, ok := t.fetching[key]; ok {
+			t.lock.Unlock()
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+			if errors.Is(call.err, context.Canceled) || errors.Is(call.err, context.DeadlineExceeded) {
+				continue
+			}
+			return call.token, call.err
+		}
+
+		call := &tokenFetch{done: make(chan struct{})}
+		if t.fetching == nil {
+			t.fetching = make(map[string]*tokenFetch)
+		}
+		t.fetching[key] = call
+		t.lock.Unlock()
+
+		token, expire, err := fetch()
+		call.token, call.err = token, err
+
+		t.lock.Lock()
+		if err == nil {
+			t.items[key] = cachedToken{token: token, expire: expire}
+		}
+		delete(t.fetching, key)
+		t.lock.Unlock()
+		close(call.done)
+
+		return token, err
+	}
+}
+
+func (t *tokenCache) remove(key string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.items, key)
+}
@@ -0,0 +1,103 @@
+package pkg_ai
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenCacheFetchOnce(t *testing.T) {
+	cache := &tokenCache{items: make(map[string]cachedToken)}
+	var fetches int32
+
+	var wait sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			token, err := cache.get(context.Background(), "a", time.Now().Unix(), func() (string, int64, error) {
+				atomic.AddInt32(&fetches, 1)
+				time.Sleep(20 * time.Millisecond)
+				return "token-a", time.Now().Unix() + 60, nil
+			})
+			if err != nil || token != "token-a" {
+				t.Errorf("token = %q, err = %v", token, err)
+			}
+		}()
+	}
+	wait.Wait()
+
+	if fetches != 1 {
+		t.Errorf("fetches = %d, want 1", fetches)
+	}
+}
+
+func TestTokenCacheIndependentKeys(t *testing.T) {
+	cache := &tokenCache{items: make(map[string]cachedToken)}
+	started, unblock := make(chan struct{}), make(chan struct{})
+	defer close(unblock)
+
+	go func() {
+		_, _ = cache.get(context.Background(), "slow", time.Now().Unix(), func() (string, int64, error) {
+			close(started)
+			<-unblock
+			return "token-slow", time.Now().Unix() + 60, nil
+		})
+	}()
+	<-started
+
+	// 其他凭证不受进行中的获取影响
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		token, err := cache.get(context.Background(), "fast", time.Now().Unix(), func() (string, int64, error) {
+			return "token-fast", time.Now().Unix() + 60, nil
+		})
+		if err != nil || token != "token-fast" {
+			t.Errorf("token = %q, err = %v", token, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("fast key blocked by slow fetch")
+	}
+
+	// 等待同一凭证的获取可通过 ctx 取消
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := cache.get(ctx, "slow", time.Now().Unix(), func() (string, int64, error) {
+		t.Error("fetch called while another fetch is in flight")
+		return "", 0, nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestTokenCacheError(t *testing.T) {
+	cache := &tokenCache{items: make(map[string]cachedToken)}
+	failure := errors.New("oauth failed")
+
+	if _, err := cache.get(context.Background(), "a", time.Now().Unix(), func() (string, int64, error) {
+		return "", 0, failure
+	}); !errors.Is(err, failure) {
+		t.Fatalf("err = %v", err)
+	}
+
+	// 失败结果不缓存, 下次重新获取
+	token, err := cache.get(context.Background(), "a", time.Now().Unix(), func() (string, int64, error) {
+		return "token-a", time.Now().Unix() + 60, nil
+	})
+	if err != nil || token != "token-a" {
+		t.Errorf("token = %q, err = %v", token, err)
+	}
+
+	cache.remove("a")
+	if _, ok := cache.items["a"]; ok {
+		t.Error("token not removed")
+	}
+}
@@ -5,4 +5,5 @@ const (
 	MessageSystem    = "system"
 	MessageUSer      = "user"
 	MessageAssistant = "assistant"
+	MessageTool      = "tool"
 )
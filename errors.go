@@ -0,0 +1,342 @@
+package pkg_ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxErrorBody    = 64 << 10 // 读取错误响应体的上限
+	maxErrorSnippet = 512      // 错误信息中附带的响应内容长度上限
+)
+
+type ErrorCategory string
+
+const (
+	CategoryAuth           ErrorCategory = "auth"            // 鉴权失败、无权限
+	CategoryRateLimit      ErrorCategory = "rate_limit"      // 上游限流
+	CategoryQuota          ErrorCategory = "quota"           // 额度不足、欠费
+	CategoryInvalidRequest ErrorCategory = "invalid_request" // 请求参数错误
+	CategoryContentFilter  ErrorCategory = "content_filter"  // 内容安全拦截
+	CategoryContextLength  ErrorCategory = "context_length"  // 超出上下文长度
+	CategoryServer         ErrorCategory = "server"          // 服务端错误、无有效响应
+	CategoryTimeout        ErrorCategory = "timeout"         // 请求超时
+	CategoryNetwork        ErrorCategory = "network"         // 连接失败等网络错误
+	CategoryUnknown        ErrorCategory = "unknown"
+)
+
+// 按错误分类判断, 如 errors.Is(err, pkg_ai.ErrorQuota)
+var (
+	ErrorAuth           = errors.New("鉴权失败")
+	ErrorRateLimit      = errors.New("上游限流")
+	ErrorQuota          = errors.New("额度不足")
+	ErrorInvalidRequest = errors.New("请求参数错误")
+	ErrorContentFilter  = errors.New("内容安全拦截")
+	ErrorContextLength  = errors.New("超出上下文长度")
+	ErrorServer         = errors.New("服务端错误")
+	ErrorTimeout        = errors.New("请求超时")
+	ErrorNetwork        = errors.New("网络错误")
+)
+
+var categoryErrors = map[ErrorCategory]error{
+	CategoryAuth:           ErrorAuth,
+	CategoryRateLimit:      ErrorRateLimit,
+	CategoryQuota:          ErrorQuota,
+	CategoryInvalidRequest: ErrorInvalidRequest,
+	CategoryContentFilter:  ErrorContentFilter,
+	CategoryContextLength:  ErrorContextLength,
+	CategoryServer:         ErrorServer,
+	CategoryTimeout:        ErrorTimeout,
+	CategoryNetwork:        ErrorNetwork,
+}
+
+// APIError 上游服务返回的错误, 可通过 errors.As 获取详细信息
+type APIError struct {
+	Provider   string        `json:"provider"`    // 服务商名称
+	StatusCode int           `json:"status_code"` // HTTP 状态码, 网络错误时为 0
+	Code       string        `json:"code"`        // 厂商错误码
+	Message    string        `json:"message"`     // 厂商错误信息
+	RequestId  string        `json:"request_id"`  // 请求唯一ID
+	Category   ErrorCategory `json:"category"`    // 错误分类
+
+	retryable  bool
+	retryAfter time.Duration
+	err        error
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.err
+}
+
+// Is 按错误分类匹配 ErrorAuth、ErrorQuota 等
+func (e *APIError) Is(target error) bool {
+	sentinel, ok := categoryErrors[e.Category]
+	return ok && sentinel == target
+}
+
+// Retryable 是否为可重试的错误(限流、服务端错误、网络错误等)
+func (e *APIError) Retryable() bool {
+	return e.retryable
+}
+
+// newAPIError 根据响应及厂商错误码构建错误, retryable 为空时按 HTTP 状态码判断是否可重试
+func newAPIError(supplier string, response *http.Response, requestId, code, message string, retryable func(status int, code string) bool) *APIError {
+	e := &APIError{Provider: supplier, Code: code, Message: message, RequestId: requestId}
+	if response != nil {
+		e.StatusCode = response.StatusCode
+		e.retryAfter = parseRetryAfter(response.Header.Get("Retry-After"))
+		if len(e.RequestId) == 0 {
+			e.RequestId = headerRequestId(response.Header)
+		}
+	}
+
+	if retryable == nil {
+		retryable = retryableStatus
+	}
+	e.retryable = retryable(e.StatusCode, code)
+	e.Category = categorize(supplier, e.StatusCode, code, message)
+
+	return e
+}
+
+// newEmptyError 响应中没有有效数据
+func newEmptyError(supplier string, response *http.Response, requestId string) *APIError {
+	e := newAPIError(supplier, response, requestId, "", "无有效响应数据", func(int, string) bool { return false })
+	e.Category = CategoryServer
+
+	return e
+}
+
+// newContentFilterError 内容被安全策略拦截
+func newContentFilterError(supplier string, response *http.Response, requestId, code, message string) *APIError {
+	e := newAPIError(supplier, response, requestId, code, message, nil)
+	e.Category = CategoryContentFilter
+
+	return e
+}
+
+// newDecodeError 响应无法解析(如网关返回的 HTML 页面), 错误信息中附带截断后的响应内容
+func newDecodeError(supplier string, response *http.Response, requestId string, body []byte, err error) *APIError {
+	e := newAPIError(supplier, response, requestId, "", "响应解析失败: "+err.Error()+", 响应内容: "+snippet(body), nil)
+	e.err = err
+	if e.Category = categorize(supplier, e.StatusCode, "", ""); e.Category == CategoryUnknown {
+		e.Category = CategoryServer
+	}
+
+	return e
+}
+
+// statusError 校验 HTTP 状态码, 非 2xx 时按服务商的错误结构解析, 无法解析时返回附带响应内容的错误
+func statusError(supplier string, response *http.Response, body []byte, parse func(response *http.Response, body []byte) *APIError) error {
+	if response.StatusCode >= http.StatusOK && response.StatusCode < http.StatusMultipleChoices {
+		return nil
+	}
+
+	if parse != nil {
+		if err := parse(response, body); err != nil {
+			return err
+		}
+	}
+
+	return newAPIError(supplier, response, "", "", "HTTP "+response.Status+", 响应内容: "+snippet(body), nil)
+}
+
+// readError 流式请求在读取前校验 HTTP 状态码, 非 2xx 时读取响应体构建错误
+func readError(supplier string, response *http.Response, parse func(response *http.Response, body []byte) *APIError) error {
+	if response.StatusCode >= http.StatusOK && response.StatusCode < http.StatusMultipleChoices {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(response.Body, maxErrorBody))
+	return statusError(supplier, response, body, parse)
+}
+
+// snippet 截断响应内容用于错误信息
+func snippet(body []byte) string {
+	text := strings.TrimSpace(string(body))
+	if len(text) > maxErrorSnippet {
+		text = strings.ToValidUTF8(text[:maxErrorSnippet], "") + "..."
+	}
+
+	return text
+}
+
+// newTransportError 网络层错误(连接失败、超时等), 均可重试
+func newTransportError(supplier string, err error) *APIError {
+	err = redactError(err)
+	e := &APIError{Provider: supplier, Message: err.Error(), Category: CategoryNetwork, retryable: true, err: err}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		e.Category = CategoryTimeout
+	}
+
+	return e
+}
+
+// headerRequestId 从响应头中获取请求ID
+func headerRequestId(header http.Header) string {
+	for _, key := range []string{"X-Request-Id", "Request-Id", "X-Tc-Requestid", "X-Acs-Request-Id", "X-Tt-Logid", "Msh-Request-Id"} {
+		if value := header.Get(key); len(value) > 0 {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// vendorCategories 各服务商数字错误码对应的分类, 字符串类的错误码按关键字判断
+var vendorCategories = map[string]map[string]ErrorCategory{
+	"baidubce": {
+		"2": CategoryServer, "4": CategoryRateLimit, "6": CategoryAuth, "17": CategoryQuota, "18": CategoryRateLimit, "19": CategoryQuota,
+		"110": CategoryAuth, "111": CategoryAuth, "336003": CategoryInvalidRequest, "336007": CategoryContextLength,
+		"336100": CategoryServer, "336103": CategoryContextLength, "336501": CategoryRateLimit, "336502": CategoryRateLimit,
+	},
+	"bigmodel": {
+		"1000": CategoryAuth, "1001": CategoryAuth, "1002": CategoryAuth, "1003": CategoryAuth, "1004": CategoryAuth,
+		"1113": CategoryQuota, "1210": CategoryInvalidRequest, "1214": CategoryInvalidRequest, "1261": CategoryContextLength,
+		"1301": CategoryContentFilter, "1302": CategoryRateLimit, "1303": CategoryRateLimit, "1305": CategoryRateLimit, "500": CategoryServer,
+	},
+	"minimaxi": {
+		"1001": CategoryTimeout, "1002": CategoryRateLimit, "1004": CategoryAuth, "1008": CategoryQuota, "1013": CategoryServer,
+		"1026": CategoryContentFilter, "1027": CategoryContentFilter, "1039": CategoryRateLimit, "2013": CategoryInvalidRequest, "2049": CategoryAuth,
+	},
+	"xfyun": {
+		"10013": CategoryContentFilter, "10014": CategoryContentFilter, "10019": CategoryContentFilter, "10163": CategoryInvalidRequest,
+		"10907": CategoryContextLength, "11200": CategoryAuth, "11201": CategoryQuota, "11202": CategoryRateLimit, "11203": CategoryRateLimit,
+	},
+}
+
+// categoryKeywords 按错误码及错误信息中的关键字分类, 按顺序匹配
+var categoryKeywords = []struct {
+	category ErrorCategory
+	keywords []string
+}{
+	{CategoryContextLength, []string{"context_length", "context length", "maximum context", "too long", "token limit", "exceeds the maximum", "长度超"}},
+	{CategoryContentFilter, []string{"content_filter", "content_policy", "data_inspection", "datainspection", "inappropriate", "sensitive", "safety", "敏感", "不安全"}},
+	{CategoryQuota, []string{"quota", "insufficient", "balance", "arrear", "billing", "欠费", "余额"}},
+	{CategoryAuth, []string{"invalid_api_key", "invalidapikey", "authentication", "unauthenticated", "unauthorized", "authfailure", "permission", "鉴权"}},
+	{CategoryRateLimit, []string{"rate_limit", "ratelimit", "limitexceeded", "limit_requests", "throttling", "too many", "resource_exhausted", "限流"}},
+	{CategoryTimeout, []string{"timeout", "deadline_exceeded", "超时"}},
+	{CategoryServer, []string{"server_error", "internal", "overloaded", "unavailable", "engineservererror"}},
+}
+
+// invalidKeywords 请求参数错误的关键字较宽泛, 在 HTTP 状态码之后判断
+var invalidKeywords = []string{"invalid", "bad_request", "参数"}
+
+// categorize 错误分类, 依次按厂商错误码、关键字、HTTP 状态码判断
+func categorize(supplier string, status int, code, message string) ErrorCategory {
+	if category, ok := vendorCategories[strings.ToLower(supplier)][code]; ok {
+		return category
+	}
+
+	lower := strings.ToLower(code + " " + message)
+	for _, item := range categoryKeywords {
+		for _, keyword := range item.keywords {
+			if strings.Contains(lower, keyword) {
+				return item.category
+			}
+		}
+	}
+
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return CategoryAuth
+	case status == http.StatusPaymentRequired:
+		return CategoryQuota
+	case status == http.StatusTooManyRequests:
+		return CategoryRateLimit
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		return CategoryTimeout
+	case status == http.StatusRequestEntityTooLarge:
+		return CategoryContextLength
+	case status >= http.StatusInternalServerError:
+		return CategoryServer
+	}
+
+	for _, keyword := range invalidKeywords {
+		if strings.Contains(lower, keyword) {
+			return CategoryInvalidRequest
+		}
+	}
+	if status >= http.StatusBadRequest {
+		return CategoryInvalidRequest
+	}
+
+	return CategoryUnknown
+}
+
+// retryableStatus 限流(429)及服务端错误(5xx)可重试
+func retryableStatus(status int, _ string) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// openAIRetryable OpenAI 风格的错误码, 额度不足同样返回 429, 不重试
+func openAIRetryable(status int, code string) bool {
+	lower := strings.ToLower(code)
+	for _, keyword := range []string{"quota", "insufficient", "balance", "arrearage"} {
+		if strings.Contains(lower, keyword) {
+			return false
+		}
+	}
+	for _, keyword := range []string{"rate_limit", "ratelimit", "overloaded", "server_error", "timeout", "throttling", "internal", "limit_requests"} {
+		if strings.Contains(lower, keyword) {
+			return true
+		}
+	}
+
+	return retryableStatus(status, code)
+}
+
+// errorCode 取第一个非空的错误码, 兼容数字、字符串等格式
+func errorCode(codes ...interface{}) string {
+	for _, code := range codes {
+		switch value := code.(type) {
+		case nil:
+			continue
+		case string:
+			if len(value) > 0 {
+				return value
+			}
+		case float64:
+			return strconv.FormatFloat(value, 'f', -1, 64)
+		case int64:
+			if value != 0 {
+				return strconv.FormatInt(value, 10)
+			}
+		default:
+			return fmt.Sprint(value)
+		}
+	}
+
+	return ""
+}
+
+// parseRetryAfter 解析 Retry-After 响应头, 支持秒数及 HTTP 日期格式
+func parseRetryAfter(value string) time.Duration {
+	if len(value) == 0 {
+		return 0
+	}
+
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+
+	return 0
+}
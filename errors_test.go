@@ -0,0 +1,241 @@
+package pkg_ai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func newHttpResponse(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestCategorize(t *testing.T) {
+	cases := []struct {
+		supplier string
+		status   int
+		code     string
+		message  string
+		want     ErrorCategory
+	}{
+		// 厂商错误码
+		{"baidubce", 200, "110", "Access token invalid or no longer valid", CategoryAuth},
+		{"baidubce", 200, "336103", "", CategoryContextLength},
+		{"baidubce", 200, "18", "Open api qps request limit reached", CategoryRateLimit},
+		{"bigmodel", 429, "1113", "", CategoryQuota},
+		{"bigmodel", 400, "1301", "", CategoryContentFilter},
+		{"minimaxi", 200, "1002", "", CategoryRateLimit},
+		{"minimaxi", 200, "1008", "", CategoryQuota},
+		{"minimaxi", 200, "1039", "", CategoryRateLimit},
+		{"Minimaxi", 200, "1004", "", CategoryAuth},
+		{"xfyun", 200, "11202", "", CategoryRateLimit},
+		{"xfyun", 200, "11201", "", CategoryQuota},
+		{"xfyun", 200, "10013", "", CategoryContentFilter},
+		// 关键字优先于状态码
+		{"chatGpt", 429, "insufficient_quota", "You exceeded your current quota", CategoryQuota},
+		{"chatGpt", 400, "context_length_exceeded", "", CategoryContextLength},
+		{"qwen", 400, "DataInspectionFailed", "", CategoryContentFilter},
+		{"hunyuan", 200, "AuthFailure.SignatureFailure", "", CategoryAuth},
+		{"hunyuan", 200, "LimitExceeded", "", CategoryRateLimit},
+		{"gemini", 429, "RESOURCE_EXHAUSTED", "", CategoryRateLimit},
+		{"gemini", 504, "DEADLINE_EXCEEDED", "", CategoryTimeout},
+		// HTTP 状态码
+		{"deepSeek", 401, "", "", CategoryAuth},
+		{"deepSeek", 403, "", "", CategoryAuth},
+		{"deepSeek", 402, "", "", CategoryQuota},
+		{"deepSeek", 429, "", "", CategoryRateLimit},
+		{"deepSeek", 504, "", "", CategoryTimeout},
+		{"deepSeek", 413, "", "", CategoryContextLength},
+		{"deepSeek", 502, "", "<html>Bad Gateway</html>", CategoryServer},
+		{"deepSeek", 422, "", "", CategoryInvalidRequest},
+		{"deepSeek", 200, "", "参数错误", CategoryInvalidRequest},
+		{"deepSeek", 200, "", "", CategoryUnknown},
+		// 厂商错误码仅对所属厂商生效
+		{"deepSeek", 200, "1008", "", CategoryUnknown},
+	}
+
+	for _, item := range cases {
+		if got := categorize(item.supplier, item.status, item.code, item.message); got != item.want {
+			t.Errorf("categorize(%s, %d, %q, %q) = %s, want %s", item.supplier, item.status, item.code, item.message, got, item.want)
+		}
+	}
+}
+
+func TestRetryableTables(t *testing.T) {
+	cases := []struct {
+		name      string
+		retryable func(status int, code string) bool
+		status    int
+		code      string
+		want      bool
+	}{
+		{"status", retryableStatus, 429, "", true},
+		{"status", retryableStatus, 503, "", true},
+		{"status", retryableStatus, 400, "", false},
+		{"openai", openAIRetryable, 429, "insufficient_quota", false},
+		{"openai", openAIRetryable, 429, "rate_limit_exceeded", true},
+		{"openai", openAIRetryable, 200, "server_error", true},
+		{"openai", openAIRetryable, 400, "invalid_request_error", false},
+		{"minimaxi", minimaxiRetryable, 200, "1002", true},
+		{"minimaxi", minimaxiRetryable, 200, "1039", true},
+		{"minimaxi", minimaxiRetryable, 200, "1008", false},
+		{"minimaxi", minimaxiRetryable, 200, "1004", false},
+		{"xfyun", xfYunRetryable, 200, "11202", true},
+		{"xfyun", xfYunRetryable, 200, "11203", true},
+		{"xfyun", xfYunRetryable, 200, "11201", false},
+		{"xfyun", xfYunRetryable, 429, "insufficient_quota", false},
+		{"glm", glmRetryable, 200, "1302", true},
+		{"glm", glmRetryable, 200, "1113", false},
+		{"hunyuan", hunyuanRetryable, 200, "LimitExceeded.Qps", true},
+		{"hunyuan", hunyuanRetryable, 200, "AuthFailure", false},
+		{"gemini", geminiRetryable, 400, "RESOURCE_EXHAUSTED", true},
+		{"gemini", geminiRetryable, 400, "INVALID_ARGUMENT", false},
+	}
+
+	for _, item := range cases {
+		if got := item.retryable(item.status, item.code); got != item.want {
+			t.Errorf("%s(%d, %q) = %v, want %v", item.name, item.status, item.code, got, item.want)
+		}
+	}
+}
+
+func TestAPIErrorIs(t *testing.T) {
+	sentinels := map[ErrorCategory]error{
+		CategoryAuth:           ErrorAuth,
+		CategoryRateLimit:      ErrorRateLimit,
+		CategoryQuota:          ErrorQuota,
+		CategoryInvalidRequest: ErrorInvalidRequest,
+		CategoryContentFilter:  ErrorContentFilter,
+		CategoryContextLength:  ErrorContextLength,
+		CategoryServer:         ErrorServer,
+		CategoryTimeout:        ErrorTimeout,
+		CategoryNetwork:        ErrorNetwork,
+	}
+
+	for category, sentinel := range sentinels {
+		err := fmt.Errorf("wrapped: %w", &APIError{Category: category})
+		if !errors.Is(err, sentinel) {
+			t.Errorf("errors.Is(%s, %v) = false", category, sentinel)
+		}
+		for other, otherSentinel := range sentinels {
+			if other != category && errors.Is(err, otherSentinel) {
+				t.Errorf("errors.Is(%s, %v) = true", category, otherSentinel)
+			}
+		}
+	}
+
+	if errors.Is(&APIError{Category: CategoryUnknown}, ErrorServer) {
+		t.Error("unknown category matches ErrorServer")
+	}
+}
+
+func TestNewAPIErrorFromResponse(t *testing.T) {
+	response := newHttpResponse(http.StatusTooManyRequests, "")
+	response.Header.Set("Retry-After", "3")
+	response.Header.Set("X-Request-Id", "req-1")
+
+	err := newAPIError("deepSeek", response, "", "", "Too Many Requests", nil)
+	if err.StatusCode != 429 || err.RequestId != "req-1" || err.Category != CategoryRateLimit || !err.Retryable() || err.retryAfter != 3*time.Second {
+		t.Errorf("err = %+v", err)
+	}
+
+	// 响应体中的请求ID优先
+	if err := newAPIError("deepSeek", response, "body-id", "", "", nil); err.RequestId != "body-id" {
+		t.Errorf("request id = %s", err.RequestId)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	if err := statusError("deepSeek", newHttpResponse(http.StatusOK, ""), nil, nil); err != nil {
+		t.Errorf("2xx err = %v", err)
+	}
+
+	html := "<html><head><title>502 Bad Gateway</title></head><body><center><h1>502 Bad Gateway</h1></center><hr><center>nginx</center></body></html>"
+	parsed := false
+	err := statusError("deepSeek", newHttpResponse(http.StatusBadGateway, html), []byte(html), func(response *http.Response, body []byte) *APIError {
+		// 无法按厂商结构解析时返回 nil
+		parsed = true
+		return nil
+	})
+	apiErr := &APIError{}
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want *APIError", err)
+	}
+	if !parsed || apiErr.StatusCode != 502 || apiErr.Category != CategoryServer || !apiErr.Retryable() || !errors.Is(err, ErrorServer) {
+		t.Errorf("err = %+v", apiErr)
+	}
+	if !strings.Contains(apiErr.Message, "HTTP 502 Bad Gateway") || !strings.Contains(apiErr.Message, "<title>502 Bad Gateway</title>") {
+		t.Errorf("message = %s", apiErr.Message)
+	}
+
+	// 厂商结构解析成功时使用解析结果
+	vendor := &APIError{Code: "invalid_api_key", Category: CategoryAuth}
+	err = statusError("deepSeek", newHttpResponse(http.StatusUnauthorized, "{}"), []byte("{}"), func(*http.Response, []byte) *APIError {
+		return vendor
+	})
+	if err != vendor {
+		t.Errorf("err = %v, want vendor error", err)
+	}
+}
+
+func TestReadError(t *testing.T) {
+	if err := readError("deepSeek", newHttpResponse(http.StatusOK, "data: {}"), nil); err != nil {
+		t.Errorf("2xx err = %v", err)
+	}
+
+	body := strings.Repeat("错误页面", 200)
+	err := readError("deepSeek", newHttpResponse(http.StatusServiceUnavailable, body), nil)
+	apiErr := &APIError{}
+	if !errors.As(err, &apiErr) || apiErr.Category != CategoryServer {
+		t.Fatalf("err = %v", err)
+	}
+	// 响应内容截断后仍为合法 UTF-8
+	if !strings.HasSuffix(apiErr.Message, "...") || !utf8.ValidString(apiErr.Message) || len(apiErr.Message) > maxErrorSnippet+64 {
+		t.Errorf("message = %s", apiErr.Message)
+	}
+}
+
+func TestNewDecodeError(t *testing.T) {
+	body := []byte("<html><body>Service Unavailable</body></html>")
+	_, cause := json.Marshal(make(chan int))
+	if cause == nil {
+		t.Fatal("want marshal error")
+	}
+
+	err := newDecodeError("deepSeek", newHttpResponse(http.StatusOK, ""), "req-1", body, cause)
+	if err.Category != CategoryServer || err.RequestId != "req-1" || !errors.Is(err, cause) {
+		t.Errorf("err = %+v", err)
+	}
+	if !strings.Contains(err.Message, "Service Unavailable") {
+		t.Errorf("message = %s", err.Message)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	cases := []struct {
+		codes []interface{}
+		want  string
+	}{
+		{[]interface{}{nil, "", "invalid_api_key"}, "invalid_api_key"},
+		{[]interface{}{float64(1002)}, "1002"},
+		{[]interface{}{int64(0), int64(110)}, "110"},
+		{[]interface{}{nil}, ""},
+	}
+
+	for _, item := range cases {
+		if got := errorCode(item.codes...); got != item.want {
+			t.Errorf("errorCode(%v) = %q, want %q", item.codes, got, item.want)
+		}
+	}
+}
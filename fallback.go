@@ -0,0 +1,188 @@
+package pkg_ai
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// FallbackTarget 降级链中的一个服务, ImplementId 与 Name 二选一
+type FallbackTarget struct {
+	ImplementId int8   `json:"implement_id"` // 实现ID
+	Name        string `json:"name"`         // 注册名称, 设置后忽略 ImplementId
+	Model       string `json:"model"`        // 使用的模型, 为空时沿用 RequestData.Model
+}
+
+// FallbackServer 多服务商降级链, 按顺序请求, 遇到可重试的错误(限流、服务端错误、网络错误等)时切换到下一个服务商
+// 每个服务商自身的重试策略仍然生效, 重试耗尽后才会切换; 实际提供服务的服务商记录在 Response.Supplier 中
+type FallbackServer struct {
+	servers []*Server
+	models  []string
+}
+
+// NewFallbackServer 使用默认实例创建降级链
+func NewFallbackServer(targets ...FallbackTarget) (*FallbackServer, error) {
+	client := Default()
+	if client == nil {
+		return nil, ErrorNoInit
+	}
+
+	return client.NewFallbackServer(targets...)
+}
+
+// NewFallbackServer 创建降级链, 任一服务实例化失败时返回错误
+func (c *Client) NewFallbackServer(targets ...FallbackTarget) (*FallbackServer, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("降级链至少需要一个服务")
+	}
+
+	f := &FallbackServer{servers: make([]*Server, 0, len(targets)), models: make([]string, 0, len(targets))}
+	for _, target := range targets {
+		var server *Server
+		var err error
+		if len(target.Name) > 0 {
+			server, err = c.NewServerByName(target.Name)
+		} else {
+			server, err = c.NewServer(target.ImplementId)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		f.servers = append(f.servers, server)
+		f.models = append(f.models, target.Model)
+	}
+
+	return f, nil
+}
+
+// Supplier 降级链中的服务商, 以逗号分隔
+func (f *FallbackServer) Supplier() string {
+	suppliers := make([]string, 0, len(f.servers))
+	for _, server := range f.servers {
+		suppliers = append(suppliers, server.Supplier())
+	}
+
+	return strings.Join(suppliers, ",")
+}
+
+// Servers 降级链中的服务
+func (f *FallbackServer) Servers() []*Server {
+	return f.servers
+}
+
+// Chat 阻塞式对话
+func (f *FallbackServer) Chat(data RequestData) (*Response, error) {
+	return f.ChatContext(context.Background(), data)
+}
+
+// ChatContext 阻塞式对话, ctx 取消或超时后中断请求, 不再切换服务商
+func (f *FallbackServer) ChatContext(ctx context.Context, data RequestData) (*Response, error) {
+	var response *Response
+	var err error
+
+	for index, server := range f.servers {
+		response, err = server.ChatContext(ctx, f.data(index, data))
+		if err == nil || !f.next(ctx, err) {
+			return response, err
+		}
+	}
+
+	return response, err
+}
+
+// ChatStream 流式对话
+func (f *FallbackServer) ChatStream(data RequestData, msgCh chan string, errChan chan error) (*Response, error) {
+	return f.ChatStreamContext(context.Background(), data, msgCh, errChan)
+}
+
+// ChatStreamContext 流式对话, 仅在推送第一段内容之前切换服务商; msgCh、errChan 的处理与 Server.ChatStreamContext 一致
+func (f *FallbackServer) ChatStreamContext(ctx context.Context, data RequestData, msgCh chan string, errChan chan error) (*Response, error) {
+	return timer(func() (*Response, error) {
+		streamTimer := newStreamTimer()
+		calls, err := f.build(data)
+		if err != nil {
+			sendErr(ctx, errChan, err)
+			close(msgCh)
+			return &Response{StreamStats: streamTimer.stats(0)}, err
+		}
+
+		response, err := f.chatStream(ctx, calls, func(msg string) error {
+			return sendMsg(ctx, msgCh, msg)
+		})
+		if err != nil {
+			sendErr(ctx, errChan, err)
+		}
+		close(msgCh)
+
+		return response, err
+	})
+}
+
+// Stream 流式对话, 返回流式响应读取器, 读取结束或中途放弃时需调用 Close
+func (f *FallbackServer) Stream(ctx context.Context, data RequestData) (*Stream, error) {
+	calls, err := f.build(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return newStream(ctx, func(ctx context.Context, handler StreamHandler) (*Response, error) {
+		return f.chatStream(ctx, calls, handler)
+	}), nil
+}
+
+func (f *FallbackServer) chatStream(ctx context.Context, calls []*Call, handler StreamHandler) (*Response, error) {
+	var response *Response
+	var err error
+
+	emitted := false
+	for index, server := range f.servers {
+		calls[index].Handler = func(msg string) error {
+			if len(msg) > 0 {
+				emitted = true
+			}
+			return handler(msg)
+		}
+		response, err = server.stream(ctx, calls[index])
+		if err == nil || emitted || !f.next(ctx, err) {
+			return response, err
+		}
+	}
+
+	return response, err
+}
+
+// build 预先为每个服务商组装请求参数, 参数有误时不发起请求
+func (f *FallbackServer) build(data RequestData) ([]*Call, error) {
+	calls := make([]*Call, 0, len(f.servers))
+	for index, server := range f.servers {
+		call, err := server.newCall(f.data(index, data), true)
+		if err != nil {
+			return nil, err
+		}
+		calls = append(calls, call)
+	}
+
+	return calls, nil
+}
+
+func (f *FallbackServer) data(index int, data RequestData) RequestData {
+	if len(f.models[index]) > 0 {
+		data.Model = f.models[index]
+	}
+
+	return data
+}
+
+// next 判断是否切换到下一个服务商, 熔断中的服务商直接跳过
+func (f *FallbackServer) next(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, ErrorCircuitOpen) {
+		return true
+	}
+
+	ok, _ := retryable(err)
+	return ok
+}
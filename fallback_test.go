@@ -0,0 +1,188 @@
+package pkg_ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+// newFallbackClient 主服务 deepseek 按 primary 返回, 备用服务 moonshot 总是成功并记录请求的模型
+func newFallbackClient(t *testing.T, primary func(r *http.Request) (int, string), options ...Options) (*Client, *int32, *int32, *atomic.Value) {
+	t.Helper()
+
+	var primaryHits, backupHits int32
+	model := &atomic.Value{}
+	deepseek := newDeepSeekUpstream(t, func(r *http.Request) (int, string) {
+		atomic.AddInt32(&primaryHits, 1)
+		return primary(r)
+	})
+	moonshot := newDeepSeekUpstream(t, func(r *http.Request) (int, string) {
+		atomic.AddInt32(&backupHits, 1)
+		body, _ := io.ReadAll(r.Body)
+		request := struct {
+			Model  string `json:"model"`
+			Stream bool   `json:"stream"`
+		}{}
+		_ = json.Unmarshal(body, &request)
+		model.Store(request.Model)
+		if request.Stream {
+			return http.StatusOK, deepSeekStreamReply
+		}
+		return http.StatusOK, deepSeekReply
+	})
+
+	options = append([]Options{WithDeepSeekConfig(deepseek.URL, "sk"), WithMoonshotConfig(moonshot.URL, "sk")}, options...)
+	return NewClient(&Config{}, options...), &primaryHits, &backupHits, model
+}
+
+func unavailable(r *http.Request) (int, string) {
+	return http.StatusServiceUnavailable, `{"error":{"message":"busy","type":"error"}}`
+}
+
+func TestFallbackFailover(t *testing.T) {
+	client, primaryHits, backupHits, model := newFallbackClient(t, unavailable)
+	fallback, err := client.NewFallbackServer(FallbackTarget{ImplementId: ImplementDeepSeek}, FallbackTarget{Name: "moonshot", Model: "moonshot-v1-8k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if supplier := fallback.Supplier(); supplier != "deepSeek,moonshot" {
+		t.Errorf("supplier = %s", supplier)
+	}
+
+	response, err := fallback.Chat(RequestData{Model: "deepseek-chat", UserQuery: "你好"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Supplier != "moonshot" || response.ResponseText != "你好" {
+		t.Errorf("response = %+v", response)
+	}
+	if *primaryHits != 1 || *backupHits != 1 {
+		t.Errorf("hits = %d, %d", *primaryHits, *backupHits)
+	}
+	if model.Load() != "moonshot-v1-8k" {
+		t.Errorf("model = %v, want moonshot-v1-8k", model.Load())
+	}
+}
+
+func TestFallbackPrimarySucceeds(t *testing.T) {
+	client, _, backupHits, _ := newFallbackClient(t, func(r *http.Request) (int, string) {
+		return http.StatusOK, deepSeekReply
+	})
+	fallback, err := client.NewFallbackServer(FallbackTarget{Name: "deepseek"}, FallbackTarget{Name: "moonshot"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := fallback.Chat(RequestData{Model: "deepseek-chat", UserQuery: "你好"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Supplier != "deepSeek" || *backupHits != 0 {
+		t.Errorf("supplier = %s, backup hits = %d", response.Supplier, *backupHits)
+	}
+}
+
+func TestFallbackStopsOnInvalidRequest(t *testing.T) {
+	client, primaryHits, backupHits, _ := newFallbackClient(t, func(r *http.Request) (int, string) {
+		return http.StatusBadRequest, `{"error":{"message":"invalid messages","type":"invalid_request_error"}}`
+	})
+	fallback, err := client.NewFallbackServer(FallbackTarget{Name: "deepseek"}, FallbackTarget{Name: "moonshot"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fallback.Chat(RequestData{Model: "deepseek-chat", UserQuery: "你好"})
+	apiErr := &APIError{}
+	if !errors.As(err, &apiErr) || apiErr.Category != CategoryInvalidRequest {
+		t.Errorf("err = %v", err)
+	}
+	if *primaryHits != 1 || *backupHits != 0 {
+		t.Errorf("hits = %d, %d", *primaryHits, *backupHits)
+	}
+}
+
+func TestFallbackSkipsOpenBreaker(t *testing.T) {
+	client, primaryHits, backupHits, _ := newFallbackClient(t, unavailable, WithBreaker("deepseek", BreakerConf{MinRequests: 1}))
+	primary, err := client.NewServer(ImplementDeepSeek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := primary.Chat(RequestData{Model: "deepseek-chat", UserQuery: "你好"}); err == nil {
+		t.Fatal("want error")
+	}
+	if primary.BreakerState() != BreakerOpen {
+		t.Fatalf("state = %s, want open", primary.BreakerState())
+	}
+
+	fallback, err := client.NewFallbackServer(FallbackTarget{Name: "deepseek"}, FallbackTarget{Name: "moonshot"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := fallback.Chat(RequestData{Model: "deepseek-chat", UserQuery: "你好"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Supplier != "moonshot" {
+		t.Errorf("supplier = %s", response.Supplier)
+	}
+	if *primaryHits != 1 || *backupHits != 1 {
+		t.Errorf("hits = %d, %d", *primaryHits, *backupHits)
+	}
+}
+
+func TestFallbackAllFail(t *testing.T) {
+	client, _, _, _ := newFallbackClient(t, unavailable)
+	fallback, err := client.NewFallbackServer(FallbackTarget{Name: "deepseek"}, FallbackTarget{Name: "deepseek"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fallback.Chat(RequestData{Model: "deepseek-chat", UserQuery: "你好"}); !errors.Is(err, ErrorServer) {
+		t.Errorf("err = %v, want ErrorServer", err)
+	}
+	if _, err := client.NewFallbackServer(); err == nil {
+		t.Error("want error for empty chain")
+	}
+	if _, err := client.NewFallbackServer(FallbackTarget{Name: "not-registered"}); !errors.Is(err, ErrorNoImplement) {
+		t.Errorf("err = %v, want ErrorNoImplement", err)
+	}
+}
+
+func TestFallbackStream(t *testing.T) {
+	client, primaryHits, backupHits, _ := newFallbackClient(t, unavailable)
+	fallback, err := client.NewFallbackServer(FallbackTarget{Name: "deepseek"}, FallbackTarget{Name: "moonshot"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := fallback.Stream(context.Background(), RequestData{Model: "deepseek-chat", UserQuery: "你好"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	text := ""
+	for stream.Next() {
+		text += stream.Current()
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if text != "你好!" || stream.Response().Supplier != "moonshot" {
+		t.Errorf("text = %q, supplier = %s", text, stream.Response().Supplier)
+	}
+	if *primaryHits != 1 || *backupHits != 1 {
+		t.Errorf("hits = %d, %d", *primaryHits, *backupHits)
+	}
+
+	// 请求参数有误时不发起请求, 同样返回耗时及流式统计
+	msgCh, errChan := make(chan string), make(chan error, 1)
+	response, err := fallback.ChatStreamContext(context.Background(), RequestData{Model: "deepseek-chat"}, msgCh, errChan)
+	if _, open := <-msgCh; err == nil || open || len(errChan) != 1 || response.StreamStats == nil {
+		t.Errorf("err = %v, response = %+v", err, response)
+	}
+}
@@ -0,0 +1,50 @@
+package pkg_ai
+
+import "strings"
+
+// FinishReason 统一后的结束原因, 服务商返回的原始值记录在 Response.Metadata["finish_reason"] 中
+type FinishReason string
+
+const (
+	FinishStop          FinishReason = "stop"           // 正常结束或命中停止词
+	FinishLength        FinishReason = "length"         // 达到最大 token 数被截断
+	FinishContentFilter FinishReason = "content_filter" // 内容被安全策略拦截或替换
+	FinishToolCalls     FinishReason = "tool_calls"     // 模型发起工具调用
+	FinishOther         FinishReason = "other"          // 其他原因
+)
+
+// normalizeFinishReason 将各服务商的结束原因统一为 FinishReason
+func normalizeFinishReason(raw string) FinishReason {
+	switch strings.ToLower(raw) {
+	case "":
+		return ""
+	case "stop", "normal", "end_turn", "stop_sequence", "eos":
+		return FinishStop
+	case "length", "max_tokens", "model_length":
+		return FinishLength
+	case "content_filter", "sensitive", "safety", "recitation", "blocklist", "prohibited_content", "spii", "image_safety":
+		return FinishContentFilter
+	case "tool_calls", "function_call", "tool_use":
+		return FinishToolCalls
+	}
+
+	return FinishOther
+}
+
+// finish 记录结束原因, 为空时忽略
+func (r *Response) finish(raw string) {
+	if len(raw) == 0 {
+		return
+	}
+
+	r.FinishReason = normalizeFinishReason(raw)
+	r.setMetadata("finish_reason", raw)
+}
+
+// setMetadata 记录服务商特有的响应信息
+func (r *Response) setMetadata(key string, value interface{}) {
+	if r.Metadata == nil {
+		r.Metadata = make(map[string]interface{})
+	}
+	r.Metadata[key] = value
+}
@@ -0,0 +1,214 @@
+package pkg_ai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultConnectTimeout        = 10 * time.Second  // 默认建立连接超时时间
+	DefaultResponseHeaderTimeout = 120 * time.Second // 默认等待响应头超时时间, 阻塞式请求需等待模型生成完毕, 不宜过短
+	DefaultStreamIdleTimeout     = 60 * time.Second  // 默认读取响应体时两次数据之间的最大间隔
+	DefaultMaxIdleConnsPerHost   = 16                // 默认每个域名保持的空闲连接数
+	DefaultIdleConnTimeout       = 90 * time.Second  // 默认空闲连接保持时间
+)
+
+var ErrorStreamIdleTimeout = errors.New("读取响应超时, 上游长时间未返回数据")
+
+// HttpConf HTTP 请求配置, 时间类配置为 0 时使用默认值, 小于 0 时不限制
+type HttpConf struct {
+	Client                *http.Client      `json:"-"`                       // 自定义 http.Client, 设置后 Transport 及连接相关配置不生效
+	Transport             http.RoundTripper `json:"-"`                       // 自定义 Transport, 设置后 Proxy 及连接相关配置不生效
+	Proxy                 string            `json:"proxy"`                   // 代理地址, 如 http://127.0.0.1:8080, 为空时读取 HTTP_PROXY 等环境变量
+	ConnectTimeout        time.Duration     `json:"connect_timeout"`         // 建立连接超时时间
+	ResponseHeaderTimeout time.Duration     `json:"response_header_timeout"` // 发送请求后等待响应头的超时时间
+	StreamIdleTimeout     time.Duration     `json:"stream_idle_timeout"`     // 读取响应体时两次数据之间的最大间隔, 主要用于流式请求
+	MaxIdleConnsPerHost   int               `json:"max_idle_conns_per_host"` // 每个域名保持的空闲连接数
+	IdleConnTimeout       time.Duration     `json:"idle_conn_timeout"`       // 空闲连接保持时间
+}
+
+// merge 以 h 为基础, 使用 o 中的非零值覆盖
+func (h HttpConf) merge(o HttpConf) HttpConf {
+	if o.Client != nil {
+		h.Client = o.Client
+	}
+	if o.Transport != nil {
+		h.Transport = o.Transport
+	}
+	if len(o.Proxy) > 0 {
+		h.Proxy = o.Proxy
+	}
+	if o.ConnectTimeout != 0 {
+		h.ConnectTimeout = o.ConnectTimeout
+	}
+	if o.ResponseHeaderTimeout != 0 {
+		h.ResponseHeaderTimeout = o.ResponseHeaderTimeout
+	}
+	if o.StreamIdleTimeout != 0 {
+		h.StreamIdleTimeout = o.StreamIdleTimeout
+	}
+	if o.MaxIdleConnsPerHost != 0 {
+		h.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+	}
+	if o.IdleConnTimeout != 0 {
+		h.IdleConnTimeout = o.IdleConnTimeout
+	}
+
+	return h
+}
+
+func durationOr(value, def time.Duration) time.Duration {
+	if value == 0 {
+		return def
+	}
+	if value < 0 {
+		return 0
+	}
+
+	return value
+}
+
+// httpScope 按 HttpConf 构建的请求客户端
+type httpScope struct {
+	client      *http.Client
+	idleTimeout time.Duration
+}
+
+func newHttpScope(conf HttpConf) *httpScope {
+	scope := &httpScope{idleTimeout: durationOr(conf.StreamIdleTimeout, DefaultStreamIdleTimeout)}
+
+	if conf.Client != nil {
+		scope.client = conf.Client
+		return scope
+	}
+	if conf.Transport != nil {
+		scope.client = &http.Client{Transport: conf.Transport}
+		return scope
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   durationOr(conf.ConnectTimeout, DefaultConnectTimeout),
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = durationOr(conf.ConnectTimeout, DefaultConnectTimeout)
+	transport.ResponseHeaderTimeout = durationOr(conf.ResponseHeaderTimeout, DefaultResponseHeaderTimeout)
+	transport.IdleConnTimeout = durationOr(conf.IdleConnTimeout, DefaultIdleConnTimeout)
+	transport.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	if conf.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = conf.MaxIdleConnsPerHost
+	}
+
+	if len(conf.Proxy) > 0 {
+		proxy, err := url.Parse(conf.Proxy)
+		if err != nil {
+			// 代理地址有误时所有请求直接失败, 避免绕过代理直连
+			transport.Proxy = func(*http.Request) (*url.URL, error) {
+				return nil, err
+			}
+		} else {
+			transport.Proxy = http.ProxyURL(proxy)
+		}
+	}
+
+	scope.client = &http.Client{Transport: transport}
+	return scope
+}
+
+// do 发送请求, 并为响应体加上读取间隔超时
+func (h *httpScope) do(ctx context.Context, method, requestUrl string, payload io.Reader, headers map[string]string) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	req, err := http.NewRequestWithContext(ctx, method, requestUrl, payload)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	for index, val := range headers {
+		req.Header.Set(index, val)
+	}
+
+	response, err := h.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	response.Body = newIdleBody(response.Body, h.idleTimeout, cancel)
+	return response, nil
+}
+
+// idleBody 单次读取等待上游数据超过 timeout 时中断请求, 读取返回 ErrorStreamIdleTimeout; 调用方处理数据的耗时不计入
+type idleBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	lock    sync.Mutex
+	expired bool
+}
+
+func newIdleBody(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) io.ReadCloser {
+	b := &idleBody{ReadCloser: body, timeout: timeout, cancel: cancel}
+	if timeout > 0 {
+		b.timer = time.AfterFunc(timeout, b.expire)
+		b.timer.Stop()
+	}
+
+	return b
+}
+
+func (b *idleBody) expire() {
+	b.lock.Lock()
+	b.expired = true
+	b.lock.Unlock()
+
+	b.cancel()
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	if b.timer == nil {
+		return b.ReadCloser.Read(p)
+	}
+
+	b.timer.Reset(b.timeout)
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Stop()
+
+	if err != nil {
+		b.lock.Lock()
+		expired := b.expired
+		b.lock.Unlock()
+
+		if expired {
+			return n, ErrorStreamIdleTimeout
+		}
+	}
+
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	err := b.ReadCloser.Close()
+	b.cancel()
+
+	return err
+}
+
+func postBase(ctx context.Context, scope *httpScope, url string, payload string, headers map[string]string) (*http.Response, error) {
+	return scope.do(ctx, http.MethodPost, url, strings.NewReader(payload), headers)
+}
+
+func getBase(ctx context.Context, scope *httpScope, requestUrl string, headers map[string]string) (*http.Response, error) {
+	return scope.do(ctx, http.MethodGet, requestUrl, nil, headers)
+}
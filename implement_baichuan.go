@@ -3,10 +3,12 @@ package pkg_ai
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/jinzhu/copier"
 	"io"
+	"net/http"
 )
 
 /**
@@ -25,6 +27,8 @@ func NewBaiChuanConf(url, key string) *Config {
 
 type BaiChuanServer struct {
 	Conf BaiChuanConf `json:"conf"`
+
+	clientScope
 }
 
 func newBaiChuanServer(url, key string) *BaiChuanServer {
@@ -45,35 +49,31 @@ func (b *BaiChuanServer) RequestPath() string {
 }
 
 type BaiChuanRequestBody struct {
-	Messages    []Message `json:"messages"`
-	Model       string    `json:"model"`
-	MaxTokens   int64     `json:"max_tokens,omitempty"`
-	Temperature float64   `json:"temperature,omitempty"`
-	TopP        float64   `json:"top_p,omitempty"`
-	Stream      bool      `json:"stream"`
+	Messages    []Message      `json:"messages"`
+	Model       string         `json:"model"`
+	MaxTokens   int64          `json:"max_tokens,omitempty"`
+	Temperature float64        `json:"temperature,omitempty"`
+	TopP        float64        `json:"top_p,omitempty"`
+	Stream      bool           `json:"stream"`
+	Tools       []FunctionTool `json:"tools,omitempty"`
+	ToolChoice  interface{}    `json:"tool_choice,omitempty"`
 }
 
-func (b *BaiChuanServer) build(data RequestData, isStream bool) ([]byte, error) {
-	if data.UserQuery == "" || data.Model == "" {
+func (b *BaiChuanServer) Build(data RequestData, isStream bool) ([]byte, error) {
+	messages := data.messages()
+	if len(messages) == 0 || data.Model == "" {
 		return []byte{}, errors.New("问题、模型为必传字段")
 	}
 
-	request := &XfYunRequestBody{Stream: isStream, Messages: make([]Message, 0)}
+	request := &BaiChuanRequestBody{Stream: isStream, Messages: make([]Message, 0)}
 
 	if err := copier.Copy(request, &data); err != nil {
 		return nil, err
 	}
 
-	if data.SystemQuery != "" {
-		request.Messages = append(request.Messages, Message{Role: MessageSystem, Content: data.SystemQuery})
-	}
-	if data.History != nil && len(data.History) > 0 {
-		for _, detail := range data.History {
-			request.Messages = append(request.Messages, Message{Role: MessageUSer, Content: detail[0]})
-			request.Messages = append(request.Messages, Message{Role: MessageAssistant, Content: detail[1]})
-		}
-	}
-	request.Messages = append(request.Messages, Message{Role: MessageUSer, Content: data.UserQuery})
+	request.Messages = messages
+	request.Tools = data.functionTools()
+	request.ToolChoice = data.toolChoice()
 
 	return json.Marshal(request)
 }
@@ -86,17 +86,13 @@ type BaiChuanChatResponse struct {
 	Choices []struct {
 		Index   int64 `json:"index"`
 		Message struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
+			Role      string     `json:"role"`
+			Content   string     `json:"content"`
+			ToolCalls []ToolCall `json:"tool_calls"`
 		} `json:"message"`
 		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int64 `json:"prompt_tokens"`
-		CompletionTokens int64 `json:"completion_tokens"`
-		TotalTokens      int64 `json:"total_tokens"`
-		SearchCount      int64 `json:"search_count"`
-	} `json:"usage"`
+	Usage OpenAIUsage `json:"usage"`
 	Error struct {
 		Message string      `json:"message"`
 		Type    string      `json:"type"`
@@ -105,12 +101,12 @@ type BaiChuanChatResponse struct {
 	} `json:"error"`
 }
 
-func (b *BaiChuanServer) Chat(requestPath string, data []byte) (*Response, error) {
+func (b *BaiChuanServer) Chat(ctx context.Context, requestPath string, data []byte) (*Response, error) {
 	headers := map[string]string{"Authorization": "Bearer " + b.Conf.Key, "Content-Type": "application/json"}
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
-	ret.RequestHeader, _ = json.Marshal(headers)
+	ret.RequestHeader = RedactHeaders(headers)
 
-	response, err := postBase(requestPath, string(data), headers)
+	response, err := b.post(ctx, requestPath, string(data), headers)
 	if err != nil {
 		return ret, err
 	}
@@ -124,24 +120,29 @@ func (b *BaiChuanServer) Chat(requestPath string, data []byte) (*Response, error
 		return ret, err
 	}
 
+	if err := statusError(b.Supplier(), response, retBytes, b.errorBody); err != nil {
+		return ret, err
+	}
+
 	retStruct := BaiChuanChatResponse{}
 	if err := json.Unmarshal(retBytes, &retStruct); err != nil {
-		return ret, err
+		return ret, newDecodeError(b.Supplier(), response, "", retBytes, err)
 	}
 
 	ret.RequestId = retStruct.Id
-	ret.PromptTokens = retStruct.Usage.PromptTokens
-	ret.CompletionTokens = retStruct.Usage.CompletionTokens
+	ret.setUsage(retStruct.Usage.usage())
 
 	if len(retStruct.Error.Message) > 0 {
-		return ret, errors.New(retStruct.Error.Message)
+		return ret, newAPIError(b.Supplier(), response, ret.RequestId, errorCode(retStruct.Error.Code, retStruct.Error.Type), retStruct.Error.Message, openAIRetryable)
 	}
 
 	if len(retStruct.Choices) == 0 {
-		return ret, errors.New("无有效响应数据")
+		return ret, newEmptyError(b.Supplier(), response, ret.RequestId)
 	}
 
 	ret.ResponseText = retStruct.Choices[0].Message.Content
+	ret.finish(retStruct.Choices[0].FinishReason)
+	ret.ToolCalls = retStruct.Choices[0].Message.ToolCalls
 
 	return ret, nil
 }
@@ -154,17 +155,13 @@ type BaiChuanStreamResp struct {
 	Choices []struct {
 		Index int64 `json:"index"`
 		Delta struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
+			Role      string          `json:"role"`
+			Content   string          `json:"content"`
+			ToolCalls []ToolCallDelta `json:"tool_calls"`
 		} `json:"delta"`
 		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int64 `json:"prompt_tokens"`
-		CompletionTokens int64 `json:"completion_tokens"`
-		TotalTokens      int64 `json:"total_tokens"`
-		SearchCount      int64 `json:"search_count"`
-	} `json:"usage"`
+	Usage OpenAIUsage `json:"usage"`
 	Error struct {
 		Code    string      `json:"code"`
 		Param   interface{} `json:"param"`
@@ -182,21 +179,35 @@ type BaiChuanErrorInfo struct {
 	} `json:"error"`
 }
 
-func (b *BaiChuanServer) ChatStream(requestPath string, data []byte, msgCh chan string, errChan chan error) (*Response, error) {
+// errorBody 解析错误响应, 不是错误结构时返回 nil
+func (b *BaiChuanServer) errorBody(response *http.Response, body []byte) *APIError {
+	errStruct := &BaiChuanErrorInfo{}
+	if err := json.Unmarshal(body, errStruct); err != nil || len(errStruct.Error.Message) == 0 {
+		return nil
+	}
+
+	return newAPIError(b.Supplier(), response, "", errorCode(errStruct.Error.Code, errStruct.Error.Type), errStruct.Error.Message, openAIRetryable)
+}
+
+func (b *BaiChuanServer) ChatStream(ctx context.Context, requestPath string, data []byte, handler StreamHandler) (*Response, error) {
 	headers := map[string]string{"Authorization": "Bearer " + b.Conf.Key, "Content-Type": "application/json"}
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
-	ret.RequestHeader, _ = json.Marshal(headers)
+	ret.RequestHeader = RedactHeaders(headers)
 
-	response, err := postBase(requestPath, string(data), headers)
+	response, err := b.post(ctx, requestPath, string(data), headers)
 	if err != nil {
-		errChan <- err
 		return ret, err
 	}
 	defer func() {
 		_ = response.Body.Close()
 	}()
 
+	if err := readError(b.Supplier(), response, b.errorBody); err != nil {
+		return ret, err
+	}
+
 	reader := bufio.NewReader(response.Body)
+	toolCalls := &toolCallBuilder{}
 
 	for {
 		line, err := reader.ReadBytes('\n')
@@ -204,19 +215,18 @@ func (b *BaiChuanServer) ChatStream(requestPath string, data []byte, msgCh chan
 		line = bytes.TrimSuffix(line, []byte("\n"))
 
 		if err != nil {
+			if ctx.Err() != nil {
+				return ret, ctx.Err()
+			}
 
 			if errors.Is(err, io.EOF) {
-				resErr := err
-				errStruct := &BaiChuanErrorInfo{}
-				if err := json.Unmarshal(line, errStruct); err == nil {
-					resErr = errors.New(errStruct.Error.Message)
+				if apiErr := b.errorBody(response, line); apiErr != nil {
+					return ret, apiErr
 				}
 
-				errChan <- resErr
-				return ret, resErr
+				return ret, err
 			}
 
-			errChan <- err
 			return ret, err
 		}
 
@@ -231,19 +241,16 @@ func (b *BaiChuanServer) ChatStream(requestPath string, data []byte, msgCh chan
 		line = bytes.TrimPrefix(line, headerData)
 
 		if string(line) == "[DONE]" {
-			close(msgCh)
 			break
 		}
 
 		retStruct := BaiChuanStreamResp{}
 		if err := json.Unmarshal(line, &retStruct); err != nil {
-			errChan <- err
-			return ret, err
+			return ret, newDecodeError(b.Supplier(), response, ret.RequestId, line, err)
 		}
 
 		if len(retStruct.Error.Message) > 0 {
-			err = errors.New(retStruct.Error.Message)
-			errChan <- err
+			err = newAPIError(b.Supplier(), response, ret.RequestId, errorCode(retStruct.Error.Code, retStruct.Error.Type), retStruct.Error.Message, openAIRetryable)
 			return ret, err
 		}
 
@@ -251,16 +258,20 @@ func (b *BaiChuanServer) ChatStream(requestPath string, data []byte, msgCh chan
 			continue
 		}
 		ret.ResponseText += retStruct.Choices[0].Delta.Content
-		msgCh <- retStruct.Choices[0].Delta.Content
+		toolCalls.add(retStruct.Choices[0].Delta.ToolCalls)
+		if err := handler(retStruct.Choices[0].Delta.Content); err != nil {
+			return ret, err
+		}
 
-		if retStruct.Choices[0].FinishReason == "stop" {
+		if len(retStruct.Choices[0].FinishReason) > 0 {
+			ret.finish(retStruct.Choices[0].FinishReason)
 			ret.RequestId = retStruct.Id
-			ret.PromptTokens = retStruct.Usage.PromptTokens
-			ret.CompletionTokens = retStruct.Usage.CompletionTokens
-			close(msgCh)
+			ret.setUsage(retStruct.Usage.usage())
 			break
 		}
 	}
 
+	ret.ToolCalls = toolCalls.result()
+
 	return ret, nil
 }
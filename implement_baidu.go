@@ -3,12 +3,13 @@ package pkg_ai
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/jinzhu/copier"
 	"io"
+	"net/http"
 	"net/url"
-	"sync"
 	"time"
 )
 
@@ -19,12 +20,6 @@ import (
 
 const BaiDuTokenUrl = "https://aip.baidubce.com/oauth/2.0/token"
 
-var (
-	BaiDuToken    string = ""
-	BaiDuTokenExp int64  = 0
-	BaiDuLock     sync.Mutex
-)
-
 type BaiDuConf struct {
 	Url          string `json:"url"`
 	ClientId     string `json:"client_id"`
@@ -37,6 +32,8 @@ func NewBaiDuConf(url, clientId, clientSecret string) *Config {
 
 type BaiDuServer struct {
 	Conf BaiDuConf `json:"conf"`
+
+	clientScope
 }
 
 func newBaiDuServer(url, clientId, clientSecret string) *BaiDuServer {
@@ -58,38 +55,90 @@ func (b *BaiDuServer) RequestPath() string {
 }
 
 type BaiDuRequestBody struct {
-	Messages    []Message `json:"messages"`
-	Model       string    `json:"model"`
-	Temperature float64   `json:"temperature,omitempty"`
-	TopP        float64   `json:"top_p,omitempty"`
-	Stream      bool      `json:"stream"`
+	Messages    []BaiDuMessage `json:"messages"`
+	System      string         `json:"system,omitempty"`
+	Model       string         `json:"model"`
+	Temperature float64        `json:"temperature,omitempty"`
+	TopP        float64        `json:"top_p,omitempty"`
+	Stream      bool           `json:"stream"`
+	Functions   []Tool         `json:"functions,omitempty"`
+	ToolChoice  interface{}    `json:"tool_choice,omitempty"`
+}
+
+type BaiDuMessage struct {
+	Role         string             `json:"role"`
+	Content      string             `json:"content"`
+	Name         string             `json:"name,omitempty"`
+	FunctionCall *BaiDuFunctionCall `json:"function_call,omitempty"`
+}
+
+type BaiDuFunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Thoughts  string `json:"thoughts,omitempty"`
 }
 
-func (b *BaiDuServer) build(data RequestData, isStream bool) ([]byte, error) {
-	if data.UserQuery == "" || data.Model == "" {
+func (b *BaiDuServer) Build(data RequestData, isStream bool) ([]byte, error) {
+	messages := data.messages()
+	if len(messages) == 0 || data.Model == "" {
 		return []byte{}, errors.New("问题、模型为必传字段")
 	}
 
-	request := &BaiDuRequestBody{Stream: isStream, Messages: make([]Message, 0)}
+	request := &BaiDuRequestBody{Stream: isStream}
 
 	if err := copier.Copy(request, &data); err != nil {
 		return nil, err
 	}
 
-	if data.SystemQuery != "" {
-		request.Messages = append(request.Messages, Message{Role: MessageSystem, Content: data.SystemQuery})
-	}
-	if data.History != nil && len(data.History) > 0 {
-		for _, detail := range data.History {
-			request.Messages = append(request.Messages, Message{Role: MessageUSer, Content: detail[0]})
-			request.Messages = append(request.Messages, Message{Role: MessageAssistant, Content: detail[1]})
+	// 千帆的系统人设通过 system 字段传递, 工具调用通过 function_call 及 function 角色传递
+	request.Messages = make([]BaiDuMessage, 0, len(messages))
+	functionNames := make(map[string]string)
+	for _, message := range messages {
+		switch {
+		case message.Role == MessageSystem:
+			if len(request.System) > 0 {
+				request.System += "\n"
+			}
+			request.System += message.Content
+
+		case message.Role == MessageTool:
+			name := message.Name
+			if len(name) == 0 {
+				name = functionNames[message.ToolCallId]
+			}
+			request.Messages = append(request.Messages, BaiDuMessage{Role: "function", Name: name, Content: message.Content})
+
+		case len(message.ToolCalls) > 0:
+			toolCall := message.ToolCalls[0]
+			functionNames[toolCall.Id] = toolCall.Function.Name
+			request.Messages = append(request.Messages, BaiDuMessage{
+				Role:         message.Role,
+				Content:      message.Content,
+				FunctionCall: &BaiDuFunctionCall{Name: toolCall.Function.Name, Arguments: toolCall.Function.Arguments},
+			})
+
+		default:
+			request.Messages = append(request.Messages, BaiDuMessage{Role: message.Role, Content: message.Content, Name: message.Name})
 		}
 	}
-	request.Messages = append(request.Messages, Message{Role: MessageUSer, Content: data.UserQuery})
+
+	request.Functions = data.Tools
+	request.ToolChoice = nil
+	if choice, ok := data.toolChoice().(map[string]interface{}); ok {
+		request.ToolChoice = choice
+	}
 
 	return json.Marshal(request)
 }
 
+func (b *BaiDuFunctionCall) toolCalls() []ToolCall {
+	if b == nil || len(b.Name) == 0 {
+		return nil
+	}
+
+	return []ToolCall{{Type: "function", Function: ToolCallFunction{Name: b.Name, Arguments: b.Arguments}}}
+}
+
 type BaiDuTokenResponse struct {
 	RefreshToken     string `json:"refresh_token"`
 	ExpiresIn        int    `json:"expires_in"`
@@ -101,14 +150,14 @@ type BaiDuTokenResponse struct {
 	Error            string `json:"error"`
 }
 
-func (b *BaiDuServer) token() (string, error) {
+func (b *BaiDuServer) token(ctx context.Context) (string, error) {
 	formData := url.Values{}
 	formData.Set("grant_type", "client_credentials")
 	formData.Set("client_id", b.Conf.ClientId)
 	formData.Set("client_secret", b.Conf.ClientSecret)
 
 	headers := map[string]string{"Accept": "application/json", "Content-Type": "application/x-www-form-urlencoded"}
-	response, err := postBase(BaiDuTokenUrl, formData.Encode(), headers)
+	response, err := b.post(ctx, BaiDuTokenUrl, formData.Encode(), headers)
 	if err != nil {
 		return "", err
 	}
@@ -116,65 +165,93 @@ func (b *BaiDuServer) token() (string, error) {
 		_ = response.Body.Close()
 	}()
 
+	retBytes, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+
+	// 鉴权失败时返回 401 及 error 字段, 优先按 error 字段处理
 	responseStruct := &BaiDuTokenResponse{}
-	if err := json.NewDecoder(response.Body).Decode(responseStruct); err != nil {
+	if err := json.Unmarshal(retBytes, responseStruct); err == nil && len(responseStruct.Error) != 0 {
+		return "", newAPIError(b.Supplier(), response, "", responseStruct.Error, responseStruct.ErrorDescription, nil)
+	}
+
+	if err := statusError(b.Supplier(), response, retBytes, nil); err != nil {
 		return "", err
 	}
 
-	if len(responseStruct.Error) != 0 {
-		return "", errors.New(responseStruct.ErrorDescription)
+	if err := json.Unmarshal(retBytes, responseStruct); err != nil {
+		return "", newDecodeError(b.Supplier(), response, "", retBytes, err)
 	}
 
 	return responseStruct.AccessToken, nil
 }
 
-func (b *BaiDuServer) Token() (string, error) {
-	BaiDuLock.Lock()
-	defer BaiDuLock.Unlock()
+func (b *BaiDuServer) tokenKey() string {
+	return "baidubce:" + b.Conf.ClientId + ":" + b.Conf.ClientSecret
+}
 
-	if time.Now().Unix() < BaiDuTokenExp {
-		return BaiDuToken, nil
-	}
+// Token 获取 access_token, 按 ClientId 缓存在所属 Client 中, 不同凭证互不影响
+func (b *BaiDuServer) Token(ctx context.Context) (string, error) {
+	return b.instance().tokens.get(ctx, b.tokenKey(), time.Now().Unix(), func() (string, int64, error) {
+		ctx, span := b.instance().telemetry.startSpan(ctx, "baidubce token")
+		token, err := b.token(ctx)
+		endSpan(span, err)
+		return token, time.Now().Unix() + 86400*30 - 7200, err
+	})
+}
 
-	token, err := b.token()
-	if err != nil {
-		return token, err
+// error 构建上游错误, access_token 失效时清除缓存, 重试时重新获取
+func (b *BaiDuServer) error(response *http.Response, requestId string, code int64, message string) *APIError {
+	if code == 110 || code == 111 {
+		b.instance().tokens.remove(b.tokenKey())
 	}
-	BaiDuTokenExp = time.Now().Unix() + 86400*30 - 7200
-	BaiDuToken = token
 
-	return token, nil
+	return newAPIError(b.Supplier(), response, requestId, errorCode(code), message, func(status int, _ string) bool {
+		switch code {
+		case 2, 4, 18, 110, 111, 336100, 336501, 336502:
+			return true
+		}
+		return retryableStatus(status, "")
+	})
+}
+
+// finish 记录结束原因, is_truncated、need_clear_history 记录在 Metadata 中
+func (b *BaiDuServer) finish(ret *Response, finishReason string, isTruncated, needClearHistory bool) {
+	ret.finish(finishReason)
+	if len(ret.FinishReason) == 0 && isTruncated {
+		ret.FinishReason = FinishLength
+	}
+	ret.setMetadata("is_truncated", isTruncated)
+	ret.setMetadata("need_clear_history", needClearHistory)
 }
 
 type BaiDuResponse struct {
-	Id               string `json:"id"`
-	Object           string `json:"object"`
-	Created          int    `json:"created"`
-	Result           string `json:"result"`
-	IsTruncated      bool   `json:"is_truncated"`
-	NeedClearHistory bool   `json:"need_clear_history"`
-	FinishReason     string `json:"finish_reason"`
-	Usage            struct {
-		PromptTokens     int64 `json:"prompt_tokens"`
-		CompletionTokens int64 `json:"completion_tokens"`
-		TotalTokens      int64 `json:"total_tokens"`
-	} `json:"usage"`
-	ErrorCode int64  `json:"error_code"`
-	ErrorMsg  string `json:"error_msg"`
+	Id               string             `json:"id"`
+	Object           string             `json:"object"`
+	Created          int                `json:"created"`
+	Result           string             `json:"result"`
+	IsTruncated      bool               `json:"is_truncated"`
+	NeedClearHistory bool               `json:"need_clear_history"`
+	FinishReason     string             `json:"finish_reason"`
+	FunctionCall     *BaiDuFunctionCall `json:"function_call"`
+	Usage            OpenAIUsage        `json:"usage"`
+	ErrorCode        int64              `json:"error_code"`
+	ErrorMsg         string             `json:"error_msg"`
 }
 
-func (b *BaiDuServer) Chat(requestPath string, data []byte) (*Response, error) {
+func (b *BaiDuServer) Chat(ctx context.Context, requestPath string, data []byte) (*Response, error) {
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
 
-	token, err := b.Token()
+	token, err := b.Token(ctx)
 	if err != nil {
 		return ret, err
 	}
 	requestPath = requestPath + "?access_token=" + token
 	headers := map[string]string{"Content-Type": "application/json", "Authorization": "Bearer " + token}
-	ret.RequestHeader, _ = json.Marshal(headers)
+	ret.RequestHeader = RedactHeaders(headers)
 
-	response, err := postBase(requestPath, string(data), headers)
+	response, err := b.post(ctx, requestPath, string(data), headers)
 	if err != nil {
 		return ret, err
 	}
@@ -188,41 +265,43 @@ func (b *BaiDuServer) Chat(requestPath string, data []byte) (*Response, error) {
 		return ret, err
 	}
 
+	if err := statusError(b.Supplier(), response, retBytes, b.errorBody); err != nil {
+		return ret, err
+	}
+
 	retStruct := BaiDuResponse{}
 	if err := json.Unmarshal(retBytes, &retStruct); err != nil {
-		return ret, err
+		return ret, newDecodeError(b.Supplier(), response, "", retBytes, err)
 	}
 
 	if retStruct.ErrorCode != 0 {
-		return ret, errors.New(retStruct.ErrorMsg)
+		return ret, b.error(response, ret.RequestId, retStruct.ErrorCode, retStruct.ErrorMsg)
 	}
 
 	ret.RequestId = retStruct.Id
-	ret.PromptTokens = retStruct.Usage.PromptTokens
-	ret.CompletionTokens = retStruct.Usage.CompletionTokens
+	ret.setUsage(retStruct.Usage.usage())
 
 	ret.ResponseText = retStruct.Result
+	b.finish(ret, retStruct.FinishReason, retStruct.IsTruncated, retStruct.NeedClearHistory)
+	ret.ToolCalls = retStruct.FunctionCall.toolCalls()
 
 	return ret, nil
 }
 
 type BaiDuStreamResp struct {
-	Id               string `json:"id"`
-	Object           string `json:"object"`
-	Created          int64  `json:"created"`
-	SentenceID       int64  `json:"sentence_id"`
-	IsEnd            bool   `json:"is_end"`
-	IsTruncated      bool   `json:"is_truncated"`
-	Result           string `json:"result"`
-	NeedClearHistory bool   `json:"need_clear_history"`
-	FinishReason     string `json:"finish_reason"`
-	Usage            struct {
-		PromptTokens     int64 `json:"prompt_tokens"`
-		CompletionTokens int64 `json:"completion_tokens"`
-		TotalTokens      int64 `json:"total_tokens"`
-	} `json:"usage"`
-	ErrorCode int64  `json:"error_code"`
-	ErrorMsg  string `json:"error_msg"`
+	Id               string             `json:"id"`
+	Object           string             `json:"object"`
+	Created          int64              `json:"created"`
+	SentenceID       int64              `json:"sentence_id"`
+	IsEnd            bool               `json:"is_end"`
+	IsTruncated      bool               `json:"is_truncated"`
+	Result           string             `json:"result"`
+	NeedClearHistory bool               `json:"need_clear_history"`
+	FinishReason     string             `json:"finish_reason"`
+	FunctionCall     *BaiDuFunctionCall `json:"function_call"`
+	Usage            OpenAIUsage        `json:"usage"`
+	ErrorCode        int64              `json:"error_code"`
+	ErrorMsg         string             `json:"error_msg"`
 }
 
 type BaiDuErrorInfo struct {
@@ -230,26 +309,39 @@ type BaiDuErrorInfo struct {
 	ErrorMsg  string `json:"error_msg"`
 }
 
-func (b *BaiDuServer) ChatStream(requestPath string, data []byte, msgCh chan string, errChan chan error) (*Response, error) {
+// errorBody 解析错误响应, 不是错误结构时返回 nil
+func (b *BaiDuServer) errorBody(response *http.Response, body []byte) *APIError {
+	errStruct := &BaiDuErrorInfo{}
+	if err := json.Unmarshal(body, errStruct); err != nil || errStruct.ErrorCode == 0 {
+		return nil
+	}
+
+	return b.error(response, "", errStruct.ErrorCode, errStruct.ErrorMsg)
+}
+
+func (b *BaiDuServer) ChatStream(ctx context.Context, requestPath string, data []byte, handler StreamHandler) (*Response, error) {
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
 
-	token, err := b.Token()
+	token, err := b.Token(ctx)
 	if err != nil {
 		return ret, err
 	}
 	requestPath = requestPath + "?access_token=" + token
 	headers := map[string]string{"Content-Type": "application/json", "Authorization": "Bearer " + token}
-	ret.RequestHeader, _ = json.Marshal(headers)
+	ret.RequestHeader = RedactHeaders(headers)
 
-	response, err := postBase(requestPath, string(data), headers)
+	response, err := b.post(ctx, requestPath, string(data), headers)
 	if err != nil {
-		errChan <- err
 		return ret, err
 	}
 	defer func() {
 		_ = response.Body.Close()
 	}()
 
+	if err := readError(b.Supplier(), response, b.errorBody); err != nil {
+		return ret, err
+	}
+
 	reader := bufio.NewReader(response.Body)
 
 	for {
@@ -258,19 +350,18 @@ func (b *BaiDuServer) ChatStream(requestPath string, data []byte, msgCh chan str
 		line = bytes.TrimSuffix(line, []byte("\n"))
 
 		if err != nil {
+			if ctx.Err() != nil {
+				return ret, ctx.Err()
+			}
 
 			if errors.Is(err, io.EOF) {
-				resErr := err
-				errStruct := &BaiDuErrorInfo{}
-				if err := json.Unmarshal(line, errStruct); err == nil {
-					resErr = errors.New(errStruct.ErrorMsg)
+				if apiErr := b.errorBody(response, line); apiErr != nil {
+					return ret, apiErr
 				}
 
-				errChan <- resErr
-				return ret, resErr
+				return ret, err
 			}
 
-			errChan <- err
 			return ret, err
 		}
 
@@ -286,26 +377,29 @@ func (b *BaiDuServer) ChatStream(requestPath string, data []byte, msgCh chan str
 
 		retStruct := BaiDuStreamResp{}
 		if err := json.Unmarshal(line, &retStruct); err != nil {
-			errChan <- err
-			return ret, err
+			return ret, newDecodeError(b.Supplier(), response, ret.RequestId, line, err)
 		}
 
 		if retStruct.ErrorCode != 0 {
-			err := errors.New(retStruct.ErrorMsg)
-			errChan <- err
+			return ret, b.error(response, ret.RequestId, retStruct.ErrorCode, retStruct.ErrorMsg)
+		}
+
+		if retStruct.FunctionCall != nil {
+			ret.ToolCalls = retStruct.FunctionCall.toolCalls()
+		}
+
+		// 最后一个数据块同样可能携带内容
+		ret.ResponseText += retStruct.Result
+		if err := handler(retStruct.Result); err != nil {
 			return ret, err
 		}
 
 		if retStruct.IsEnd {
+			b.finish(ret, retStruct.FinishReason, retStruct.IsTruncated, retStruct.NeedClearHistory)
 			ret.RequestId = retStruct.Id
-			ret.PromptTokens = retStruct.Usage.PromptTokens
-			ret.CompletionTokens = retStruct.Usage.CompletionTokens
-			close(msgCh)
+			ret.setUsage(retStruct.Usage.usage())
 			break
 		}
-
-		ret.ResponseText += retStruct.Result
-		msgCh <- retStruct.Result
 	}
 
 	return ret, nil
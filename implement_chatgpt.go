@@ -0,0 +1,348 @@
+package pkg_ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jinzhu/copier"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+/**
+ * 【OpenAI】chatGpt
+ * Doc : https://platform.openai.com/docs/api-reference/chat/create
+ * Azure Doc : https://learn.microsoft.com/zh-cn/azure/ai-services/openai/reference
+ */
+
+type ChatGptConf struct {
+	Url             string `json:"url"`
+	Key             string `json:"key"`
+	Organization    string `json:"organization"`
+	Project         string `json:"project"`
+	AzureDeployment string `json:"azure_deployment"`
+	AzureApiVersion string `json:"azure_api_version"`
+}
+
+func NewChatGptConf(url, key string) *Config {
+	return &Config{ChatGptUrl: url, ChatGptKey: key}
+}
+
+type ChatGptServer struct {
+	Conf ChatGptConf `json:"conf"`
+
+	clientScope
+}
+
+func newChatGptServer(conf ChatGptConf) *ChatGptServer {
+	return &ChatGptServer{Conf: conf}
+}
+
+func (c *ChatGptServer) Supplier() string {
+	return "chatGpt"
+}
+
+// RequestPath 配置了 Azure 部署名称时, 按 Azure OpenAI 规则拼接部署地址
+func (c *ChatGptServer) RequestPath() string {
+	if len(c.Conf.AzureDeployment) == 0 {
+		return c.Conf.Url
+	}
+
+	return strings.TrimSuffix(c.Conf.Url, "/") + "/openai/deployments/" + url.PathEscape(c.Conf.AzureDeployment) +
+		"/chat/completions?api-version=" + url.QueryEscape(c.Conf.AzureApiVersion)
+}
+
+func (c *ChatGptServer) headers() map[string]string {
+	headers := map[string]string{"Content-Type": "application/json"}
+
+	if len(c.Conf.AzureDeployment) > 0 {
+		headers["api-key"] = c.Conf.Key
+		return headers
+	}
+
+	headers["Authorization"] = "Bearer " + c.Conf.Key
+	if len(c.Conf.Organization) > 0 {
+		headers["OpenAI-Organization"] = c.Conf.Organization
+	}
+	if len(c.Conf.Project) > 0 {
+		headers["OpenAI-Project"] = c.Conf.Project
+	}
+
+	return headers
+}
+
+type ChatGptRequestBody struct {
+	Messages         []Message `json:"messages"`
+	Model            string    `json:"model"`
+	MaxTokens        int64     `json:"max_tokens,omitempty"`
+	Temperature      float64   `json:"temperature,omitempty"`
+	TopP             float64   `json:"top_p,omitempty"`
+	N                int64     `json:"n,omitempty"`
+	PresencePenalty  float64   `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float64   `json:"frequency_penalty,omitempty"`
+	ResponseFormat   *struct {
+		Type string `json:"type"`
+	} `json:"response_format,omitempty"`
+	Stop          []string       `json:"stop,omitempty"`
+	Stream        bool           `json:"stream"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+	Tools         []FunctionTool `json:"tools,omitempty"`
+	ToolChoice    interface{}    `json:"tool_choice,omitempty"`
+}
+
+func (c *ChatGptServer) Build(data RequestData, isStream bool) ([]byte, error) {
+	messages := data.messages()
+	if len(messages) == 0 || data.Model == "" {
+		return []byte{}, errors.New("问题、模型为必传字段")
+	}
+
+	request := &ChatGptRequestBody{Stream: isStream}
+
+	if err := copier.Copy(request, &data); err != nil {
+		return nil, err
+	}
+
+	request.Messages = messages
+	request.Tools = data.functionTools()
+	request.ToolChoice = data.toolChoice()
+
+	switch data.ResponseFormat {
+	case "":
+		request.ResponseFormat = nil
+	case "json", "json_object":
+		request.ResponseFormat = &struct {
+			Type string `json:"type"`
+		}{Type: "json_object"}
+	default:
+		request.ResponseFormat = &struct {
+			Type string `json:"type"`
+		}{Type: data.ResponseFormat}
+	}
+
+	if isStream {
+		request.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+
+	return json.Marshal(request)
+}
+
+type ChatGptChatResponse struct {
+	Id      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index   int64 `json:"index"`
+		Message struct {
+			Role      string     `json:"role"`
+			Content   string     `json:"content"`
+			Refusal   string     `json:"refusal"`
+			ToolCalls []ToolCall `json:"tool_calls"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage             OpenAIUsage `json:"usage"`
+	SystemFingerprint string      `json:"system_fingerprint"`
+	Error             struct {
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Param   interface{} `json:"param"`
+		Code    interface{} `json:"code"`
+	} `json:"error"`
+}
+
+func (c *ChatGptServer) Chat(ctx context.Context, requestPath string, data []byte) (*Response, error) {
+	headers := c.headers()
+	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
+	ret.RequestHeader = RedactHeaders(headers)
+
+	response, err := c.post(ctx, requestPath, string(data), headers)
+	if err != nil {
+		return ret, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	retBytes, err := io.ReadAll(response.Body)
+	ret.ResponseData = append(ret.ResponseData, retBytes)
+	if err != nil {
+		return ret, err
+	}
+
+	if err := statusError(c.Supplier(), response, retBytes, c.errorBody); err != nil {
+		return ret, err
+	}
+
+	retStruct := ChatGptChatResponse{}
+	if err := json.Unmarshal(retBytes, &retStruct); err != nil {
+		return ret, newDecodeError(c.Supplier(), response, "", retBytes, err)
+	}
+
+	ret.RequestId = retStruct.Id
+	ret.setUsage(retStruct.Usage.usage())
+
+	if len(retStruct.Error.Message) > 0 {
+		return ret, newAPIError(c.Supplier(), response, ret.RequestId, errorCode(retStruct.Error.Code, retStruct.Error.Type), retStruct.Error.Message, openAIRetryable)
+	}
+
+	if len(retStruct.Choices) == 0 {
+		return ret, newEmptyError(c.Supplier(), response, ret.RequestId)
+	}
+
+	ret.ResponseText = retStruct.Choices[0].Message.Content
+	ret.finish(retStruct.Choices[0].FinishReason)
+	ret.ToolCalls = retStruct.Choices[0].Message.ToolCalls
+
+	if len(ret.ResponseText) == 0 && len(retStruct.Choices[0].Message.Refusal) > 0 {
+		return ret, newContentFilterError(c.Supplier(), response, ret.RequestId, "refusal", retStruct.Choices[0].Message.Refusal)
+	}
+
+	return ret, nil
+}
+
+type ChatGptErrorInfo struct {
+	Error struct {
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Param   interface{} `json:"param"`
+		Code    interface{} `json:"code"`
+	} `json:"error"`
+}
+
+// errorBody 解析错误响应, 不是错误结构时返回 nil
+func (c *ChatGptServer) errorBody(response *http.Response, body []byte) *APIError {
+	errStruct := &ChatGptErrorInfo{}
+	if err := json.Unmarshal(body, errStruct); err != nil || len(errStruct.Error.Message) == 0 {
+		return nil
+	}
+
+	return newAPIError(c.Supplier(), response, "", errorCode(errStruct.Error.Code, errStruct.Error.Type), errStruct.Error.Message, openAIRetryable)
+}
+
+type ChatGptStreamResp struct {
+	Id      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index int64 `json:"index"`
+		Delta struct {
+			Role      string          `json:"role"`
+			Content   string          `json:"content"`
+			Refusal   string          `json:"refusal"`
+			ToolCalls []ToolCallDelta `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage             *OpenAIUsage `json:"usage"`
+	SystemFingerprint string       `json:"system_fingerprint"`
+	Error             struct {
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Param   interface{} `json:"param"`
+		Code    interface{} `json:"code"`
+	} `json:"error"`
+}
+
+func (c *ChatGptServer) ChatStream(ctx context.Context, requestPath string, data []byte, handler StreamHandler) (*Response, error) {
+	headers := c.headers()
+	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
+	ret.RequestHeader = RedactHeaders(headers)
+
+	response, err := c.post(ctx, requestPath, string(data), headers)
+	if err != nil {
+		return ret, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if err := readError(c.Supplier(), response, c.errorBody); err != nil {
+		return ret, err
+	}
+
+	reader := bufio.NewReader(response.Body)
+	toolCalls := &toolCallBuilder{}
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		ret.ResponseData = append(ret.ResponseData, line)
+		line = bytes.TrimSuffix(line, []byte("\n"))
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return ret, ctx.Err()
+			}
+
+			if errors.Is(err, io.EOF) {
+				// 等待用量数据块期间断开, 已收到结束原因时视为正常结束
+				if len(ret.FinishReason) > 0 {
+					break
+				}
+
+				if apiErr := c.errorBody(response, line); apiErr != nil {
+					return ret, apiErr
+				}
+
+				return ret, err
+			}
+
+			return ret, err
+		}
+
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if string(line) == "" {
+			continue
+		}
+
+		headerData := []byte("data: ")
+		if !bytes.HasPrefix(line, headerData) {
+			continue
+		}
+		line = bytes.TrimPrefix(line, headerData)
+
+		if string(line) == "[DONE]" {
+			break
+		}
+
+		retStruct := ChatGptStreamResp{}
+		if err := json.Unmarshal(line, &retStruct); err != nil {
+			return ret, newDecodeError(c.Supplier(), response, ret.RequestId, line, err)
+		}
+
+		if len(retStruct.Error.Message) > 0 {
+			return ret, newAPIError(c.Supplier(), response, ret.RequestId, errorCode(retStruct.Error.Code, retStruct.Error.Type), retStruct.Error.Message, openAIRetryable)
+		}
+
+		if len(retStruct.Id) > 0 {
+			ret.RequestId = retStruct.Id
+		}
+
+		// 开启 stream_options.include_usage 后, 最后一个数据块 choices 为空, 仅携带 usage
+		if retStruct.Usage != nil {
+			ret.setUsage(retStruct.Usage.usage())
+		}
+
+		if len(retStruct.Choices) == 0 {
+			continue
+		}
+
+		ret.ResponseText += retStruct.Choices[0].Delta.Content
+		toolCalls.add(retStruct.Choices[0].Delta.ToolCalls)
+		if err := handler(retStruct.Choices[0].Delta.Content); err != nil {
+			return ret, err
+		}
+
+		// 结束原因之后可能还有仅携带 usage 的数据块, 读取到 [DONE] 为止
+		ret.finish(retStruct.Choices[0].FinishReason)
+	}
+
+	ret.ToolCalls = toolCalls.result()
+
+	return ret, nil
+}
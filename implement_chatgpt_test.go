@@ -0,0 +1,150 @@
+package pkg_ai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChatGptHeaders(t *testing.T) {
+	var request *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = r.Clone(context.Background())
+		_, _ = io.WriteString(w, `{"id":"chatcmpl-1","choices":[{"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`)
+	}))
+	defer upstream.Close()
+
+	client := NewClient(&Config{}, WithChatGptConfig(upstream.URL+"/v1/chat/completions", "sk-test"), WithChatGptOrganization("org-1", "proj-1"))
+	server, err := client.NewServer(ImplementChatGpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := server.Chat(RequestData{Model: "gpt-4o", UserQuery: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.ResponseText != "hi" || response.Usage.TotalTokens != 4 {
+		t.Errorf("response = %q, usage = %+v", response.ResponseText, response.Usage)
+	}
+
+	if request.URL.Path != "/v1/chat/completions" {
+		t.Errorf("path = %s", request.URL.Path)
+	}
+	want := map[string]string{
+		"Authorization":       "Bearer sk-test",
+		"OpenAI-Organization": "org-1",
+		"OpenAI-Project":      "proj-1",
+		"api-key":             "",
+	}
+	for key, value := range want {
+		if got := request.Header.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
+
+func TestChatGptAzure(t *testing.T) {
+	var request *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = r.Clone(context.Background())
+		_, _ = io.WriteString(w, `{"id":"chatcmpl-1","choices":[{"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`)
+	}))
+	defer upstream.Close()
+
+	client := NewClient(&Config{}, WithChatGptAzureConfig(upstream.URL+"/", "azure-key", "gpt 4o", "2024-06-01"), WithChatGptOrganization("org-1", ""))
+	server, err := client.NewServer(ImplementChatGpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := server.Chat(RequestData{Model: "gpt-4o", UserQuery: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if request.URL.EscapedPath() != "/openai/deployments/gpt%204o/chat/completions" {
+		t.Errorf("path = %s", request.URL.EscapedPath())
+	}
+	if version := request.URL.Query().Get("api-version"); version != "2024-06-01" {
+		t.Errorf("api-version = %s", version)
+	}
+	if key := request.Header.Get("api-key"); key != "azure-key" {
+		t.Errorf("api-key = %q", key)
+	}
+	for _, key := range []string{"Authorization", "OpenAI-Organization"} {
+		if value := request.Header.Get(key); len(value) > 0 {
+			t.Errorf("%s = %q, want empty", key, value)
+		}
+	}
+}
+
+func TestChatGptStreamUsageAfterFinish(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), `"include_usage":true`) {
+			t.Errorf("stream_options.include_usage not set: %s", body)
+		}
+		_, _ = io.WriteString(w, "data: {\"id\":\"chatcmpl-1\",\"choices\":[{\"delta\":{\"content\":\"he\"}}]}\n\n"+
+			"data: {\"id\":\"chatcmpl-1\",\"choices\":[{\"delta\":{\"content\":\"llo\"},\"finish_reason\":\"stop\"}]}\n\n"+
+			"data: {\"id\":\"chatcmpl-1\",\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":2,\"total_tokens\":7}}\n\n"+
+			"data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+
+	client := NewClient(&Config{}, WithChatGptConfig(upstream.URL, "sk-test"))
+	server, err := client.NewServer(ImplementChatGpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := server.Stream(context.Background(), RequestData{Model: "gpt-4o", UserQuery: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := ""
+	for stream.Next() {
+		text += stream.Current()
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	response := stream.Response()
+	if text != "hello" || response.ResponseText != "hello" {
+		t.Errorf("text = %q, response = %q", text, response.ResponseText)
+	}
+	if response.FinishReason != FinishStop {
+		t.Errorf("finish reason = %q", response.FinishReason)
+	}
+	if response.Usage.PromptTokens != 5 || response.Usage.CompletionTokens != 2 || response.Usage.TotalTokens != 7 {
+		t.Errorf("usage = %+v", response.Usage)
+	}
+}
+
+func TestChatGptHtmlError(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = io.WriteString(w, "<html><body><h1>502 Bad Gateway</h1></body></html>")
+	}))
+	defer upstream.Close()
+
+	client := NewClient(&Config{}, WithChatGptConfig(upstream.URL, "sk-test"))
+	server, err := client.NewServer(ImplementChatGpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.Chat(RequestData{Model: "gpt-4o", UserQuery: "hi"})
+	apiErr := &APIError{}
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want *APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusBadGateway || apiErr.Category != CategoryServer || apiErr.Provider != "chatGpt" {
+		t.Errorf("err = %+v", apiErr)
+	}
+}
@@ -3,9 +3,11 @@ package pkg_ai
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
+	"net/http"
 )
 
 type DeepSeekConf struct {
@@ -19,6 +21,8 @@ func NewDeepSeekConf(url, key string) *Config {
 
 type DeepSeekServer struct {
 	Conf DeepSeekConf `json:"conf"`
+
+	clientScope
 }
 
 func newDeepSeekServer(url, key string) *DeepSeekServer {
@@ -39,30 +43,26 @@ func (d *DeepSeekServer) RequestPath() string {
 }
 
 type DeepSeekRequestBody struct {
-	Messages    []Message `json:"messages"`
-	Model       string    `json:"model"`
-	MaxTokens   int64     `json:"max_tokens,omitempty"`
-	Temperature float64   `json:"temperature,omitempty"`
-	Stream      bool      `json:"stream"`
+	Messages    []Message      `json:"messages"`
+	Model       string         `json:"model"`
+	MaxTokens   int64          `json:"max_tokens,omitempty"`
+	Temperature float64        `json:"temperature,omitempty"`
+	Stream      bool           `json:"stream"`
+	Tools       []FunctionTool `json:"tools,omitempty"`
+	ToolChoice  interface{}    `json:"tool_choice,omitempty"`
 }
 
-func (d *DeepSeekServer) build(data RequestData, isStream bool) ([]byte, error) {
-	if data.UserQuery == "" || data.Model == "" {
+func (d *DeepSeekServer) Build(data RequestData, isStream bool) ([]byte, error) {
+	messages := data.messages()
+	if len(messages) == 0 || data.Model == "" {
 		return []byte{}, errors.New("问题、模型为必传字段")
 	}
 
 	request := &DeepSeekRequestBody{Stream: isStream, Messages: make([]Message, 0), Model: data.Model}
 
-	if data.SystemQuery != "" {
-		request.Messages = append(request.Messages, Message{Role: MessageSystem, Content: data.SystemQuery})
-	}
-	if data.History != nil && len(data.History) > 0 {
-		for _, detail := range data.History {
-			request.Messages = append(request.Messages, Message{Role: MessageUSer, Content: detail[0]})
-			request.Messages = append(request.Messages, Message{Role: MessageAssistant, Content: detail[1]})
-		}
-	}
-	request.Messages = append(request.Messages, Message{Role: MessageUSer, Content: data.UserQuery})
+	request.Messages = messages
+	request.Tools = data.functionTools()
+	request.ToolChoice = data.toolChoice()
 
 	return json.Marshal(request)
 }
@@ -75,28 +75,25 @@ type DeepSeekChatResponse struct {
 	Choices []struct {
 		Index   int `json:"index"`
 		Message struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
+			Role      string     `json:"role"`
+			Content   string     `json:"content"`
+			ToolCalls []ToolCall `json:"tool_calls"`
 		} `json:"message"`
 		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int64 `json:"prompt_tokens"`
-		CompletionTokens int64 `json:"completion_tokens"`
-		TotalTokens      int64 `json:"total_tokens"`
-	} `json:"usage"`
+	Usage OpenAIUsage `json:"usage"`
 	Error struct {
 		Message string `json:"message"`
 		Type    string `json:"type"`
 	} `json:"error"`
 }
 
-func (d *DeepSeekServer) Chat(requestPath string, data []byte) (*Response, error) {
+func (d *DeepSeekServer) Chat(ctx context.Context, requestPath string, data []byte) (*Response, error) {
 	headers := map[string]string{"Authorization": "Bearer " + d.Conf.Key, "content-type": "application/json"}
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
-	ret.RequestHeader, _ = json.Marshal(headers)
+	ret.RequestHeader = RedactHeaders(headers)
 
-	response, err := postBase(requestPath, string(data), headers)
+	response, err := d.post(ctx, requestPath, string(data), headers)
 	if err != nil {
 		return ret, err
 	}
@@ -110,24 +107,29 @@ func (d *DeepSeekServer) Chat(requestPath string, data []byte) (*Response, error
 		return ret, err
 	}
 
+	if err := statusError(d.Supplier(), response, retBytes, d.errorBody); err != nil {
+		return ret, err
+	}
+
 	retStruct := DeepSeekChatResponse{}
 	if err := json.Unmarshal(retBytes, &retStruct); err != nil {
-		return ret, err
+		return ret, newDecodeError(d.Supplier(), response, "", retBytes, err)
 	}
 
 	ret.RequestId = retStruct.Id
-	ret.PromptTokens = retStruct.Usage.PromptTokens
-	ret.CompletionTokens = retStruct.Usage.CompletionTokens
+	ret.setUsage(retStruct.Usage.usage())
 
 	if len(retStruct.Error.Message) > 0 {
-		return ret, errors.New(retStruct.Error.Message)
+		return ret, newAPIError(d.Supplier(), response, ret.RequestId, retStruct.Error.Type, retStruct.Error.Message, openAIRetryable)
 	}
 
 	if len(retStruct.Choices) == 0 {
-		return ret, errors.New("无有效响应数据")
+		return ret, newEmptyError(d.Supplier(), response, ret.RequestId)
 	}
 
 	ret.ResponseText = retStruct.Choices[0].Message.Content
+	ret.finish(retStruct.Choices[0].FinishReason)
+	ret.ToolCalls = retStruct.Choices[0].Message.ToolCalls
 
 	return ret, nil
 }
@@ -136,9 +138,20 @@ type DeepSeekErrorInfo struct {
 	Error struct {
 		Code    int64  `json:"code"`
 		Message string `json:"message"`
+		Type    string `json:"type"`
 	} `json:"error"`
 }
 
+// errorBody 解析错误响应, 不是错误结构时返回 nil
+func (d *DeepSeekServer) errorBody(response *http.Response, body []byte) *APIError {
+	errStruct := &DeepSeekErrorInfo{}
+	if err := json.Unmarshal(body, errStruct); err != nil || len(errStruct.Error.Message) == 0 {
+		return nil
+	}
+
+	return newAPIError(d.Supplier(), response, "", errorCode(errStruct.Error.Type, errStruct.Error.Code), errStruct.Error.Message, openAIRetryable)
+}
+
 type DeepSeekStreamResp struct {
 	Id      string `json:"id"`
 	Object  string `json:"object"`
@@ -147,32 +160,33 @@ type DeepSeekStreamResp struct {
 	Choices []struct {
 		Index int `json:"index"`
 		Delta struct {
-			Content string `json:"content"`
+			Content   string          `json:"content"`
+			ToolCalls []ToolCallDelta `json:"tool_calls"`
 		} `json:"delta"`
 		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int64 `json:"prompt_tokens"`
-		CompletionTokens int64 `json:"completion_tokens"`
-		TotalTokens      int64 `json:"total_tokens"`
-	} `json:"usage"`
+	Usage OpenAIUsage `json:"usage"`
 }
 
-func (d *DeepSeekServer) ChatStream(requestPath string, data []byte, msgCh chan string, errChan chan error) (*Response, error) {
+func (d *DeepSeekServer) ChatStream(ctx context.Context, requestPath string, data []byte, handler StreamHandler) (*Response, error) {
 	headers := map[string]string{"Authorization": "Bearer " + d.Conf.Key, "content-type": "application/json"}
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
-	ret.RequestHeader, _ = json.Marshal(headers)
+	ret.RequestHeader = RedactHeaders(headers)
 
-	response, err := postBase(requestPath, string(data), headers)
+	response, err := d.post(ctx, requestPath, string(data), headers)
 	if err != nil {
-		errChan <- err
 		return ret, err
 	}
 	defer func() {
 		_ = response.Body.Close()
 	}()
 
+	if err := readError(d.Supplier(), response, d.errorBody); err != nil {
+		return ret, err
+	}
+
 	reader := bufio.NewReader(response.Body)
+	toolCalls := &toolCallBuilder{}
 
 	for {
 		line, err := reader.ReadBytes('\n')
@@ -180,18 +194,18 @@ func (d *DeepSeekServer) ChatStream(requestPath string, data []byte, msgCh chan
 		line = bytes.TrimSuffix(line, []byte("\n"))
 
 		if err != nil {
+			if ctx.Err() != nil {
+				return ret, ctx.Err()
+			}
+
 			if errors.Is(err, io.EOF) {
-				resErr := err
-				errStruct := &DeepSeekErrorInfo{}
-				if err := json.Unmarshal(line, errStruct); err == nil {
-					resErr = errors.New(errStruct.Error.Message)
+				if apiErr := d.errorBody(response, line); apiErr != nil {
+					return ret, apiErr
 				}
 
-				errChan <- resErr
-				return ret, resErr
+				return ret, err
 			}
 
-			errChan <- err
 			return ret, err
 		}
 
@@ -206,29 +220,31 @@ func (d *DeepSeekServer) ChatStream(requestPath string, data []byte, msgCh chan
 		line = bytes.TrimPrefix(line, headerData)
 
 		if string(line) == "[DONE]" {
-			close(msgCh)
 			break
 		}
 
 		retStruct := DeepSeekStreamResp{}
 		if err := json.Unmarshal(line, &retStruct); err != nil {
-			errChan <- err
-			return ret, err
+			return ret, newDecodeError(d.Supplier(), response, ret.RequestId, line, err)
 		}
 		if len(retStruct.Choices) == 0 {
 			continue
 		}
 		ret.ResponseText += retStruct.Choices[0].Delta.Content
-		msgCh <- retStruct.Choices[0].Delta.Content
+		toolCalls.add(retStruct.Choices[0].Delta.ToolCalls)
+		if err := handler(retStruct.Choices[0].Delta.Content); err != nil {
+			return ret, err
+		}
 
-		if retStruct.Choices[0].FinishReason == "stop" {
+		if len(retStruct.Choices[0].FinishReason) > 0 {
+			ret.finish(retStruct.Choices[0].FinishReason)
 			ret.RequestId = retStruct.Id
-			ret.PromptTokens = retStruct.Usage.PromptTokens
-			ret.CompletionTokens = retStruct.Usage.CompletionTokens
-			close(msgCh)
+			ret.setUsage(retStruct.Usage.usage())
 			break
 		}
 	}
 
+	ret.ToolCalls = toolCalls.result()
+
 	return ret, nil
 }
@@ -0,0 +1,481 @@
+package pkg_ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+/**
+ * 【Google】gemini
+ * Doc : https://ai.google.dev/api/generate-content
+ */
+
+type GeminiConf struct {
+	Url string `json:"url"`
+	Key string `json:"key"`
+}
+
+func NewGeminiConf(url, key string) *Config {
+	return &Config{GeminiUrl: url, GeminiKey: key}
+}
+
+type GeminiServer struct {
+	Conf GeminiConf `json:"conf"`
+
+	clientScope
+}
+
+func newGeminiServer(url, key string) *GeminiServer {
+	return &GeminiServer{
+		Conf: GeminiConf{
+			Url: url,
+			Key: key,
+		},
+	}
+}
+
+func (g *GeminiServer) Supplier() string {
+	return "gemini"
+}
+
+// RequestPath 接口根地址, 如 https://generativelanguage.googleapis.com/v1beta
+func (g *GeminiServer) RequestPath() string {
+	return g.Conf.Url
+}
+
+type GeminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+type GeminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	FunctionCall     *GeminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *GeminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type GeminiFunctionCall struct {
+	Id   string          `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type GeminiFunctionResponse struct {
+	Id       string          `json:"id,omitempty"`
+	Name     string          `json:"name"`
+	Response json.RawMessage `json:"response"`
+}
+
+type GeminiGenerationConfig struct {
+	StopSequences    []string `json:"stopSequences,omitempty"`
+	ResponseMimeType string   `json:"responseMimeType,omitempty"`
+	CandidateCount   int64    `json:"candidateCount,omitempty"`
+	MaxOutputTokens  int64    `json:"maxOutputTokens,omitempty"`
+	Temperature      float64  `json:"temperature,omitempty"`
+	TopP             float64  `json:"topP,omitempty"`
+	PresencePenalty  float64  `json:"presencePenalty,omitempty"`
+	FrequencyPenalty float64  `json:"frequencyPenalty,omitempty"`
+}
+
+type GeminiTool struct {
+	FunctionDeclarations []Tool `json:"functionDeclarations"`
+}
+
+type GeminiToolConfig struct {
+	FunctionCallingConfig struct {
+		Mode                 string   `json:"mode"`
+		AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+	} `json:"functionCallingConfig"`
+}
+
+// GeminiRequestBody 其中 Model 仅用于拼接请求地址, 发送前会从请求体中移除
+type GeminiRequestBody struct {
+	Model             string                 `json:"model"`
+	Contents          []GeminiContent        `json:"contents"`
+	SystemInstruction *GeminiContent         `json:"systemInstruction,omitempty"`
+	GenerationConfig  GeminiGenerationConfig `json:"generationConfig"`
+	Tools             []GeminiTool           `json:"tools,omitempty"`
+	ToolConfig        *GeminiToolConfig      `json:"toolConfig,omitempty"`
+}
+
+func (g *GeminiServer) Build(data RequestData, isStream bool) ([]byte, error) {
+	messages := data.messages()
+	if len(messages) == 0 || data.Model == "" {
+		return []byte{}, errors.New("问题、模型为必传字段")
+	}
+
+	request := &GeminiRequestBody{
+		Model:    data.Model,
+		Contents: make([]GeminiContent, 0, len(messages)),
+		GenerationConfig: GeminiGenerationConfig{
+			StopSequences:    data.Stop,
+			CandidateCount:   data.N,
+			MaxOutputTokens:  data.MaxTokens,
+			Temperature:      data.Temperature,
+			TopP:             data.TopP,
+			PresencePenalty:  data.PresencePenalty,
+			FrequencyPenalty: data.FrequencyPenalty,
+		},
+	}
+	if data.ResponseFormat == "json" || data.ResponseFormat == "json_object" {
+		request.GenerationConfig.ResponseMimeType = "application/json"
+	}
+
+	functionNames := make(map[string]string)
+	for _, message := range messages {
+		role, part := "user", GeminiPart{Text: message.Content}
+
+		switch message.Role {
+		case MessageSystem:
+			if request.SystemInstruction == nil {
+				request.SystemInstruction = &GeminiContent{}
+			}
+			request.SystemInstruction.Parts = append(request.SystemInstruction.Parts, part)
+			continue
+
+		case MessageAssistant:
+			role = "model"
+
+		case MessageTool:
+			name := message.Name
+			if len(name) == 0 {
+				name = functionNames[message.ToolCallId]
+			}
+			part = GeminiPart{FunctionResponse: &GeminiFunctionResponse{Name: name, Response: geminiFunctionResponse(message.Content)}}
+		}
+
+		parts := make([]GeminiPart, 0, len(message.ToolCalls)+1)
+		if len(part.Text) > 0 || part.FunctionResponse != nil {
+			parts = append(parts, part)
+		}
+		for _, toolCall := range message.ToolCalls {
+			functionNames[toolCall.Id] = toolCall.Function.Name
+
+			var args json.RawMessage
+			if len(toolCall.Function.Arguments) > 0 {
+				args = json.RawMessage(toolCall.Function.Arguments)
+			}
+			parts = append(parts, GeminiPart{FunctionCall: &GeminiFunctionCall{Name: toolCall.Function.Name, Args: args}})
+		}
+
+		// Gemini 要求 user 与 model 交替出现, 相邻的同角色消息合并
+		if last := len(request.Contents) - 1; last >= 0 && request.Contents[last].Role == role {
+			request.Contents[last].Parts = append(request.Contents[last].Parts, parts...)
+			continue
+		}
+		request.Contents = append(request.Contents, GeminiContent{Role: role, Parts: parts})
+	}
+
+	if len(data.Tools) > 0 {
+		request.Tools = []GeminiTool{{FunctionDeclarations: data.Tools}}
+	}
+	if len(data.ToolChoice) > 0 {
+		request.ToolConfig = &GeminiToolConfig{}
+		switch data.ToolChoice {
+		case "auto":
+			request.ToolConfig.FunctionCallingConfig.Mode = "AUTO"
+		case "none":
+			request.ToolConfig.FunctionCallingConfig.Mode = "NONE"
+		case "required":
+			request.ToolConfig.FunctionCallingConfig.Mode = "ANY"
+		default:
+			request.ToolConfig.FunctionCallingConfig.Mode = "ANY"
+			request.ToolConfig.FunctionCallingConfig.AllowedFunctionNames = []string{data.ToolChoice}
+		}
+	}
+
+	return json.Marshal(request)
+}
+
+// geminiFunctionResponse 工具结果需为 JSON 对象, 非对象内容包装为 {"content": ...}
+func geminiFunctionResponse(content string) json.RawMessage {
+	trimmed := strings.TrimSpace(content)
+	if strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed)) {
+		return json.RawMessage(trimmed)
+	}
+
+	response, _ := json.Marshal(map[string]string{"content": content})
+	return response
+}
+
+// requestUrl 从请求体中取出模型名称拼接请求地址, 并移除请求体中的 model 字段
+func (g *GeminiServer) requestUrl(requestPath string, data []byte, isStream bool) (string, []byte, error) {
+	body := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &body); err != nil {
+		return "", nil, err
+	}
+
+	model := ""
+	if raw, ok := body["model"]; ok {
+		_ = json.Unmarshal(raw, &model)
+		delete(body, "model")
+	}
+	model = strings.TrimPrefix(model, "models/")
+	if len(model) == 0 {
+		return "", nil, errors.New("请求体缺少 model 字段")
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return "", nil, err
+	}
+
+	requestUrl := strings.TrimSuffix(requestPath, "/") + "/models/" + url.PathEscape(model)
+	if isStream {
+		requestUrl += ":streamGenerateContent?alt=sse&key=" + url.QueryEscape(g.Conf.Key)
+	} else {
+		requestUrl += ":generateContent?key=" + url.QueryEscape(g.Conf.Key)
+	}
+
+	return requestUrl, payload, nil
+}
+
+type GeminiResponse struct {
+	Candidates []struct {
+		Content      GeminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+		Index        int64         `json:"index"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata struct {
+		PromptTokenCount        int64 `json:"promptTokenCount"`
+		CandidatesTokenCount    int64 `json:"candidatesTokenCount"`
+		TotalTokenCount         int64 `json:"totalTokenCount"`
+		CachedContentTokenCount int64 `json:"cachedContentTokenCount"`
+		ThoughtsTokenCount      int64 `json:"thoughtsTokenCount"`
+	} `json:"usageMetadata"`
+	ModelVersion string `json:"modelVersion"`
+	ResponseId   string `json:"responseId"`
+	Error        struct {
+		Code    int64  `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+// errorBody 解析错误响应, 兼容数组格式的错误结构, 不是错误结构时返回 nil
+func (g *GeminiServer) errorBody(response *http.Response, body []byte) *APIError {
+	errStructs := make([]GeminiResponse, 1)
+	if err := json.Unmarshal(body, &errStructs[0]); err != nil {
+		if err := json.Unmarshal(body, &errStructs); err != nil || len(errStructs) == 0 {
+			return nil
+		}
+	}
+	if len(errStructs[0].Error.Message) == 0 {
+		return nil
+	}
+
+	return newAPIError(g.Supplier(), response, "", errStructs[0].Error.Status, errStructs[0].Error.Message, geminiRetryable)
+}
+
+// finish 记录结束原因, Gemini 发起工具调用时结束原因同样为 STOP, 此时统一为 tool_calls
+func (g *GeminiServer) finish(ret *Response, finishReason string) {
+	ret.finish(finishReason)
+	if ret.FinishReason == FinishStop && len(ret.ToolCalls) > 0 {
+		ret.FinishReason = FinishToolCalls
+	}
+}
+
+// geminiRetryable 限流、服务不可用、内部错误及超时可重试
+func geminiRetryable(status int, code string) bool {
+	switch code {
+	case "RESOURCE_EXHAUSTED", "UNAVAILABLE", "INTERNAL", "DEADLINE_EXCEEDED":
+		return true
+	}
+
+	return retryableStatus(status, code)
+}
+
+// usage 输出 token 包含思考过程消耗的 token
+func (g *GeminiResponse) usage() Usage {
+	return Usage{
+		PromptTokens:       g.UsageMetadata.PromptTokenCount,
+		CompletionTokens:   g.UsageMetadata.CandidatesTokenCount + g.UsageMetadata.ThoughtsTokenCount,
+		TotalTokens:        g.UsageMetadata.TotalTokenCount,
+		CachedPromptTokens: g.UsageMetadata.CachedContentTokenCount,
+		ReasoningTokens:    g.UsageMetadata.ThoughtsTokenCount,
+	}
+}
+
+// parts 拼接文本内容并提取工具调用
+func (g *GeminiResponse) parts(callIndex int) (string, []ToolCall) {
+	text, toolCalls := "", make([]ToolCall, 0)
+	if len(g.Candidates) == 0 {
+		return text, toolCalls
+	}
+
+	for _, part := range g.Candidates[0].Content.Parts {
+		text += part.Text
+		if part.FunctionCall == nil {
+			continue
+		}
+
+		id := part.FunctionCall.Id
+		if len(id) == 0 {
+			id = fmt.Sprintf("call_%d", callIndex+len(toolCalls))
+		}
+		args := string(part.FunctionCall.Args)
+		if len(args) == 0 {
+			args = "{}"
+		}
+		toolCalls = append(toolCalls, ToolCall{Id: id, Type: "function", Function: ToolCallFunction{Name: part.FunctionCall.Name, Arguments: args}})
+	}
+
+	return text, toolCalls
+}
+
+func (g *GeminiServer) Chat(ctx context.Context, requestPath string, data []byte) (*Response, error) {
+	headers := map[string]string{"Content-Type": "application/json"}
+	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
+	ret.RequestHeader = RedactHeaders(headers)
+
+	requestUrl, payload, err := g.requestUrl(requestPath, data, false)
+	if err != nil {
+		return ret, err
+	}
+
+	response, err := g.post(ctx, requestUrl, string(payload), headers)
+	if err != nil {
+		return ret, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	retBytes, err := io.ReadAll(response.Body)
+	ret.ResponseData = append(ret.ResponseData, retBytes)
+	if err != nil {
+		return ret, err
+	}
+
+	if err := statusError(g.Supplier(), response, retBytes, g.errorBody); err != nil {
+		return ret, err
+	}
+
+	retStruct := GeminiResponse{}
+	if err := json.Unmarshal(retBytes, &retStruct); err != nil {
+		return ret, newDecodeError(g.Supplier(), response, "", retBytes, err)
+	}
+
+	ret.RequestId = retStruct.ResponseId
+	ret.setUsage(retStruct.usage())
+
+	if len(retStruct.Error.Message) > 0 {
+		return ret, newAPIError(g.Supplier(), response, ret.RequestId, retStruct.Error.Status, retStruct.Error.Message, geminiRetryable)
+	}
+
+	if len(retStruct.PromptFeedback.BlockReason) > 0 {
+		return ret, newContentFilterError(g.Supplier(), response, ret.RequestId, retStruct.PromptFeedback.BlockReason, "请求被拦截: "+retStruct.PromptFeedback.BlockReason)
+	}
+
+	if len(retStruct.Candidates) == 0 {
+		return ret, newEmptyError(g.Supplier(), response, ret.RequestId)
+	}
+
+	ret.ResponseText, ret.ToolCalls = retStruct.parts(0)
+	g.finish(ret, retStruct.Candidates[0].FinishReason)
+
+	return ret, nil
+}
+
+func (g *GeminiServer) ChatStream(ctx context.Context, requestPath string, data []byte, handler StreamHandler) (*Response, error) {
+	headers := map[string]string{"Content-Type": "application/json"}
+	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
+	ret.RequestHeader = RedactHeaders(headers)
+
+	requestUrl, payload, err := g.requestUrl(requestPath, data, true)
+	if err != nil {
+		return ret, err
+	}
+
+	response, err := g.post(ctx, requestUrl, string(payload), headers)
+	if err != nil {
+		return ret, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if err := readError(g.Supplier(), response, g.errorBody); err != nil {
+		return ret, err
+	}
+
+	reader := bufio.NewReader(response.Body)
+	unframed := make([]byte, 0)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		ret.ResponseData = append(ret.ResponseData, line)
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		line = bytes.TrimSuffix(line, []byte("\r"))
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return ret, ctx.Err()
+			}
+
+			// Gemini 流式响应没有结束标识, 收到结束原因后以 EOF 结束
+			if errors.Is(err, io.EOF) {
+				if len(ret.FinishReason) > 0 {
+					break
+				}
+
+				if apiErr := g.errorBody(response, append(unframed, line...)); apiErr != nil {
+					return ret, apiErr
+				}
+
+				return ret, err
+			}
+
+			return ret, err
+		}
+
+		headerData := []byte("data: ")
+		if !bytes.HasPrefix(line, headerData) {
+			unframed = append(unframed, line...)
+			continue
+		}
+		line = bytes.TrimPrefix(line, headerData)
+
+		retStruct := GeminiResponse{}
+		if err := json.Unmarshal(line, &retStruct); err != nil {
+			return ret, newDecodeError(g.Supplier(), response, ret.RequestId, line, err)
+		}
+
+		if len(retStruct.Error.Message) > 0 {
+			return ret, newAPIError(g.Supplier(), response, ret.RequestId, retStruct.Error.Status, retStruct.Error.Message, geminiRetryable)
+		}
+
+		if len(retStruct.PromptFeedback.BlockReason) > 0 {
+			return ret, newContentFilterError(g.Supplier(), response, ret.RequestId, retStruct.PromptFeedback.BlockReason, "请求被拦截: "+retStruct.PromptFeedback.BlockReason)
+		}
+
+		ret.RequestId = retStruct.ResponseId
+		ret.setUsage(retStruct.usage())
+
+		if len(retStruct.Candidates) == 0 {
+			continue
+		}
+
+		text, toolCalls := retStruct.parts(len(ret.ToolCalls))
+		ret.ResponseText += text
+		ret.ToolCalls = append(ret.ToolCalls, toolCalls...)
+		if err := handler(text); err != nil {
+			return ret, err
+		}
+
+		g.finish(ret, retStruct.Candidates[0].FinishReason)
+	}
+
+	return ret, nil
+}
@@ -3,10 +3,12 @@ package pkg_ai
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/jinzhu/copier"
 	"io"
+	"net/http"
 )
 
 /**
@@ -25,6 +27,8 @@ func NewGlmConf(url, key string) *Config {
 
 type GlmServer struct {
 	Conf GlmConf `json:"conf"`
+
+	clientScope
 }
 
 func newGlmServer(url, key string) *GlmServer {
@@ -45,16 +49,19 @@ func (g *GlmServer) RequestPath() string {
 }
 
 type GlmRequestBody struct {
-	Messages    []Message `json:"messages"`
-	Model       string    `json:"model"`
-	MaxTokens   int64     `json:"max_tokens,omitempty"`
-	Temperature float64   `json:"temperature,omitempty"`
-	TopP        float64   `json:"top_p,omitempty"`
-	Stream      bool      `json:"stream"`
+	Messages    []Message      `json:"messages"`
+	Model       string         `json:"model"`
+	MaxTokens   int64          `json:"max_tokens,omitempty"`
+	Temperature float64        `json:"temperature,omitempty"`
+	TopP        float64        `json:"top_p,omitempty"`
+	Stream      bool           `json:"stream"`
+	Tools       []FunctionTool `json:"tools,omitempty"`
+	ToolChoice  interface{}    `json:"tool_choice,omitempty"`
 }
 
-func (g *GlmServer) build(data RequestData, isStream bool) ([]byte, error) {
-	if data.UserQuery == "" || data.Model == "" {
+func (g *GlmServer) Build(data RequestData, isStream bool) ([]byte, error) {
+	messages := data.messages()
+	if len(messages) == 0 || data.Model == "" {
 		return []byte{}, errors.New("问题、模型为必传字段")
 	}
 
@@ -64,16 +71,9 @@ func (g *GlmServer) build(data RequestData, isStream bool) ([]byte, error) {
 		return nil, err
 	}
 
-	if data.SystemQuery != "" {
-		request.Messages = append(request.Messages, Message{Role: MessageSystem, Content: data.SystemQuery})
-	}
-	if data.History != nil && len(data.History) > 0 {
-		for _, detail := range data.History {
-			request.Messages = append(request.Messages, Message{Role: MessageUSer, Content: detail[0]})
-			request.Messages = append(request.Messages, Message{Role: MessageAssistant, Content: detail[1]})
-		}
-	}
-	request.Messages = append(request.Messages, Message{Role: MessageUSer, Content: data.UserQuery})
+	request.Messages = messages
+	request.Tools = data.functionTools()
+	request.ToolChoice = data.toolChoice()
 
 	return json.Marshal(request)
 }
@@ -83,31 +83,28 @@ type GlmChatResponse struct {
 		FinishReason string `json:"finish_reason"`
 		Index        int64  `json:"index"`
 		Message      struct {
-			Content string `json:"content"`
-			Role    string `json:"role"`
+			Content   string     `json:"content"`
+			Role      string     `json:"role"`
+			ToolCalls []ToolCall `json:"tool_calls"`
 		} `json:"message"`
 	} `json:"choices"`
-	Created   int64  `json:"created"`
-	Id        string `json:"id"`
-	Model     string `json:"model"`
-	RequestId string `json:"request_id"`
-	Usage     struct {
-		CompletionTokens int64 `json:"completion_tokens"`
-		PromptTokens     int64 `json:"prompt_tokens"`
-		TotalTokens      int64 `json:"total_tokens"`
-	} `json:"usage"`
-	Error struct {
+	Created   int64       `json:"created"`
+	Id        string      `json:"id"`
+	Model     string      `json:"model"`
+	RequestId string      `json:"request_id"`
+	Usage     OpenAIUsage `json:"usage"`
+	Error     struct {
 		Code    string `json:"code"`
 		Message string `json:"message"`
 	} `json:"error"`
 }
 
-func (g *GlmServer) Chat(requestPath string, data []byte) (*Response, error) {
+func (g *GlmServer) Chat(ctx context.Context, requestPath string, data []byte) (*Response, error) {
 	headers := map[string]string{"Authorization": "Bearer " + g.Conf.Key, "Content-Type": "application/json"}
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
-	ret.RequestHeader, _ = json.Marshal(headers)
+	ret.RequestHeader = RedactHeaders(headers)
 
-	response, err := postBase(requestPath, string(data), headers)
+	response, err := g.post(ctx, requestPath, string(data), headers)
 	if err != nil {
 		return ret, err
 	}
@@ -121,24 +118,29 @@ func (g *GlmServer) Chat(requestPath string, data []byte) (*Response, error) {
 		return ret, err
 	}
 
+	if err := statusError(g.Supplier(), response, retBytes, g.errorBody); err != nil {
+		return ret, err
+	}
+
 	retStruct := GlmChatResponse{}
 	if err := json.Unmarshal(retBytes, &retStruct); err != nil {
-		return ret, err
+		return ret, newDecodeError(g.Supplier(), response, "", retBytes, err)
 	}
 
 	ret.RequestId = retStruct.Id
-	ret.PromptTokens = retStruct.Usage.PromptTokens
-	ret.CompletionTokens = retStruct.Usage.CompletionTokens
+	ret.setUsage(retStruct.Usage.usage())
 
 	if len(retStruct.Error.Message) > 0 {
-		return ret, errors.New(retStruct.Error.Message)
+		return ret, newAPIError(g.Supplier(), response, ret.RequestId, retStruct.Error.Code, retStruct.Error.Message, glmRetryable)
 	}
 
 	if len(retStruct.Choices) == 0 {
-		return ret, errors.New("无有效响应数据")
+		return ret, newEmptyError(g.Supplier(), response, ret.RequestId)
 	}
 
 	ret.ResponseText = retStruct.Choices[0].Message.Content
+	ret.finish(retStruct.Choices[0].FinishReason)
+	ret.ToolCalls = retStruct.Choices[0].Message.ToolCalls
 
 	return ret, nil
 }
@@ -151,15 +153,12 @@ type GlmStreamResp struct {
 		Index        int64  `json:"index"`
 		FinishReason string `json:"finish_reason"`
 		Delta        struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
+			Role      string          `json:"role"`
+			Content   string          `json:"content"`
+			ToolCalls []ToolCallDelta `json:"tool_calls"`
 		} `json:"delta"`
 	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int64 `json:"prompt_tokens"`
-		CompletionTokens int64 `json:"completion_tokens"`
-		TotalTokens      int64 `json:"total_tokens"`
-	} `json:"usage"`
+	Usage OpenAIUsage `json:"usage"`
 	Error struct {
 		Code    string `json:"code"`
 		Message string `json:"message"`
@@ -173,21 +172,45 @@ type GlmErrorInfo struct {
 	} `json:"error"`
 }
 
-func (g *GlmServer) ChatStream(requestPath string, data []byte, msgCh chan string, errChan chan error) (*Response, error) {
+// errorBody 解析错误响应, 不是错误结构时返回 nil
+func (g *GlmServer) errorBody(response *http.Response, body []byte) *APIError {
+	errStruct := &GlmErrorInfo{}
+	if err := json.Unmarshal(body, errStruct); err != nil || len(errStruct.Error.Message) == 0 {
+		return nil
+	}
+
+	return newAPIError(g.Supplier(), response, "", errStruct.Error.Code, errStruct.Error.Message, glmRetryable)
+}
+
+// glmRetryable 1302 并发超限、1303 频率超限、1305 请求过多、500 内部错误可重试
+func glmRetryable(status int, code string) bool {
+	switch code {
+	case "1302", "1303", "1305", "500":
+		return true
+	}
+
+	return retryableStatus(status, code)
+}
+
+func (g *GlmServer) ChatStream(ctx context.Context, requestPath string, data []byte, handler StreamHandler) (*Response, error) {
 	headers := map[string]string{"Authorization": "Bearer " + g.Conf.Key, "Content-Type": "application/json"}
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
-	ret.RequestHeader, _ = json.Marshal(headers)
+	ret.RequestHeader = RedactHeaders(headers)
 
-	response, err := postBase(requestPath, string(data), headers)
+	response, err := g.post(ctx, requestPath, string(data), headers)
 	if err != nil {
-		errChan <- err
 		return ret, err
 	}
 	defer func() {
 		_ = response.Body.Close()
 	}()
 
+	if err := readError(g.Supplier(), response, g.errorBody); err != nil {
+		return ret, err
+	}
+
 	reader := bufio.NewReader(response.Body)
+	toolCalls := &toolCallBuilder{}
 
 	for {
 		line, err := reader.ReadBytes('\n')
@@ -195,19 +218,18 @@ func (g *GlmServer) ChatStream(requestPath string, data []byte, msgCh chan strin
 		line = bytes.TrimSuffix(line, []byte("\n"))
 
 		if err != nil {
+			if ctx.Err() != nil {
+				return ret, ctx.Err()
+			}
 
 			if errors.Is(err, io.EOF) {
-				resErr := err
-				errStruct := &GlmErrorInfo{}
-				if err := json.Unmarshal(line, errStruct); err == nil {
-					resErr = errors.New(errStruct.Error.Message)
+				if apiErr := g.errorBody(response, line); apiErr != nil {
+					return ret, apiErr
 				}
 
-				errChan <- resErr
-				return ret, resErr
+				return ret, err
 			}
 
-			errChan <- err
 			return ret, err
 		}
 
@@ -222,18 +244,15 @@ func (g *GlmServer) ChatStream(requestPath string, data []byte, msgCh chan strin
 		line = bytes.TrimPrefix(line, headerData)
 
 		if string(line) == "[DONE]" {
-			close(msgCh)
 			break
 		}
 
 		retStruct := GlmStreamResp{}
 		if err := json.Unmarshal(line, &retStruct); err != nil {
-			errChan <- err
-			return ret, err
+			return ret, newDecodeError(g.Supplier(), response, ret.RequestId, line, err)
 		}
 		if len(retStruct.Error.Message) > 0 {
-			err := errors.New(retStruct.Error.Message)
-			errChan <- err
+			err := newAPIError(g.Supplier(), response, ret.RequestId, retStruct.Error.Code, retStruct.Error.Message, glmRetryable)
 			return ret, err
 		}
 
@@ -242,16 +261,20 @@ func (g *GlmServer) ChatStream(requestPath string, data []byte, msgCh chan strin
 		}
 
 		ret.ResponseText += retStruct.Choices[0].Delta.Content
-		msgCh <- retStruct.Choices[0].Delta.Content
+		toolCalls.add(retStruct.Choices[0].Delta.ToolCalls)
+		if err := handler(retStruct.Choices[0].Delta.Content); err != nil {
+			return ret, err
+		}
 
-		if retStruct.Choices[0].FinishReason == "stop" {
+		if len(retStruct.Choices[0].FinishReason) > 0 {
+			ret.finish(retStruct.Choices[0].FinishReason)
 			ret.RequestId = retStruct.Id
-			ret.PromptTokens = retStruct.Usage.PromptTokens
-			ret.CompletionTokens = retStruct.Usage.CompletionTokens
-			close(msgCh)
+			ret.setUsage(retStruct.Usage.usage())
 			break
 		}
 	}
 
+	ret.ToolCalls = toolCalls.result()
+
 	return ret, nil
 }
@@ -3,12 +3,15 @@ package pkg_ai
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -29,6 +32,8 @@ func NewHunyuanConf(url, clientId, clientSecret string) *Config {
 
 type HunyuanServer struct {
 	Conf HunyuanConf `json:"conf"`
+
+	clientScope
 }
 
 func newHunyuanServer(url, clientId, clientSecret string) *HunyuanServer {
@@ -55,25 +60,52 @@ type HunyuanRequestBody struct {
 	Stream      bool             `json:"Stream"`
 	TopP        float64          `json:"TopP,omitempty"`
 	Temperature float64          `json:"Temperature,omitempty"`
+	Tools       []HunyuanTool    `json:"Tools,omitempty"`
+	ToolChoice  string           `json:"ToolChoice,omitempty"`
+	CustomTool  *HunyuanTool     `json:"CustomTool,omitempty"`
 }
 
-func (h *HunyuanServer) build(data RequestData, isStream bool) ([]byte, error) {
-	if data.UserQuery == "" || data.Model == "" {
+func (h *HunyuanServer) Build(data RequestData, isStream bool) ([]byte, error) {
+	messages := data.messages()
+	if len(messages) == 0 || data.Model == "" {
 		return []byte{}, errors.New("问题、模型为必传字段")
 	}
 
 	request := &HunyuanRequestBody{Stream: isStream, Messages: make([]HunyuanMessage, 0), Model: data.Model}
 
-	if data.SystemQuery != "" {
-		request.Messages = append(request.Messages, HunyuanMessage{Role: MessageSystem, Content: data.SystemQuery})
+	for _, message := range messages {
+		hunyuanMessage := HunyuanMessage{Role: message.Role, Content: message.Content, ToolCallId: message.ToolCallId}
+		for _, toolCall := range message.ToolCalls {
+			hunyuanMessage.ToolCalls = append(hunyuanMessage.ToolCalls, HunyuanToolCall{
+				Id:       toolCall.Id,
+				Type:     toolCall.Type,
+				Function: HunyuanToolCallFunction{Name: toolCall.Function.Name, Arguments: toolCall.Function.Arguments},
+			})
+		}
+		request.Messages = append(request.Messages, hunyuanMessage)
 	}
-	if data.History != nil && len(data.History) > 0 {
-		for _, detail := range data.History {
-			request.Messages = append(request.Messages, HunyuanMessage{Role: MessageUSer, Content: detail[0]})
-			request.Messages = append(request.Messages, HunyuanMessage{Role: MessageAssistant, Content: detail[1]})
+
+	for _, tool := range data.Tools {
+		parameters, err := tool.parametersJSON()
+		if err != nil {
+			return nil, err
 		}
+
+		hunyuanTool := HunyuanTool{Type: "function", Function: HunyuanToolFunction{Name: tool.Name, Parameters: parameters, Description: tool.Description}}
+		request.Tools = append(request.Tools, hunyuanTool)
+
+		// 混元仅支持 none、auto、custom, 指定函数名时通过 CustomTool 强制调用
+		if tool.Name == data.ToolChoice {
+			request.ToolChoice = "custom"
+			request.CustomTool = &hunyuanTool
+		}
+	}
+	switch data.ToolChoice {
+	case "auto", "none":
+		request.ToolChoice = data.ToolChoice
+	case "required":
+		request.ToolChoice = "auto"
 	}
-	request.Messages = append(request.Messages, HunyuanMessage{Role: MessageUSer, Content: data.UserQuery})
 
 	if data.TopP > 0 {
 		request.TopP = data.TopP
@@ -138,26 +170,23 @@ type HunyuanChatResponse struct {
 		Note      string `json:"Note"`
 		Choices   []struct {
 			Message struct {
-				Role    string `json:"Role"`
-				Content string `json:"Content"`
+				Role      string            `json:"Role"`
+				Content   string            `json:"Content"`
+				ToolCalls []HunyuanToolCall `json:"ToolCalls"`
 			} `json:"Message"`
 			FinishReason string `json:"FinishReason"`
 		} `json:"Choices"`
-		Created int64  `json:"Created"`
-		Id      string `json:"Id"`
-		Usage   struct {
-			PromptTokens     int64 `json:"PromptTokens"`
-			CompletionTokens int64 `json:"CompletionTokens"`
-			TotalTokens      int64 `json:"TotalTokens"`
-		} `json:"Usage"`
-		Error struct {
+		Created int64        `json:"Created"`
+		Id      string       `json:"Id"`
+		Usage   HunyuanUsage `json:"Usage"`
+		Error   struct {
 			Code    string `json:"Code"`
 			Message string `json:"Message"`
 		} `json:"Error"`
 	} `json:"Response"`
 }
 
-func (h *HunyuanServer) Chat(requestPath string, data []byte) (*Response, error) {
+func (h *HunyuanServer) Chat(ctx context.Context, requestPath string, data []byte) (*Response, error) {
 	timestamp := time.Now().Unix()
 	headers := map[string]string{
 		"Authorization":  h.token(data, timestamp),
@@ -168,9 +197,9 @@ func (h *HunyuanServer) Chat(requestPath string, data []byte) (*Response, error)
 		"content-type":   "application/json",
 	}
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
-	ret.RequestHeader, _ = json.Marshal(headers)
+	ret.RequestHeader = RedactHeaders(headers)
 
-	response, err := postBase(requestPath, string(data), headers)
+	response, err := h.post(ctx, requestPath, string(data), headers)
 	if err != nil {
 		return ret, err
 	}
@@ -184,24 +213,29 @@ func (h *HunyuanServer) Chat(requestPath string, data []byte) (*Response, error)
 		return ret, err
 	}
 
+	if err := statusError(h.Supplier(), response, retBytes, h.errorBody); err != nil {
+		return ret, err
+	}
+
 	retStruct := HunyuanChatResponse{}
 	if err := json.Unmarshal(retBytes, &retStruct); err != nil {
-		return ret, err
+		return ret, newDecodeError(h.Supplier(), response, "", retBytes, err)
 	}
 
 	ret.RequestId = retStruct.Response.RequestID
-	ret.PromptTokens = retStruct.Response.Usage.PromptTokens
-	ret.CompletionTokens = retStruct.Response.Usage.CompletionTokens
+	ret.setUsage(retStruct.Response.Usage.usage())
 
 	if len(retStruct.Response.Error.Message) > 0 {
-		return ret, errors.New(retStruct.Response.Error.Message)
+		return ret, newAPIError(h.Supplier(), response, ret.RequestId, retStruct.Response.Error.Code, retStruct.Response.Error.Message, hunyuanRetryable)
 	}
 
 	if len(retStruct.Response.Choices) == 0 {
-		return ret, errors.New("无有效响应数据")
+		return ret, newEmptyError(h.Supplier(), response, ret.RequestId)
 	}
 
 	ret.ResponseText = retStruct.Response.Choices[0].Message.Content
+	ret.finish(retStruct.Response.Choices[0].FinishReason)
+	ret.ToolCalls = hunyuanToolCalls(retStruct.Response.Choices[0].Message.ToolCalls)
 
 	return ret, nil
 }
@@ -216,22 +250,50 @@ type HunyuanErrorInfo struct {
 	} `json:"Response"`
 }
 
+// errorBody 解析错误响应, 不是错误结构时返回 nil
+func (h *HunyuanServer) errorBody(response *http.Response, body []byte) *APIError {
+	errStruct := &HunyuanErrorInfo{}
+	if err := json.Unmarshal(body, errStruct); err != nil || len(errStruct.Response.Error.Message) == 0 {
+		return nil
+	}
+
+	return newAPIError(h.Supplier(), response, errStruct.Response.RequestID, errStruct.Response.Error.Code, errStruct.Response.Error.Message, hunyuanRetryable)
+}
+
+type HunyuanUsage struct {
+	PromptTokens     int64 `json:"PromptTokens"`
+	CompletionTokens int64 `json:"CompletionTokens"`
+	TotalTokens      int64 `json:"TotalTokens"`
+}
+
+func (u *HunyuanUsage) usage() Usage {
+	return Usage{PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens, TotalTokens: u.TotalTokens}
+}
+
+// hunyuanRetryable 限流、引擎超时及内部错误可重试
+func hunyuanRetryable(status int, code string) bool {
+	for _, prefix := range []string{"RequestLimitExceeded", "LimitExceeded", "InternalError", "FailedOperation.EngineRequestTimeout", "FailedOperation.EngineServerError", "FailedOperation.EngineServerLimitExceeded"} {
+		if strings.HasPrefix(code, prefix) {
+			return true
+		}
+	}
+
+	return retryableStatus(status, code)
+}
+
 type HunyuanStreamResp struct {
 	Note    string `json:"Note"`
 	Choices []struct {
 		Delta struct {
-			Role    string `json:"Role"`
-			Content string `json:"Content"`
+			Role      string            `json:"Role"`
+			Content   string            `json:"Content"`
+			ToolCalls []HunyuanToolCall `json:"ToolCalls"`
 		} `json:"Delta"`
 		FinishReason string `json:"FinishReason"`
 	} `json:"Choices"`
-	Created int64  `json:"Created"`
-	Id      string `json:"Id"`
-	Usage   struct {
-		PromptTokens     int64 `json:"PromptTokens"`
-		CompletionTokens int64 `json:"CompletionTokens"`
-		TotalTokens      int64 `json:"TotalTokens"`
-	} `json:"Usage"`
+	Created  int64        `json:"Created"`
+	Id       string       `json:"Id"`
+	Usage    HunyuanUsage `json:"Usage"`
 	Response struct {
 		Error struct {
 			Code    string `json:"Code"`
@@ -241,7 +303,7 @@ type HunyuanStreamResp struct {
 	} `json:"Response"`
 }
 
-func (h *HunyuanServer) ChatStream(requestPath string, data []byte, msgCh chan string, errChan chan error) (*Response, error) {
+func (h *HunyuanServer) ChatStream(ctx context.Context, requestPath string, data []byte, handler StreamHandler) (*Response, error) {
 	timestamp := time.Now().Unix()
 	headers := map[string]string{
 		"Authorization":  h.token(data, timestamp),
@@ -252,18 +314,22 @@ func (h *HunyuanServer) ChatStream(requestPath string, data []byte, msgCh chan s
 		"content-type":   "application/json",
 	}
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
-	ret.RequestHeader, _ = json.Marshal(headers)
+	ret.RequestHeader = RedactHeaders(headers)
 
-	response, err := postBase(requestPath, string(data), headers)
+	response, err := h.post(ctx, requestPath, string(data), headers)
 	if err != nil {
-		errChan <- err
 		return ret, err
 	}
 	defer func() {
 		_ = response.Body.Close()
 	}()
 
+	if err := readError(h.Supplier(), response, h.errorBody); err != nil {
+		return ret, err
+	}
+
 	reader := bufio.NewReader(response.Body)
+	toolCalls := &toolCallBuilder{}
 
 	for {
 		line, err := reader.ReadBytes('\n')
@@ -271,19 +337,18 @@ func (h *HunyuanServer) ChatStream(requestPath string, data []byte, msgCh chan s
 		line = bytes.TrimSuffix(line, []byte("\n"))
 
 		if err != nil {
+			if ctx.Err() != nil {
+				return ret, ctx.Err()
+			}
 
 			if errors.Is(err, io.EOF) {
-				resErr := err
-				errStruct := &HunyuanErrorInfo{}
-				if err := json.Unmarshal(line, errStruct); err == nil {
-					resErr = errors.New(errStruct.Response.Error.Message)
+				if apiErr := h.errorBody(response, line); apiErr != nil {
+					return ret, apiErr
 				}
 
-				errChan <- resErr
-				return ret, resErr
+				return ret, err
 			}
 
-			errChan <- err
 			return ret, err
 		}
 
@@ -299,12 +364,10 @@ func (h *HunyuanServer) ChatStream(requestPath string, data []byte, msgCh chan s
 
 		retStruct := HunyuanStreamResp{}
 		if err := json.Unmarshal(line, &retStruct); err != nil {
-			errChan <- err
-			return ret, err
+			return ret, newDecodeError(h.Supplier(), response, ret.RequestId, line, err)
 		}
 		if len(retStruct.Response.Error.Message) > 0 {
-			err := errors.New(retStruct.Response.Error.Message)
-			errChan <- err
+			err := newAPIError(h.Supplier(), response, ret.RequestId, retStruct.Response.Error.Code, retStruct.Response.Error.Message, hunyuanRetryable)
 			return ret, err
 		}
 
@@ -312,17 +375,42 @@ func (h *HunyuanServer) ChatStream(requestPath string, data []byte, msgCh chan s
 			continue
 		}
 
-		if retStruct.Choices[0].FinishReason == "stop" {
+		for _, toolCall := range retStruct.Choices[0].Delta.ToolCalls {
+			toolCalls.add([]ToolCallDelta{{
+				Index:    toolCall.Index,
+				Id:       toolCall.Id,
+				Type:     toolCall.Type,
+				Function: ToolCallFunction{Name: toolCall.Function.Name, Arguments: toolCall.Function.Arguments},
+			}})
+		}
+
+		ret.ResponseText += retStruct.Choices[0].Delta.Content
+		if err := handler(retStruct.Choices[0].Delta.Content); err != nil {
+			return ret, err
+		}
+
+		if len(retStruct.Choices[0].FinishReason) > 0 {
+			ret.finish(retStruct.Choices[0].FinishReason)
 			ret.RequestId = retStruct.Id
-			ret.PromptTokens = retStruct.Usage.PromptTokens
-			ret.CompletionTokens = retStruct.Usage.CompletionTokens
-			close(msgCh)
+			ret.setUsage(retStruct.Usage.usage())
 			break
-		} else {
-			ret.ResponseText += retStruct.Choices[0].Delta.Content
-			msgCh <- retStruct.Choices[0].Delta.Content
 		}
 	}
 
+	ret.ToolCalls = toolCalls.result()
+
 	return ret, nil
 }
+
+func hunyuanToolCalls(hunyuanToolCalls []HunyuanToolCall) []ToolCall {
+	var toolCalls []ToolCall
+	for _, toolCall := range hunyuanToolCalls {
+		toolCalls = append(toolCalls, ToolCall{
+			Id:       toolCall.Id,
+			Type:     toolCall.Type,
+			Function: ToolCallFunction{Name: toolCall.Function.Name, Arguments: toolCall.Function.Arguments},
+		})
+	}
+
+	return toolCalls
+}
@@ -3,10 +3,12 @@ package pkg_ai
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/jinzhu/copier"
 	"io"
+	"net/http"
 )
 
 /**
@@ -25,6 +27,8 @@ func NewMinimaxiConf(url, key string) *Config {
 
 type MinimaxiServer struct {
 	Conf MinimaxiConf `json:"conf"`
+
+	clientScope
 }
 
 func newMinimaxiServer(url, key string) *MinimaxiServer {
@@ -45,18 +49,30 @@ func (m *MinimaxiServer) RequestPath() string {
 }
 
 type MinimaxiRequestBody struct {
-	Messages          []Message `json:"messages"`
-	Model             string    `json:"model"`
-	MaxTokens         int64     `json:"max_tokens,omitempty"`
-	Temperature       float64   `json:"temperature,omitempty"`
-	TopP              float64   `json:"top_p,omitempty"`
-	N                 int64     `json:"n,omitempty"`
-	MaskSensitiveInfo bool      `json:"mask_sensitive_info,omitempty"`
-	Stream            bool      `json:"stream"`
+	Messages          []Message      `json:"messages"`
+	Model             string         `json:"model"`
+	MaxTokens         int64          `json:"max_tokens,omitempty"`
+	Temperature       float64        `json:"temperature,omitempty"`
+	TopP              float64        `json:"top_p,omitempty"`
+	N                 int64          `json:"n,omitempty"`
+	MaskSensitiveInfo bool           `json:"mask_sensitive_info,omitempty"`
+	Stream            bool           `json:"stream"`
+	Tools             []MinimaxiTool `json:"tools,omitempty"`
+	ToolChoice        string         `json:"tool_choice,omitempty"`
+}
+
+type MinimaxiTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string `json:"name"`
+		Description string `json:"description,omitempty"`
+		Parameters  string `json:"parameters"`
+	} `json:"function"`
 }
 
-func (m *MinimaxiServer) build(data RequestData, isStream bool) ([]byte, error) {
-	if data.UserQuery == "" || data.Model == "" {
+func (m *MinimaxiServer) Build(data RequestData, isStream bool) ([]byte, error) {
+	messages := data.messages()
+	if len(messages) == 0 || data.Model == "" {
 		return []byte{}, errors.New("问题、模型为必传字段")
 	}
 
@@ -66,16 +82,28 @@ func (m *MinimaxiServer) build(data RequestData, isStream bool) ([]byte, error)
 		return nil, err
 	}
 
-	if data.SystemQuery != "" {
-		request.Messages = append(request.Messages, Message{Role: MessageSystem, Content: data.SystemQuery})
-	}
-	if data.History != nil && len(data.History) > 0 {
-		for _, detail := range data.History {
-			request.Messages = append(request.Messages, Message{Role: MessageUSer, Content: detail[0]})
-			request.Messages = append(request.Messages, Message{Role: MessageAssistant, Content: detail[1]})
+	request.Messages = messages
+	request.Tools = make([]MinimaxiTool, 0, len(data.Tools))
+	for _, tool := range data.Tools {
+		parameters, err := tool.parametersJSON()
+		if err != nil {
+			return nil, err
 		}
+
+		minimaxiTool := MinimaxiTool{Type: "function"}
+		minimaxiTool.Function.Name = tool.Name
+		minimaxiTool.Function.Description = tool.Description
+		minimaxiTool.Function.Parameters = parameters
+		request.Tools = append(request.Tools, minimaxiTool)
+	}
+
+	// minimaxi 仅支持 none、auto
+	switch data.ToolChoice {
+	case "", "none":
+		request.ToolChoice = data.ToolChoice
+	default:
+		request.ToolChoice = "auto"
 	}
-	request.Messages = append(request.Messages, Message{Role: MessageUSer, Content: data.UserQuery})
 
 	return json.Marshal(request)
 }
@@ -86,36 +114,34 @@ type MinimaxiResponse struct {
 		FinishReason string `json:"finish_reason"`
 		Index        int    `json:"index"`
 		Message      struct {
-			Content      string `json:"content"`
-			Role         string `json:"role"`
-			Name         string `json:"name"`
-			AudioContent string `json:"audio_content"`
+			Content      string     `json:"content"`
+			Role         string     `json:"role"`
+			Name         string     `json:"name"`
+			AudioContent string     `json:"audio_content"`
+			ToolCalls    []ToolCall `json:"tool_calls"`
 		} `json:"message"`
 	} `json:"choices"`
-	Created int    `json:"created"`
-	Model   string `json:"model"`
-	Object  string `json:"object"`
-	Usage   struct {
-		TotalTokens     int64 `json:"total_tokens"`
-		TotalCharacters int64 `json:"total_characters"`
-	} `json:"usage"`
-	InputSensitive      bool `json:"input_sensitive"`
-	OutputSensitive     bool `json:"output_sensitive"`
-	InputSensitiveType  int  `json:"input_sensitive_type"`
-	OutputSensitiveType int  `json:"output_sensitive_type"`
-	OutputSensitiveInt  int  `json:"output_sensitive_int"`
+	Created             int         `json:"created"`
+	Model               string      `json:"model"`
+	Object              string      `json:"object"`
+	Usage               OpenAIUsage `json:"usage"`
+	InputSensitive      bool        `json:"input_sensitive"`
+	OutputSensitive     bool        `json:"output_sensitive"`
+	InputSensitiveType  int         `json:"input_sensitive_type"`
+	OutputSensitiveType int         `json:"output_sensitive_type"`
+	OutputSensitiveInt  int         `json:"output_sensitive_int"`
 	BaseResp            struct {
 		StatusCode int64  `json:"status_code"`
 		StatusMsg  string `json:"status_msg"`
 	} `json:"base_resp"`
 }
 
-func (m *MinimaxiServer) Chat(requestPath string, data []byte) (*Response, error) {
+func (m *MinimaxiServer) Chat(ctx context.Context, requestPath string, data []byte) (*Response, error) {
 	headers := map[string]string{"Authorization": "Bearer " + m.Conf.Key, "Content-Type": "application/json"}
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
-	ret.RequestHeader, _ = json.Marshal(headers)
+	ret.RequestHeader = RedactHeaders(headers)
 
-	response, err := postBase(requestPath, string(data), headers)
+	response, err := m.post(ctx, requestPath, string(data), headers)
 	if err != nil {
 		return ret, err
 	}
@@ -129,23 +155,29 @@ func (m *MinimaxiServer) Chat(requestPath string, data []byte) (*Response, error
 		return ret, err
 	}
 
+	if err := statusError(m.Supplier(), response, retBytes, m.errorBody); err != nil {
+		return ret, err
+	}
+
 	retStruct := MinimaxiResponse{}
 	if err := json.Unmarshal(retBytes, &retStruct); err != nil {
-		return ret, err
+		return ret, newDecodeError(m.Supplier(), response, "", retBytes, err)
 	}
 
 	ret.RequestId = retStruct.Id
-	ret.CompletionTokens = retStruct.Usage.TotalTokens
+	ret.setUsage(m.usage(&retStruct.Usage))
 
 	if retStruct.BaseResp.StatusCode != 0 {
-		return ret, errors.New(retStruct.BaseResp.StatusMsg)
+		return ret, newAPIError(m.Supplier(), response, ret.RequestId, errorCode(retStruct.BaseResp.StatusCode), retStruct.BaseResp.StatusMsg, minimaxiRetryable)
 	}
 
 	if len(retStruct.Choices) == 0 {
-		return ret, errors.New("无有效响应数据")
+		return ret, newEmptyError(m.Supplier(), response, ret.RequestId)
 	}
 
 	ret.ResponseText = retStruct.Choices[0].Message.Content
+	ret.finish(retStruct.Choices[0].FinishReason)
+	ret.ToolCalls = retStruct.Choices[0].Message.ToolCalls
 
 	return ret, nil
 }
@@ -157,57 +189,90 @@ type MinimaxiErrorInfo struct {
 	} `json:"base_resp"`
 }
 
+// errorBody 解析错误响应, 不是错误结构时返回 nil
+func (m *MinimaxiServer) errorBody(response *http.Response, body []byte) *APIError {
+	errStruct := &MinimaxiErrorInfo{}
+	if err := json.Unmarshal(body, errStruct); err != nil || errStruct.BaseResp.StatusCode == 0 {
+		return nil
+	}
+
+	return newAPIError(m.Supplier(), response, "", errorCode(errStruct.BaseResp.StatusCode), errStruct.BaseResp.StatusMsg, minimaxiRetryable)
+}
+
+// usage 旧版接口仅返回 total_tokens, 此时全部计为输出 token
+func (m *MinimaxiServer) usage(u *OpenAIUsage) Usage {
+	usage := u.usage()
+	if usage.PromptTokens == 0 && usage.CompletionTokens == 0 {
+		usage.CompletionTokens = usage.TotalTokens
+	}
+
+	return usage
+}
+
+// minimaxiRetryable 1000 未知错误、1001 超时、1002 RPM 限流、1013 服务内部错误、1039 TPM 限流可重试
+func minimaxiRetryable(status int, code string) bool {
+	switch code {
+	case "1000", "1001", "1002", "1013", "1039":
+		return true
+	}
+
+	return retryableStatus(status, code)
+}
+
 type MinimaxiStreamResp struct {
 	Id      string `json:"id"`
 	Choices []struct {
 		FinishReason string `json:"finish_reason"`
 		Index        int    `json:"index"`
 		Message      struct {
-			Content      string `json:"content"`
-			Role         string `json:"role"`
-			Name         string `json:"name"`
-			AudioContent string `json:"audio_content"`
+			Content      string     `json:"content"`
+			Role         string     `json:"role"`
+			Name         string     `json:"name"`
+			AudioContent string     `json:"audio_content"`
+			ToolCalls    []ToolCall `json:"tool_calls"`
 		} `json:"message"`
 		Delta struct {
-			Content      string `json:"content"`
-			Role         string `json:"role"`
-			Name         string `json:"name"`
-			AudioContent string `json:"audio_content"`
+			Content      string          `json:"content"`
+			Role         string          `json:"role"`
+			Name         string          `json:"name"`
+			AudioContent string          `json:"audio_content"`
+			ToolCalls    []ToolCallDelta `json:"tool_calls"`
 		} `json:"delta"`
 	} `json:"choices"`
-	Created int    `json:"created"`
-	Model   string `json:"model"`
-	Object  string `json:"object"`
-	Usage   struct {
-		TotalTokens     int64 `json:"total_tokens"`
-		TotalCharacters int64 `json:"total_characters"`
-	} `json:"usage"`
-	InputSensitive      bool `json:"input_sensitive"`
-	OutputSensitive     bool `json:"output_sensitive"`
-	InputSensitiveType  int  `json:"input_sensitive_type"`
-	OutputSensitiveType int  `json:"output_sensitive_type"`
-	OutputSensitiveInt  int  `json:"output_sensitive_int"`
+	Created             int         `json:"created"`
+	Model               string      `json:"model"`
+	Object              string      `json:"object"`
+	Usage               OpenAIUsage `json:"usage"`
+	InputSensitive      bool        `json:"input_sensitive"`
+	OutputSensitive     bool        `json:"output_sensitive"`
+	InputSensitiveType  int         `json:"input_sensitive_type"`
+	OutputSensitiveType int         `json:"output_sensitive_type"`
+	OutputSensitiveInt  int         `json:"output_sensitive_int"`
 	BaseResp            struct {
 		StatusCode int64  `json:"status_code"`
 		StatusMsg  string `json:"status_msg"`
 	} `json:"base_resp"`
 }
 
-func (m *MinimaxiServer) ChatStream(requestPath string, data []byte, msgCh chan string, errChan chan error) (*Response, error) {
+func (m *MinimaxiServer) ChatStream(ctx context.Context, requestPath string, data []byte, handler StreamHandler) (*Response, error) {
 	headers := map[string]string{"Authorization": "Bearer " + m.Conf.Key, "Content-Type": "application/json"}
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
-	ret.RequestHeader, _ = json.Marshal(headers)
+	ret.RequestHeader = RedactHeaders(headers)
 
-	response, err := postBase(requestPath, string(data), headers)
+	response, err := m.post(ctx, requestPath, string(data), headers)
 	if err != nil {
-		errChan <- err
 		return ret, err
 	}
 	defer func() {
 		_ = response.Body.Close()
 	}()
 
+	if err := readError(m.Supplier(), response, m.errorBody); err != nil {
+		return ret, err
+	}
+
 	reader := bufio.NewReader(response.Body)
+	toolCalls := &toolCallBuilder{}
 
 	for {
 		line, _, err := reader.ReadLine()
@@ -215,19 +280,23 @@ func (m *MinimaxiServer) ChatStream(requestPath string, data []byte, msgCh chan
 		line = bytes.TrimSuffix(line, []byte("\n"))
 
 		if err != nil {
+			if ctx.Err() != nil {
+				return ret, ctx.Err()
+			}
 
 			if errors.Is(err, io.EOF) {
-				resErr := err
-				errStruct := &MinimaxiErrorInfo{}
-				if err := json.Unmarshal(line, errStruct); err == nil {
-					resErr = errors.New(errStruct.BaseResp.StatusMsg)
+				// 收到结束原因后仍继续读取汇总数据, 此时断开视为正常结束
+				if len(ret.FinishReason) > 0 {
+					break
+				}
+
+				if apiErr := m.errorBody(response, line); apiErr != nil {
+					return ret, apiErr
 				}
 
-				errChan <- resErr
-				return ret, resErr
+				return ret, err
 			}
 
-			errChan <- err
 			return ret, err
 		}
 
@@ -243,13 +312,11 @@ func (m *MinimaxiServer) ChatStream(requestPath string, data []byte, msgCh chan
 
 		retStruct := MinimaxiStreamResp{}
 		if err := json.Unmarshal(line, &retStruct); err != nil {
-			errChan <- err
-			return ret, err
+			return ret, newDecodeError(m.Supplier(), response, ret.RequestId, line, err)
 		}
 
 		if retStruct.BaseResp.StatusCode != 0 {
-			errChan <- errors.New(retStruct.BaseResp.StatusMsg)
-			return ret, errors.New(retStruct.BaseResp.StatusMsg)
+			return ret, newAPIError(m.Supplier(), response, ret.RequestId, errorCode(retStruct.BaseResp.StatusCode), retStruct.BaseResp.StatusMsg, minimaxiRetryable)
 		}
 
 		if len(retStruct.Choices) == 0 {
@@ -257,20 +324,31 @@ func (m *MinimaxiServer) ChatStream(requestPath string, data []byte, msgCh chan
 		}
 		if len(retStruct.Choices[0].Delta.Content) > 0 {
 			ret.ResponseText += retStruct.Choices[0].Delta.Content
-			msgCh <- retStruct.Choices[0].Delta.Content
+			if err := handler(retStruct.Choices[0].Delta.Content); err != nil {
+				return ret, err
+			}
 		}
 
+		toolCalls.add(retStruct.Choices[0].Delta.ToolCalls)
+		ret.finish(retStruct.Choices[0].FinishReason)
+
 		if len(retStruct.Choices[0].Message.Content) > 0 {
 			ret.ResponseText = retStruct.Choices[0].Message.Content
 		}
+		if len(retStruct.Choices[0].Message.ToolCalls) > 0 {
+			ret.ToolCalls = retStruct.Choices[0].Message.ToolCalls
+		}
 
 		if retStruct.Usage.TotalTokens > 0 {
 			ret.RequestId = retStruct.Id
-			ret.CompletionTokens = retStruct.Usage.TotalTokens
-			close(msgCh)
+			ret.setUsage(m.usage(&retStruct.Usage))
 			break
 		}
 	}
 
+	if len(ret.ToolCalls) == 0 {
+		ret.ToolCalls = toolCalls.result()
+	}
+
 	return ret, nil
 }
@@ -3,10 +3,12 @@ package pkg_ai
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/jinzhu/copier"
 	"io"
+	"net/http"
 )
 
 /**
@@ -25,6 +27,8 @@ func NewMoonshotConf(url, key string) *Config {
 
 type MoonshotServer struct {
 	Conf MoonshotConf `json:"conf"`
+
+	clientScope
 }
 
 func newMoonshotServer(url, key string) *MoonshotServer {
@@ -56,12 +60,15 @@ type MoonshotRequestBody struct {
 	ResponseFormat   struct {
 		Type string `json:"type"`
 	} `json:"response_format,omitempty"`
-	Stop   []string `json:"stop,omitempty"`
-	Stream bool     `json:"stream"`
+	Stop       []string       `json:"stop,omitempty"`
+	Stream     bool           `json:"stream"`
+	Tools      []FunctionTool `json:"tools,omitempty"`
+	ToolChoice interface{}    `json:"tool_choice,omitempty"`
 }
 
-func (m *MoonshotServer) build(data RequestData, isStream bool) ([]byte, error) {
-	if data.UserQuery == "" || data.Model == "" {
+func (m *MoonshotServer) Build(data RequestData, isStream bool) ([]byte, error) {
+	messages := data.messages()
+	if len(messages) == 0 || data.Model == "" {
 		return []byte{}, errors.New("问题、模型为必传字段")
 	}
 
@@ -79,16 +86,9 @@ func (m *MoonshotServer) build(data RequestData, isStream bool) ([]byte, error)
 		}(struct{ Type string }{Type: "json_object"})
 	}
 
-	if data.SystemQuery != "" {
-		request.Messages = append(request.Messages, Message{Role: MessageSystem, Content: data.SystemQuery})
-	}
-	if data.History != nil && len(data.History) > 0 {
-		for _, detail := range data.History {
-			request.Messages = append(request.Messages, Message{Role: MessageUSer, Content: detail[0]})
-			request.Messages = append(request.Messages, Message{Role: MessageAssistant, Content: detail[1]})
-		}
-	}
-	request.Messages = append(request.Messages, Message{Role: MessageUSer, Content: data.UserQuery})
+	request.Messages = messages
+	request.Tools = data.functionTools()
+	request.ToolChoice = data.toolChoice()
 
 	return json.Marshal(request)
 }
@@ -101,28 +101,25 @@ type MoonshotChatResponse struct {
 	Choices []struct {
 		Index   int `json:"index"`
 		Message struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
+			Role      string     `json:"role"`
+			Content   string     `json:"content"`
+			ToolCalls []ToolCall `json:"tool_calls"`
 		} `json:"message"`
 		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int64 `json:"prompt_tokens"`
-		CompletionTokens int64 `json:"completion_tokens"`
-		TotalTokens      int64 `json:"total_tokens"`
-	} `json:"usage"`
+	Usage OpenAIUsage `json:"usage"`
 	Error struct {
 		Message string `json:"message"`
 		Type    string `json:"type"`
 	} `json:"error"`
 }
 
-func (m *MoonshotServer) Chat(requestPath string, data []byte) (*Response, error) {
+func (m *MoonshotServer) Chat(ctx context.Context, requestPath string, data []byte) (*Response, error) {
 	headers := map[string]string{"Authorization": "Bearer " + m.Conf.Key}
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
-	ret.RequestHeader, _ = json.Marshal(headers)
+	ret.RequestHeader = RedactHeaders(headers)
 
-	response, err := postBase(requestPath, string(data), headers)
+	response, err := m.post(ctx, requestPath, string(data), headers)
 	if err != nil {
 		return ret, err
 	}
@@ -136,24 +133,29 @@ func (m *MoonshotServer) Chat(requestPath string, data []byte) (*Response, error
 		return ret, err
 	}
 
+	if err := statusError(m.Supplier(), response, retBytes, m.errorBody); err != nil {
+		return ret, err
+	}
+
 	retStruct := MoonshotChatResponse{}
 	if err := json.Unmarshal(retBytes, &retStruct); err != nil {
-		return ret, err
+		return ret, newDecodeError(m.Supplier(), response, "", retBytes, err)
 	}
 
 	ret.RequestId = retStruct.Id
-	ret.PromptTokens = retStruct.Usage.PromptTokens
-	ret.CompletionTokens = retStruct.Usage.CompletionTokens
+	ret.setUsage(retStruct.Usage.usage())
 
 	if len(retStruct.Error.Message) > 0 {
-		return ret, errors.New(retStruct.Error.Message)
+		return ret, newAPIError(m.Supplier(), response, ret.RequestId, retStruct.Error.Type, retStruct.Error.Message, openAIRetryable)
 	}
 
 	if len(retStruct.Choices) == 0 {
-		return ret, errors.New("无有效响应数据")
+		return ret, newEmptyError(m.Supplier(), response, ret.RequestId)
 	}
 
 	ret.ResponseText = retStruct.Choices[0].Message.Content
+	ret.finish(retStruct.Choices[0].FinishReason)
+	ret.ToolCalls = retStruct.Choices[0].Message.ToolCalls
 
 	return ret, nil
 }
@@ -162,9 +164,20 @@ type MoonshotErrorInfo struct {
 	Error struct {
 		Code    int64  `json:"code"`
 		Message string `json:"message"`
+		Type    string `json:"type"`
 	} `json:"error"`
 }
 
+// errorBody 解析错误响应, 不是错误结构时返回 nil
+func (m *MoonshotServer) errorBody(response *http.Response, body []byte) *APIError {
+	errStruct := &MoonshotErrorInfo{}
+	if err := json.Unmarshal(body, errStruct); err != nil || len(errStruct.Error.Message) == 0 {
+		return nil
+	}
+
+	return newAPIError(m.Supplier(), response, "", errorCode(errStruct.Error.Type, errStruct.Error.Code), errStruct.Error.Message, openAIRetryable)
+}
+
 type MoonshotStreamResp struct {
 	Id      string `json:"id"`
 	Object  string `json:"object"`
@@ -173,33 +186,34 @@ type MoonshotStreamResp struct {
 	Choices []struct {
 		Index int `json:"index"`
 		Delta struct {
-			Content string `json:"content"`
+			Content   string          `json:"content"`
+			ToolCalls []ToolCallDelta `json:"tool_calls"`
 		} `json:"delta"`
-		FinishReason string `json:"finish_reason"`
-		Usage        struct {
-			PromptTokens     int64 `json:"prompt_tokens"`
-			CompletionTokens int64 `json:"completion_tokens"`
-			TotalTokens      int64 `json:"total_tokens"`
-		} `json:"usage"`
+		FinishReason string      `json:"finish_reason"`
+		Usage        OpenAIUsage `json:"usage"`
 	} `json:"choices"`
 	SystemFingerprint string `json:"system_fingerprint"`
 }
 
-func (m *MoonshotServer) ChatStream(requestPath string, data []byte, msgCh chan string, errChan chan error) (*Response, error) {
+func (m *MoonshotServer) ChatStream(ctx context.Context, requestPath string, data []byte, handler StreamHandler) (*Response, error) {
 	headers := map[string]string{"Authorization": "Bearer " + m.Conf.Key}
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
-	ret.RequestHeader, _ = json.Marshal(headers)
+	ret.RequestHeader = RedactHeaders(headers)
 
-	response, err := postBase(requestPath, string(data), headers)
+	response, err := m.post(ctx, requestPath, string(data), headers)
 	if err != nil {
-		errChan <- err
 		return ret, err
 	}
 	defer func() {
 		_ = response.Body.Close()
 	}()
 
+	if err := readError(m.Supplier(), response, m.errorBody); err != nil {
+		return ret, err
+	}
+
 	reader := bufio.NewReader(response.Body)
+	toolCalls := &toolCallBuilder{}
 
 	for {
 		line, err := reader.ReadBytes('\n')
@@ -207,18 +221,18 @@ func (m *MoonshotServer) ChatStream(requestPath string, data []byte, msgCh chan
 		line = bytes.TrimSuffix(line, []byte("\n"))
 
 		if err != nil {
+			if ctx.Err() != nil {
+				return ret, ctx.Err()
+			}
+
 			if errors.Is(err, io.EOF) {
-				resErr := err
-				errStruct := &MoonshotErrorInfo{}
-				if err := json.Unmarshal(line, errStruct); err == nil {
-					resErr = errors.New(errStruct.Error.Message)
+				if apiErr := m.errorBody(response, line); apiErr != nil {
+					return ret, apiErr
 				}
 
-				errChan <- resErr
-				return ret, resErr
+				return ret, err
 			}
 
-			errChan <- err
 			return ret, err
 		}
 
@@ -233,29 +247,31 @@ func (m *MoonshotServer) ChatStream(requestPath string, data []byte, msgCh chan
 		line = bytes.TrimPrefix(line, headerData)
 
 		if string(line) == "[DONE]" {
-			close(msgCh)
 			break
 		}
 
 		retStruct := MoonshotStreamResp{}
 		if err := json.Unmarshal(line, &retStruct); err != nil {
-			errChan <- err
-			return ret, err
+			return ret, newDecodeError(m.Supplier(), response, ret.RequestId, line, err)
 		}
 		if len(retStruct.Choices) == 0 {
 			continue
 		}
 		ret.ResponseText += retStruct.Choices[0].Delta.Content
-		msgCh <- retStruct.Choices[0].Delta.Content
+		toolCalls.add(retStruct.Choices[0].Delta.ToolCalls)
+		if err := handler(retStruct.Choices[0].Delta.Content); err != nil {
+			return ret, err
+		}
 
-		if retStruct.Choices[0].FinishReason == "stop" {
+		if len(retStruct.Choices[0].FinishReason) > 0 {
+			ret.finish(retStruct.Choices[0].FinishReason)
 			ret.RequestId = retStruct.Id
-			ret.PromptTokens = retStruct.Choices[0].Usage.PromptTokens
-			ret.CompletionTokens = retStruct.Choices[0].Usage.CompletionTokens
-			close(msgCh)
+			ret.setUsage(retStruct.Choices[0].Usage.usage())
 			break
 		}
 	}
 
+	ret.ToolCalls = toolCalls.result()
+
 	return ret, nil
 }
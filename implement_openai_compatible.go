@@ -0,0 +1,327 @@
+package pkg_ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jinzhu/copier"
+	"io"
+	"net/http"
+)
+
+/**
+ * 【OpenAI 兼容接口】通用实现, 适用于 SiliconFlow、零一万物、阶跃星辰、vLLM、Ollama(/v1) 等
+ * Doc : https://platform.openai.com/docs/api-reference/chat/create
+ */
+
+type OpenAICompatibleConf struct {
+	Name               string            `json:"name"`                  // 服务名称, 同时作为 Supplier 返回
+	Url                string            `json:"url"`                   // 完整请求地址, 如 http://127.0.0.1:11434/v1/chat/completions
+	Key                string            `json:"key"`                   // 为空时不发送鉴权头
+	AuthHeader         string            `json:"auth_header"`           // 鉴权头名称, 为空时使用 Authorization: Bearer {key}
+	AuthScheme         string            `json:"auth_scheme"`           // 鉴权值前缀, 仅在指定 AuthHeader 时生效, 如 Bearer
+	Headers            map[string]string `json:"headers"`               // 附加请求头
+	StreamUsage        bool              `json:"stream_usage"`          // 流式请求携带 stream_options.include_usage
+	StopOnFinishReason bool              `json:"stop_on_finish_reason"` // 收到 finish_reason 即结束读取, 用于不发送 [DONE] 的服务
+}
+
+type OpenAICompatibleServer struct {
+	Conf OpenAICompatibleConf `json:"conf"`
+
+	clientScope
+}
+
+func newOpenAICompatibleServer(conf OpenAICompatibleConf) *OpenAICompatibleServer {
+	return &OpenAICompatibleServer{Conf: conf}
+}
+
+func (o *OpenAICompatibleServer) Supplier() string {
+	return o.Conf.Name
+}
+
+func (o *OpenAICompatibleServer) RequestPath() string {
+	return o.Conf.Url
+}
+
+func (o *OpenAICompatibleServer) headers() map[string]string {
+	headers := map[string]string{"Content-Type": "application/json"}
+	for key, val := range o.Conf.Headers {
+		headers[key] = val
+	}
+
+	if len(o.Conf.Key) == 0 {
+		return headers
+	}
+
+	if len(o.Conf.AuthHeader) == 0 {
+		headers["Authorization"] = "Bearer " + o.Conf.Key
+		return headers
+	}
+
+	if len(o.Conf.AuthScheme) > 0 {
+		headers[o.Conf.AuthHeader] = o.Conf.AuthScheme + " " + o.Conf.Key
+	} else {
+		headers[o.Conf.AuthHeader] = o.Conf.Key
+	}
+
+	return headers
+}
+
+type OpenAICompatibleRequestBody struct {
+	Messages         []Message `json:"messages"`
+	Model            string    `json:"model"`
+	MaxTokens        int64     `json:"max_tokens,omitempty"`
+	Temperature      float64   `json:"temperature,omitempty"`
+	TopP             float64   `json:"top_p,omitempty"`
+	N                int64     `json:"n,omitempty"`
+	PresencePenalty  float64   `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float64   `json:"frequency_penalty,omitempty"`
+	ResponseFormat   *struct {
+		Type string `json:"type"`
+	} `json:"response_format,omitempty"`
+	Stop          []string       `json:"stop,omitempty"`
+	Stream        bool           `json:"stream"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+	Tools         []FunctionTool `json:"tools,omitempty"`
+	ToolChoice    interface{}    `json:"tool_choice,omitempty"`
+}
+
+func (o *OpenAICompatibleServer) Build(data RequestData, isStream bool) ([]byte, error) {
+	messages := data.messages()
+	if len(messages) == 0 || data.Model == "" {
+		return []byte{}, errors.New("问题、模型为必传字段")
+	}
+
+	request := &OpenAICompatibleRequestBody{Stream: isStream}
+
+	if err := copier.Copy(request, &data); err != nil {
+		return nil, err
+	}
+
+	request.Messages = messages
+	request.Tools = data.functionTools()
+	request.ToolChoice = data.toolChoice()
+
+	request.ResponseFormat = nil
+	if data.ResponseFormat == "json" || data.ResponseFormat == "json_object" {
+		request.ResponseFormat = &struct {
+			Type string `json:"type"`
+		}{Type: "json_object"}
+	}
+
+	if isStream && o.Conf.StreamUsage {
+		request.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+
+	return json.Marshal(request)
+}
+
+type OpenAICompatibleChatResponse struct {
+	Id      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index   int64 `json:"index"`
+		Message struct {
+			Role      string     `json:"role"`
+			Content   string     `json:"content"`
+			ToolCalls []ToolCall `json:"tool_calls"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage OpenAIUsage `json:"usage"`
+	Error struct {
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Code    interface{} `json:"code"`
+	} `json:"error"`
+}
+
+func (o *OpenAICompatibleServer) Chat(ctx context.Context, requestPath string, data []byte) (*Response, error) {
+	headers := o.headers()
+	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
+	ret.RequestHeader = RedactHeaders(headers)
+
+	response, err := o.post(ctx, requestPath, string(data), headers)
+	if err != nil {
+		return ret, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	retBytes, err := io.ReadAll(response.Body)
+	ret.ResponseData = append(ret.ResponseData, retBytes)
+	if err != nil {
+		return ret, err
+	}
+
+	if err := statusError(o.Supplier(), response, retBytes, o.errorBody); err != nil {
+		return ret, err
+	}
+
+	retStruct := OpenAICompatibleChatResponse{}
+	if err := json.Unmarshal(retBytes, &retStruct); err != nil {
+		return ret, newDecodeError(o.Supplier(), response, "", retBytes, err)
+	}
+
+	ret.RequestId = retStruct.Id
+	ret.setUsage(retStruct.Usage.usage())
+
+	if len(retStruct.Error.Message) > 0 {
+		return ret, newAPIError(o.Supplier(), response, ret.RequestId, errorCode(retStruct.Error.Code, retStruct.Error.Type), retStruct.Error.Message, openAIRetryable)
+	}
+
+	if len(retStruct.Choices) == 0 {
+		return ret, newEmptyError(o.Supplier(), response, ret.RequestId)
+	}
+
+	ret.ResponseText = retStruct.Choices[0].Message.Content
+	ret.finish(retStruct.Choices[0].FinishReason)
+	ret.ToolCalls = retStruct.Choices[0].Message.ToolCalls
+
+	return ret, nil
+}
+
+type OpenAICompatibleErrorInfo struct {
+	Error struct {
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Code    interface{} `json:"code"`
+	} `json:"error"`
+}
+
+// errorBody 解析错误响应, 不是错误结构时返回 nil
+func (o *OpenAICompatibleServer) errorBody(response *http.Response, body []byte) *APIError {
+	errStruct := &OpenAICompatibleErrorInfo{}
+	if err := json.Unmarshal(body, errStruct); err != nil || len(errStruct.Error.Message) == 0 {
+		return nil
+	}
+
+	return newAPIError(o.Supplier(), response, "", errorCode(errStruct.Error.Code, errStruct.Error.Type), errStruct.Error.Message, openAIRetryable)
+}
+
+type OpenAICompatibleStreamResp struct {
+	Id      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index int64 `json:"index"`
+		Delta struct {
+			Role      string          `json:"role"`
+			Content   string          `json:"content"`
+			ToolCalls []ToolCallDelta `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *OpenAIUsage `json:"usage"`
+	Error struct {
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Code    interface{} `json:"code"`
+	} `json:"error"`
+}
+
+func (o *OpenAICompatibleServer) ChatStream(ctx context.Context, requestPath string, data []byte, handler StreamHandler) (*Response, error) {
+	headers := o.headers()
+	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
+	ret.RequestHeader = RedactHeaders(headers)
+
+	response, err := o.post(ctx, requestPath, string(data), headers)
+	if err != nil {
+		return ret, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if err := readError(o.Supplier(), response, o.errorBody); err != nil {
+		return ret, err
+	}
+
+	reader := bufio.NewReader(response.Body)
+	toolCalls := &toolCallBuilder{}
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		ret.ResponseData = append(ret.ResponseData, line)
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		line = bytes.TrimSuffix(line, []byte("\r"))
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return ret, ctx.Err()
+			}
+
+			if errors.Is(err, io.EOF) {
+				// 部分服务不发送 [DONE], 已收到 finish_reason 时视为正常结束
+				if len(ret.FinishReason) > 0 {
+					break
+				}
+
+				if apiErr := o.errorBody(response, line); apiErr != nil {
+					return ret, apiErr
+				}
+
+				return ret, err
+			}
+
+			return ret, err
+		}
+
+		if string(line) == "" {
+			continue
+		}
+
+		headerData := []byte("data:")
+		if !bytes.HasPrefix(line, headerData) {
+			continue
+		}
+		line = bytes.TrimSpace(bytes.TrimPrefix(line, headerData))
+
+		if string(line) == "[DONE]" {
+			break
+		}
+
+		retStruct := OpenAICompatibleStreamResp{}
+		if err := json.Unmarshal(line, &retStruct); err != nil {
+			return ret, newDecodeError(o.Supplier(), response, ret.RequestId, line, err)
+		}
+
+		if len(retStruct.Error.Message) > 0 {
+			return ret, newAPIError(o.Supplier(), response, ret.RequestId, errorCode(retStruct.Error.Code, retStruct.Error.Type), retStruct.Error.Message, openAIRetryable)
+		}
+
+		if len(retStruct.Id) > 0 {
+			ret.RequestId = retStruct.Id
+		}
+		if retStruct.Usage != nil {
+			ret.setUsage(retStruct.Usage.usage())
+		}
+
+		if len(retStruct.Choices) == 0 {
+			continue
+		}
+
+		ret.ResponseText += retStruct.Choices[0].Delta.Content
+		toolCalls.add(retStruct.Choices[0].Delta.ToolCalls)
+		if err := handler(retStruct.Choices[0].Delta.Content); err != nil {
+			return ret, err
+		}
+
+		if len(retStruct.Choices[0].FinishReason) > 0 {
+			ret.finish(retStruct.Choices[0].FinishReason)
+			if o.Conf.StopOnFinishReason {
+				break
+			}
+		}
+	}
+
+	ret.ToolCalls = toolCalls.result()
+
+	return ret, nil
+}
@@ -0,0 +1,68 @@
+package pkg_ai
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestOpenAICompatibleNameCaseInsensitive(t *testing.T) {
+	client := NewClient(&Config{OpenAICompatible: []OpenAICompatibleConf{{Name: "SiliconFlow", Url: "http://127.0.0.1", Key: "sk"}}})
+
+	server, err := client.NewServerByName("siliconflow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.Name != "SiliconFlow" {
+		t.Errorf("name = %s", server.Name)
+	}
+	if _, err := client.NewOpenAICompatibleServer("SILICONFLOW"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWithOpenAICompatibleConfigOverride(t *testing.T) {
+	client := NewClient(&Config{},
+		WithOpenAICompatibleConfig(OpenAICompatibleConf{Name: "SiliconFlow", Url: "http://127.0.0.1/old", Key: "sk-old"}),
+		WithOpenAICompatibleConfig(OpenAICompatibleConf{Name: "siliconflow", Url: "http://127.0.0.1/new", Key: "sk-new"}),
+	)
+
+	confs := client.Config().OpenAICompatible
+	if len(confs) != 1 || confs[0].Url != "http://127.0.0.1/new" {
+		t.Fatalf("OpenAICompatible = %+v", confs)
+	}
+	server, err := client.NewServerByName("SiliconFlow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path := server.client.RequestPath(); path != "http://127.0.0.1/new" {
+		t.Errorf("request path = %s", path)
+	}
+}
+
+func TestOpenAICompatibleOverridesBuiltinName(t *testing.T) {
+	upstream := newDeepSeekUpstream(t, func(r *http.Request) (int, string) {
+		return http.StatusOK, deepSeekReply
+	})
+
+	client := NewClient(&Config{}, WithOpenAICompatibleConfig(OpenAICompatibleConf{Name: "deepseek", Url: upstream.URL, Key: "sk"}))
+	server, err := client.NewServerByName("DeepSeek")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.ImplementId != ImplementOpenAICompatible {
+		t.Errorf("implement = %d, want %d", server.ImplementId, ImplementOpenAICompatible)
+	}
+
+	response, err := server.Chat(RequestData{Model: "deepseek-chat", UserQuery: "你好"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.ResponseText != "你好" {
+		t.Errorf("response = %q", response.ResponseText)
+	}
+
+	// 未配置同名 OpenAICompatible 时仍使用内置服务商
+	if _, err := NewClient(&Config{}).NewServerByName("deepseek"); err != ErrorNoConfig {
+		t.Errorf("err = %v, want ErrorNoConfig", err)
+	}
+}
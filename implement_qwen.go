@@ -3,10 +3,12 @@ package pkg_ai
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/jinzhu/copier"
 	"io"
+	"net/http"
 )
 
 /**
@@ -25,6 +27,8 @@ func NewQwenConf(url, key string) *Config {
 
 type QwenServer struct {
 	Conf QwenConf `json:"conf"`
+
+	clientScope
 }
 
 func newQwenServer(url, key string) *QwenServer {
@@ -52,10 +56,13 @@ type QwenRequestBody struct {
 	TopP          float64        `json:"top_p,omitempty"`
 	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
 	Stream        bool           `json:"stream"`
+	Tools         []FunctionTool `json:"tools,omitempty"`
+	ToolChoice    interface{}    `json:"tool_choice,omitempty"`
 }
 
-func (q *QwenServer) build(data RequestData, isStream bool) ([]byte, error) {
-	if data.UserQuery == "" || data.Model == "" {
+func (q *QwenServer) Build(data RequestData, isStream bool) ([]byte, error) {
+	messages := data.messages()
+	if len(messages) == 0 || data.Model == "" {
 		return []byte{}, errors.New("问题、模型为必传字段")
 	}
 
@@ -65,16 +72,9 @@ func (q *QwenServer) build(data RequestData, isStream bool) ([]byte, error) {
 		return nil, err
 	}
 
-	if data.SystemQuery != "" {
-		request.Messages = append(request.Messages, Message{Role: MessageSystem, Content: data.SystemQuery})
-	}
-	if data.History != nil && len(data.History) > 0 {
-		for _, detail := range data.History {
-			request.Messages = append(request.Messages, Message{Role: MessageUSer, Content: detail[0]})
-			request.Messages = append(request.Messages, Message{Role: MessageAssistant, Content: detail[1]})
-		}
-	}
-	request.Messages = append(request.Messages, Message{Role: MessageUSer, Content: data.UserQuery})
+	request.Messages = messages
+	request.Tools = data.functionTools()
+	request.ToolChoice = data.toolChoice()
 	if isStream {
 		request.StreamOptions = &StreamOptions{IncludeUsage: true}
 	}
@@ -85,19 +85,16 @@ func (q *QwenServer) build(data RequestData, isStream bool) ([]byte, error) {
 type QwenChatResponse struct {
 	Choices []struct {
 		Message struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
+			Role      string     `json:"role"`
+			Content   string     `json:"content"`
+			ToolCalls []ToolCall `json:"tool_calls"`
 		} `json:"message"`
 		FinishReason string      `json:"finish_reason"`
 		Index        int64       `json:"index"`
 		Logprobs     interface{} `json:"logprobs"`
 	} `json:"choices"`
-	Object string `json:"object"`
-	Usage  struct {
-		PromptTokens     int64 `json:"prompt_tokens"`
-		CompletionTokens int64 `json:"completion_tokens"`
-		TotalTokens      int64 `json:"total_tokens"`
-	} `json:"usage"`
+	Object            string      `json:"object"`
+	Usage             OpenAIUsage `json:"usage"`
 	Created           int64       `json:"created"`
 	SystemFingerprint interface{} `json:"system_fingerprint"`
 	Model             string      `json:"model"`
@@ -108,12 +105,12 @@ type QwenChatResponse struct {
 	} `json:"error"`
 }
 
-func (q *QwenServer) Chat(requestPath string, data []byte) (*Response, error) {
+func (q *QwenServer) Chat(ctx context.Context, requestPath string, data []byte) (*Response, error) {
 	headers := map[string]string{"Authorization": "Bearer " + q.Conf.Key, "Content-Type": "application/json"}
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
-	ret.RequestHeader, _ = json.Marshal(headers)
+	ret.RequestHeader = RedactHeaders(headers)
 
-	response, err := postBase(requestPath, string(data), headers)
+	response, err := q.post(ctx, requestPath, string(data), headers)
 	if err != nil {
 		return ret, err
 	}
@@ -127,24 +124,29 @@ func (q *QwenServer) Chat(requestPath string, data []byte) (*Response, error) {
 		return ret, err
 	}
 
+	if err := statusError(q.Supplier(), response, retBytes, q.errorBody); err != nil {
+		return ret, err
+	}
+
 	retStruct := QwenChatResponse{}
 	if err := json.Unmarshal(retBytes, &retStruct); err != nil {
-		return ret, err
+		return ret, newDecodeError(q.Supplier(), response, "", retBytes, err)
 	}
 
 	ret.RequestId = retStruct.Id
-	ret.PromptTokens = retStruct.Usage.PromptTokens
-	ret.CompletionTokens = retStruct.Usage.CompletionTokens
+	ret.setUsage(retStruct.Usage.usage())
 
 	if len(retStruct.Error.Message) > 0 {
-		return ret, errors.New(retStruct.Error.Message)
+		return ret, newAPIError(q.Supplier(), response, ret.RequestId, retStruct.Error.Type, retStruct.Error.Message, openAIRetryable)
 	}
 
 	if len(retStruct.Choices) == 0 {
-		return ret, errors.New("无有效响应数据")
+		return ret, newEmptyError(q.Supplier(), response, ret.RequestId)
 	}
 
 	ret.ResponseText = retStruct.Choices[0].Message.Content
+	ret.finish(retStruct.Choices[0].FinishReason)
+	ret.ToolCalls = retStruct.Choices[0].Message.ToolCalls
 
 	return ret, nil
 }
@@ -153,17 +155,14 @@ type QwenResponse struct {
 	Choices []struct {
 		FinishReason string `json:"finish_reason"`
 		Delta        struct {
-			Content string `json:"content"`
+			Content   string          `json:"content"`
+			ToolCalls []ToolCallDelta `json:"tool_calls"`
 		} `json:"delta"`
 		Index    int64       `json:"index"`
 		Logprobs interface{} `json:"logprobs"`
 	} `json:"choices"`
-	Object string `json:"object"`
-	Usage  struct {
-		PromptTokens     int64 `json:"prompt_tokens"`
-		CompletionTokens int64 `json:"completion_tokens"`
-		TotalTokens      int64 `json:"total_tokens"`
-	} `json:"usage"`
+	Object            string      `json:"object"`
+	Usage             OpenAIUsage `json:"usage"`
 	Created           int64       `json:"created"`
 	SystemFingerprint interface{} `json:"system_fingerprint"`
 	Model             string      `json:"model"`
@@ -181,21 +180,35 @@ type QwenErrorInfo struct {
 	} `json:"error"`
 }
 
-func (q *QwenServer) ChatStream(requestPath string, data []byte, msgCh chan string, errChan chan error) (*Response, error) {
+// errorBody 解析错误响应, 不是错误结构时返回 nil
+func (q *QwenServer) errorBody(response *http.Response, body []byte) *APIError {
+	errStruct := &QwenErrorInfo{}
+	if err := json.Unmarshal(body, errStruct); err != nil || len(errStruct.Error.Message) == 0 {
+		return nil
+	}
+
+	return newAPIError(q.Supplier(), response, "", errStruct.Error.Type, errStruct.Error.Message, openAIRetryable)
+}
+
+func (q *QwenServer) ChatStream(ctx context.Context, requestPath string, data []byte, handler StreamHandler) (*Response, error) {
 	headers := map[string]string{"Authorization": "Bearer " + q.Conf.Key, "Content-Type": "application/json"}
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
-	ret.RequestHeader, _ = json.Marshal(headers)
+	ret.RequestHeader = RedactHeaders(headers)
 
-	response, err := postBase(requestPath, string(data), headers)
+	response, err := q.post(ctx, requestPath, string(data), headers)
 	if err != nil {
-		errChan <- err
 		return ret, err
 	}
 	defer func() {
 		_ = response.Body.Close()
 	}()
 
+	if err := readError(q.Supplier(), response, q.errorBody); err != nil {
+		return ret, err
+	}
+
 	reader := bufio.NewReader(response.Body)
+	toolCalls := &toolCallBuilder{}
 
 	for {
 		line, err := reader.ReadBytes('\n')
@@ -203,19 +216,23 @@ func (q *QwenServer) ChatStream(requestPath string, data []byte, msgCh chan stri
 		line = bytes.TrimSuffix(line, []byte("\n"))
 
 		if err != nil {
+			if ctx.Err() != nil {
+				return ret, ctx.Err()
+			}
 
 			if errors.Is(err, io.EOF) {
-				resErr := err
-				errStruct := &QwenErrorInfo{}
-				if err := json.Unmarshal(line, errStruct); err == nil {
-					resErr = errors.New(errStruct.Error.Message)
+				// 已收到结束原因时上游断开视为正常结束
+				if len(ret.FinishReason) > 0 {
+					break
+				}
+
+				if apiErr := q.errorBody(response, line); apiErr != nil {
+					return ret, apiErr
 				}
 
-				errChan <- resErr
-				return ret, resErr
+				return ret, err
 			}
 
-			errChan <- err
 			return ret, err
 		}
 
@@ -230,26 +247,22 @@ func (q *QwenServer) ChatStream(requestPath string, data []byte, msgCh chan stri
 		line = bytes.TrimPrefix(line, headerData)
 
 		if string(line) == "[DONE]" {
-			close(msgCh)
 			break
 		}
 
 		retStruct := QwenResponse{}
 		if err := json.Unmarshal(line, &retStruct); err != nil {
-			errChan <- err
-			return ret, err
+			return ret, newDecodeError(q.Supplier(), response, ret.RequestId, line, err)
 		}
 
 		if len(retStruct.Error.Message) > 0 {
-			err := errors.New(retStruct.Error.Message)
-			errChan <- err
+			err := newAPIError(q.Supplier(), response, ret.RequestId, retStruct.Error.Type, retStruct.Error.Message, openAIRetryable)
 			return ret, err
 		}
 
 		if retStruct.Usage.TotalTokens > 0 {
 			ret.RequestId = retStruct.Id
-			ret.PromptTokens = retStruct.Usage.PromptTokens
-			ret.CompletionTokens = retStruct.Usage.CompletionTokens
+			ret.setUsage(retStruct.Usage.usage())
 		}
 
 		if len(retStruct.Choices) == 0 {
@@ -257,8 +270,16 @@ func (q *QwenServer) ChatStream(requestPath string, data []byte, msgCh chan stri
 		}
 
 		ret.ResponseText += retStruct.Choices[0].Delta.Content
-		msgCh <- retStruct.Choices[0].Delta.Content
+		toolCalls.add(retStruct.Choices[0].Delta.ToolCalls)
+		if err := handler(retStruct.Choices[0].Delta.Content); err != nil {
+			return ret, err
+		}
+
+		// 结束原因之后可能还有仅携带 usage 的数据块, 读取到 [DONE] 为止
+		ret.finish(retStruct.Choices[0].FinishReason)
 	}
 
+	ret.ToolCalls = toolCalls.result()
+
 	return ret, nil
 }
@@ -3,11 +3,13 @@ package pkg_ai
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/jinzhu/copier"
 	"io"
+	"net/http"
 	"time"
 )
 
@@ -28,6 +30,8 @@ func NewSensenovaConf(url, clientId, clientSecret string) *Config {
 
 type SensenovaServer struct {
 	Conf SensenovaConf `json:"conf"`
+
+	clientScope
 }
 
 func newSensenovaServer(url, clientId, clientSecret string) *SensenovaServer {
@@ -49,15 +53,18 @@ func (s *SensenovaServer) RequestPath() string {
 }
 
 type SensenovaRequestBody struct {
-	Messages    []Message `json:"messages"`
-	Model       string    `json:"model"`
-	Temperature float64   `json:"temperature,omitempty"`
-	TopP        float64   `json:"top_p,omitempty"`
-	Stream      bool      `json:"stream"`
+	Messages    []Message      `json:"messages"`
+	Model       string         `json:"model"`
+	Temperature float64        `json:"temperature,omitempty"`
+	TopP        float64        `json:"top_p,omitempty"`
+	Stream      bool           `json:"stream"`
+	Tools       []FunctionTool `json:"tools,omitempty"`
+	ToolChoice  interface{}    `json:"tool_choice,omitempty"`
 }
 
-func (s *SensenovaServer) build(data RequestData, isStream bool) ([]byte, error) {
-	if data.UserQuery == "" || data.Model == "" {
+func (s *SensenovaServer) Build(data RequestData, isStream bool) ([]byte, error) {
+	messages := data.messages()
+	if len(messages) == 0 || data.Model == "" {
 		return []byte{}, errors.New("问题、模型为必传字段")
 	}
 
@@ -67,28 +74,27 @@ func (s *SensenovaServer) build(data RequestData, isStream bool) ([]byte, error)
 		return nil, err
 	}
 
-	if data.SystemQuery != "" {
-		request.Messages = append(request.Messages, Message{Role: MessageSystem, Content: data.SystemQuery})
-	}
-	if data.History != nil && len(data.History) > 0 {
-		for _, detail := range data.History {
-			request.Messages = append(request.Messages, Message{Role: MessageUSer, Content: detail[0]})
-			request.Messages = append(request.Messages, Message{Role: MessageAssistant, Content: detail[1]})
-		}
-	}
-	request.Messages = append(request.Messages, Message{Role: MessageUSer, Content: data.UserQuery})
+	request.Messages = messages
+	request.Tools = data.functionTools()
+	request.ToolChoice = data.toolChoice()
 
 	return json.Marshal(request)
 }
 
-func (s *SensenovaServer) token(ak string, sk string) (string, error) {
+func (s *SensenovaServer) token(ctx context.Context, ak string, sk string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
 	payload := jwt.MapClaims{
 		"iss": ak,
 		"exp": time.Now().Add(1800 * time.Second).Unix(),
 		"nbf": time.Now().Add(-5 * time.Second).Unix(),
 	}
+	_, span := s.instance().telemetry.startSpan(ctx, "sensenova token")
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, payload)
 	signedToken, err := token.SignedString([]byte(sk))
+	endSpan(span, err)
 	if err != nil {
 		return "", err
 	}
@@ -97,18 +103,14 @@ func (s *SensenovaServer) token(ak string, sk string) (string, error) {
 
 type SensenovaChatResponse struct {
 	Data struct {
-		Id    string `json:"id"`
-		Usage struct {
-			PromptTokens     int64 `json:"prompt_tokens"`
-			CompletionTokens int64 `json:"completion_tokens"`
-			KnowledgeTokens  int64 `json:"knowledge_tokens"`
-			TotalTokens      int64 `json:"total_tokens"`
-		} `json:"usage"`
+		Id      string      `json:"id"`
+		Usage   OpenAIUsage `json:"usage"`
 		Choices []struct {
-			Index        int64  `json:"index"`
-			Role         string `json:"role"`
-			Message      string `json:"message"`
-			FinishReason string `json:"finish_reason"`
+			Index        int64      `json:"index"`
+			Role         string     `json:"role"`
+			Message      string     `json:"message"`
+			FinishReason string     `json:"finish_reason"`
+			ToolCalls    []ToolCall `json:"tool_calls"`
 		} `json:"choices"`
 		Plugins struct {
 		} `json:"plugins"`
@@ -119,17 +121,17 @@ type SensenovaChatResponse struct {
 	} `json:"error"`
 }
 
-func (s *SensenovaServer) Chat(requestPath string, data []byte) (*Response, error) {
+func (s *SensenovaServer) Chat(ctx context.Context, requestPath string, data []byte) (*Response, error) {
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
 
-	token, err := s.token(s.Conf.ClientId, s.Conf.ClientSecret)
+	token, err := s.token(ctx, s.Conf.ClientId, s.Conf.ClientSecret)
 	if err != nil {
 		return ret, err
 	}
 
 	headers := map[string]string{"Authorization": "Bearer " + token, "Content-Type": "application/json"}
-	ret.RequestHeader, _ = json.Marshal(headers)
-	response, err := postBase(requestPath, string(data), headers)
+	ret.RequestHeader = RedactHeaders(headers)
+	response, err := s.post(ctx, requestPath, string(data), headers)
 	if err != nil {
 		return ret, err
 	}
@@ -143,42 +145,43 @@ func (s *SensenovaServer) Chat(requestPath string, data []byte) (*Response, erro
 		return ret, err
 	}
 
+	if err := statusError(s.Supplier(), response, retBytes, s.errorBody); err != nil {
+		return ret, err
+	}
+
 	retStruct := SensenovaChatResponse{}
 	if err := json.Unmarshal(retBytes, &retStruct); err != nil {
-		return ret, err
+		return ret, newDecodeError(s.Supplier(), response, "", retBytes, err)
 	}
 
 	ret.RequestId = retStruct.Data.Id
-	ret.PromptTokens = retStruct.Data.Usage.PromptTokens
-	ret.CompletionTokens = retStruct.Data.Usage.CompletionTokens
+	ret.setUsage(retStruct.Data.Usage.usage())
 
 	if len(retStruct.Error.Message) > 0 {
-		return ret, errors.New(retStruct.Error.Message)
+		return ret, newAPIError(s.Supplier(), response, ret.RequestId, errorCode(retStruct.Error.Code), retStruct.Error.Message, nil)
 	}
 
 	if len(retStruct.Data.Choices) == 0 {
-		return ret, errors.New("无有效响应数据")
+		return ret, newEmptyError(s.Supplier(), response, ret.RequestId)
 	}
 
 	ret.ResponseText = retStruct.Data.Choices[0].Message
+	ret.finish(retStruct.Data.Choices[0].FinishReason)
+	ret.ToolCalls = retStruct.Data.Choices[0].ToolCalls
 
 	return ret, nil
 }
 
 type SensenovaStreamResp struct {
 	Data struct {
-		Id    string `json:"id"`
-		Usage struct {
-			PromptTokens     int64 `json:"prompt_tokens"`
-			CompletionTokens int64 `json:"completion_tokens"`
-			KnowledgeTokens  int64 `json:"knowledge_tokens"`
-			TotalTokens      int64 `json:"total_tokens"`
-		} `json:"usage"`
+		Id      string      `json:"id"`
+		Usage   OpenAIUsage `json:"usage"`
 		Choices []struct {
-			Index        int64  `json:"index"`
-			Role         string `json:"role"`
-			Delta        string `json:"delta"`
-			FinishReason string `json:"finish_reason"`
+			Index        int64          `json:"index"`
+			Role         string         `json:"role"`
+			Delta        string         `json:"delta"`
+			FinishReason string         `json:"finish_reason"`
+			ToolCalls    ToolCallDeltas `json:"tool_calls"`
 		} `json:"choices"`
 		Plugins struct {
 		} `json:"plugins"`
@@ -200,26 +203,40 @@ type SensenovaErrorInfo struct {
 	} `json:"error"`
 }
 
-func (s *SensenovaServer) ChatStream(requestPath string, data []byte, msgCh chan string, errChan chan error) (*Response, error) {
+// errorBody 解析错误响应, 不是错误结构时返回 nil
+func (s *SensenovaServer) errorBody(response *http.Response, body []byte) *APIError {
+	errStruct := &SensenovaErrorInfo{}
+	if err := json.Unmarshal(body, errStruct); err != nil || len(errStruct.Error.Message) == 0 {
+		return nil
+	}
+
+	return newAPIError(s.Supplier(), response, "", errorCode(errStruct.Error.Code), errStruct.Error.Message, nil)
+}
+
+func (s *SensenovaServer) ChatStream(ctx context.Context, requestPath string, data []byte, handler StreamHandler) (*Response, error) {
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
 
-	token, err := s.token(s.Conf.ClientId, s.Conf.ClientSecret)
+	token, err := s.token(ctx, s.Conf.ClientId, s.Conf.ClientSecret)
 	if err != nil {
 		return ret, err
 	}
 
 	headers := map[string]string{"Authorization": "Bearer " + token, "Content-Type": "application/json"}
-	ret.RequestHeader, _ = json.Marshal(headers)
-	response, err := postBase(requestPath, string(data), headers)
+	ret.RequestHeader = RedactHeaders(headers)
+	response, err := s.post(ctx, requestPath, string(data), headers)
 	if err != nil {
-		errChan <- err
 		return ret, err
 	}
 	defer func() {
 		_ = response.Body.Close()
 	}()
 
+	if err := readError(s.Supplier(), response, s.errorBody); err != nil {
+		return ret, err
+	}
+
 	reader := bufio.NewReader(response.Body)
+	toolCalls := &toolCallBuilder{}
 
 	for {
 		line, err := reader.ReadBytes('\n')
@@ -227,19 +244,18 @@ func (s *SensenovaServer) ChatStream(requestPath string, data []byte, msgCh chan
 		line = bytes.TrimSuffix(line, []byte("\n"))
 
 		if err != nil {
+			if ctx.Err() != nil {
+				return ret, ctx.Err()
+			}
 
 			if errors.Is(err, io.EOF) {
-				resErr := err
-				errStruct := &SensenovaErrorInfo{}
-				if err := json.Unmarshal(line, errStruct); err == nil {
-					resErr = errors.New(errStruct.Error.Message)
+				if apiErr := s.errorBody(response, line); apiErr != nil {
+					return ret, apiErr
 				}
 
-				errChan <- resErr
-				return ret, resErr
+				return ret, err
 			}
 
-			errChan <- err
 			return ret, err
 		}
 
@@ -254,24 +270,20 @@ func (s *SensenovaServer) ChatStream(requestPath string, data []byte, msgCh chan
 		line = bytes.TrimPrefix(line, headerData)
 
 		if string(line) == "[DONE]" {
-			close(msgCh)
 			break
 		}
 
 		retStruct := SensenovaStreamResp{}
 		if err := json.Unmarshal(line, &retStruct); err != nil {
-			errChan <- err
-			return ret, err
+			return ret, newDecodeError(s.Supplier(), response, ret.RequestId, line, err)
 		}
 
 		if len(retStruct.Error.Message) > 0 {
-			err = errors.New(retStruct.Error.Message)
-			errChan <- err
+			err = newAPIError(s.Supplier(), response, ret.RequestId, errorCode(retStruct.Error.Code), retStruct.Error.Message, nil)
 			return ret, err
 		}
 		if retStruct.Status.Code != 0 {
-			err = errors.New(retStruct.Status.Message)
-			errChan <- err
+			err = newAPIError(s.Supplier(), response, ret.RequestId, errorCode(retStruct.Status.Code), retStruct.Status.Message, nil)
 			return ret, err
 		}
 
@@ -280,16 +292,20 @@ func (s *SensenovaServer) ChatStream(requestPath string, data []byte, msgCh chan
 		}
 
 		ret.ResponseText += retStruct.Data.Choices[0].Delta
-		msgCh <- retStruct.Data.Choices[0].Delta
+		toolCalls.add(retStruct.Data.Choices[0].ToolCalls)
+		if err := handler(retStruct.Data.Choices[0].Delta); err != nil {
+			return ret, err
+		}
 
-		if retStruct.Data.Choices[0].FinishReason == "stop" {
+		if len(retStruct.Data.Choices[0].FinishReason) > 0 {
+			ret.finish(retStruct.Data.Choices[0].FinishReason)
 			ret.RequestId = retStruct.Data.Id
-			ret.PromptTokens = retStruct.Data.Usage.PromptTokens
-			ret.CompletionTokens = retStruct.Data.Usage.CompletionTokens
-			close(msgCh)
+			ret.setUsage(retStruct.Data.Usage.usage())
 			break
 		}
 	}
 
+	ret.ToolCalls = toolCalls.result()
+
 	return ret, nil
 }
@@ -3,10 +3,12 @@ package pkg_ai
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/jinzhu/copier"
 	"io"
+	"net/http"
 )
 
 /**
@@ -25,6 +27,8 @@ func NewVolcConf(url, key string) *Config {
 
 type VolcServer struct {
 	Conf VolcConf `json:"conf"`
+
+	clientScope
 }
 
 func newVolcServer(url, key string) *VolcServer {
@@ -53,10 +57,13 @@ type VolcRequestBody struct {
 	Stop          []string       `json:"stop,omitempty"`
 	Stream        bool           `json:"stream"`
 	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+	Tools         []FunctionTool `json:"tools,omitempty"`
+	ToolChoice    interface{}    `json:"tool_choice,omitempty"`
 }
 
-func (m *VolcServer) build(data RequestData, isStream bool) ([]byte, error) {
-	if data.UserQuery == "" || data.Model == "" {
+func (m *VolcServer) Build(data RequestData, isStream bool) ([]byte, error) {
+	messages := data.messages()
+	if len(messages) == 0 || data.Model == "" {
 		return []byte{}, errors.New("问题、模型为必传字段")
 	}
 
@@ -66,16 +73,9 @@ func (m *VolcServer) build(data RequestData, isStream bool) ([]byte, error) {
 		return nil, err
 	}
 
-	if data.SystemQuery != "" {
-		request.Messages = append(request.Messages, Message{Role: MessageSystem, Content: data.SystemQuery})
-	}
-	if data.History != nil && len(data.History) > 0 {
-		for _, detail := range data.History {
-			request.Messages = append(request.Messages, Message{Role: MessageUSer, Content: detail[0]})
-			request.Messages = append(request.Messages, Message{Role: MessageAssistant, Content: detail[1]})
-		}
-	}
-	request.Messages = append(request.Messages, Message{Role: MessageUSer, Content: data.UserQuery})
+	request.Messages = messages
+	request.Tools = data.functionTools()
+	request.ToolChoice = data.toolChoice()
 	if isStream {
 		request.StreamOptions = &StreamOptions{IncludeUsage: true}
 	}
@@ -88,20 +88,17 @@ type VolcChatResponse struct {
 		FinishReason string `json:"finish_reason"`
 		Index        int64  `json:"index"`
 		Message      struct {
-			Content string `json:"content"`
-			Role    string `json:"role"`
+			Content   string     `json:"content"`
+			Role      string     `json:"role"`
+			ToolCalls []ToolCall `json:"tool_calls"`
 		} `json:"message"`
 	} `json:"choices"`
-	Created int    `json:"created"`
-	Id      string `json:"id"`
-	Model   string `json:"model"`
-	Object  string `json:"object"`
-	Usage   struct {
-		CompletionTokens int64 `json:"completion_tokens"`
-		PromptTokens     int64 `json:"prompt_tokens"`
-		TotalTokens      int64 `json:"total_tokens"`
-	} `json:"usage"`
-	Error struct {
+	Created int         `json:"created"`
+	Id      string      `json:"id"`
+	Model   string      `json:"model"`
+	Object  string      `json:"object"`
+	Usage   OpenAIUsage `json:"usage"`
+	Error   struct {
 		Code    string `json:"code"`
 		Message string `json:"message"`
 		Param   string `json:"param"`
@@ -109,12 +106,12 @@ type VolcChatResponse struct {
 	} `json:"error"`
 }
 
-func (m *VolcServer) Chat(requestPath string, data []byte) (*Response, error) {
+func (m *VolcServer) Chat(ctx context.Context, requestPath string, data []byte) (*Response, error) {
 	headers := map[string]string{"Authorization": "Bearer " + m.Conf.Key}
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
-	ret.RequestHeader, _ = json.Marshal(headers)
+	ret.RequestHeader = RedactHeaders(headers)
 
-	response, err := postBase(requestPath, string(data), headers)
+	response, err := m.post(ctx, requestPath, string(data), headers)
 	if err != nil {
 		return ret, err
 	}
@@ -128,24 +125,29 @@ func (m *VolcServer) Chat(requestPath string, data []byte) (*Response, error) {
 		return ret, err
 	}
 
+	if err := statusError(m.Supplier(), response, retBytes, m.errorBody); err != nil {
+		return ret, err
+	}
+
 	retStruct := VolcChatResponse{}
 	if err := json.Unmarshal(retBytes, &retStruct); err != nil {
-		return ret, err
+		return ret, newDecodeError(m.Supplier(), response, "", retBytes, err)
 	}
 
 	ret.RequestId = retStruct.Id
-	ret.PromptTokens = retStruct.Usage.PromptTokens
-	ret.CompletionTokens = retStruct.Usage.CompletionTokens
+	ret.setUsage(retStruct.Usage.usage())
 
 	if len(retStruct.Error.Code) > 0 {
-		return ret, errors.New(retStruct.Error.Message)
+		return ret, newAPIError(m.Supplier(), response, ret.RequestId, errorCode(retStruct.Error.Code, retStruct.Error.Type), retStruct.Error.Message, openAIRetryable)
 	}
 
 	if len(retStruct.Choices) == 0 {
-		return ret, errors.New("无有效响应数据")
+		return ret, newEmptyError(m.Supplier(), response, ret.RequestId)
 	}
 
 	ret.ResponseText = retStruct.Choices[0].Message.Content
+	ret.finish(retStruct.Choices[0].FinishReason)
+	ret.ToolCalls = retStruct.Choices[0].Message.ToolCalls
 
 	return ret, nil
 }
@@ -153,22 +155,19 @@ func (m *VolcServer) Chat(requestPath string, data []byte) (*Response, error) {
 type VolcStreamResp struct {
 	Choices []struct {
 		Delta struct {
-			Content string `json:"content"`
-			Role    string `json:"role"`
+			Content   string          `json:"content"`
+			Role      string          `json:"role"`
+			ToolCalls []ToolCallDelta `json:"tool_calls"`
 		} `json:"delta"`
 		FinishReason string `json:"finish_reason"`
 		Index        int    `json:"index"`
 	} `json:"choices"`
-	Created int64  `json:"created"`
-	Id      string `json:"id"`
-	Model   string `json:"model"`
-	Object  string `json:"object"`
-	Usage   struct {
-		CompletionTokens int64 `json:"completion_tokens"`
-		PromptTokens     int64 `json:"prompt_tokens"`
-		TotalTokens      int64 `json:"total_tokens"`
-	} `json:"usage"`
-	Error struct {
+	Created int64       `json:"created"`
+	Id      string      `json:"id"`
+	Model   string      `json:"model"`
+	Object  string      `json:"object"`
+	Usage   OpenAIUsage `json:"usage"`
+	Error   struct {
 		Code    string `json:"code"`
 		Message string `json:"message"`
 		Param   string `json:"param"`
@@ -185,21 +184,35 @@ type VolcErrorInfo struct {
 	} `json:"error"`
 }
 
-func (m *VolcServer) ChatStream(requestPath string, data []byte, msgCh chan string, errChan chan error) (*Response, error) {
+// errorBody 解析错误响应, 不是错误结构时返回 nil
+func (m *VolcServer) errorBody(response *http.Response, body []byte) *APIError {
+	errStruct := &VolcErrorInfo{}
+	if err := json.Unmarshal(body, errStruct); err != nil || len(errStruct.Error.Message) == 0 {
+		return nil
+	}
+
+	return newAPIError(m.Supplier(), response, "", errorCode(errStruct.Error.Code, errStruct.Error.Type), errStruct.Error.Message, openAIRetryable)
+}
+
+func (m *VolcServer) ChatStream(ctx context.Context, requestPath string, data []byte, handler StreamHandler) (*Response, error) {
 	headers := map[string]string{"Authorization": "Bearer " + m.Conf.Key}
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
-	ret.RequestHeader, _ = json.Marshal(headers)
+	ret.RequestHeader = RedactHeaders(headers)
 
-	response, err := postBase(requestPath, string(data), headers)
+	response, err := m.post(ctx, requestPath, string(data), headers)
 	if err != nil {
-		errChan <- err
 		return ret, err
 	}
 	defer func() {
 		_ = response.Body.Close()
 	}()
 
+	if err := readError(m.Supplier(), response, m.errorBody); err != nil {
+		return ret, err
+	}
+
 	reader := bufio.NewReader(response.Body)
+	toolCalls := &toolCallBuilder{}
 
 	for {
 		line, err := reader.ReadBytes('\n')
@@ -207,19 +220,23 @@ func (m *VolcServer) ChatStream(requestPath string, data []byte, msgCh chan stri
 		line = bytes.TrimSuffix(line, []byte("\n"))
 
 		if err != nil {
+			if ctx.Err() != nil {
+				return ret, ctx.Err()
+			}
 
 			if errors.Is(err, io.EOF) {
-				resErr := err
-				errStruct := &VolcErrorInfo{}
-				if err := json.Unmarshal(line, errStruct); err == nil {
-					resErr = errors.New(errStruct.Error.Message)
+				// 结束原因之后可能直接断开而不发送 [DONE]
+				if len(ret.FinishReason) > 0 {
+					break
+				}
+
+				if apiErr := m.errorBody(response, line); apiErr != nil {
+					return ret, apiErr
 				}
 
-				errChan <- resErr
-				return ret, resErr
+				return ret, err
 			}
 
-			errChan <- err
 			return ret, err
 		}
 
@@ -234,33 +251,37 @@ func (m *VolcServer) ChatStream(requestPath string, data []byte, msgCh chan stri
 		line = bytes.TrimPrefix(line, headerData)
 
 		if string(line) == "[DONE]" {
-			close(msgCh)
 			break
 		}
 
 		retStruct := VolcStreamResp{}
 		if err := json.Unmarshal(line, &retStruct); err != nil {
-			errChan <- err
-			return ret, err
+			return ret, newDecodeError(m.Supplier(), response, ret.RequestId, line, err)
 		}
 		if len(retStruct.Error.Message) > 0 {
-			err := errors.New(retStruct.Error.Message)
-			errChan <- err
+			err := newAPIError(m.Supplier(), response, ret.RequestId, errorCode(retStruct.Error.Code, retStruct.Error.Type), retStruct.Error.Message, openAIRetryable)
 			return ret, err
 		}
 
 		if retStruct.Usage.TotalTokens > 0 {
 			ret.RequestId = retStruct.Id
-			ret.PromptTokens = retStruct.Usage.PromptTokens
-			ret.CompletionTokens = retStruct.Usage.CompletionTokens
+			ret.setUsage(retStruct.Usage.usage())
 		}
 
 		if len(retStruct.Choices) == 0 {
 			continue
 		}
 		ret.ResponseText += retStruct.Choices[0].Delta.Content
-		msgCh <- retStruct.Choices[0].Delta.Content
+		toolCalls.add(retStruct.Choices[0].Delta.ToolCalls)
+		if err := handler(retStruct.Choices[0].Delta.Content); err != nil {
+			return ret, err
+		}
+
+		// 结束原因之后可能还有仅携带 usage 的数据块, 读取到 [DONE] 为止
+		ret.finish(retStruct.Choices[0].FinishReason)
 	}
 
+	ret.ToolCalls = toolCalls.result()
+
 	return ret, nil
 }
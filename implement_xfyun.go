@@ -3,10 +3,12 @@ package pkg_ai
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/jinzhu/copier"
 	"io"
+	"net/http"
 )
 
 /**
@@ -25,6 +27,8 @@ func NewXfYunConf(url, key string) *Config {
 
 type XfYunServer struct {
 	Conf XfYunConf `json:"conf"`
+
+	clientScope
 }
 
 func newXfYunServer(url, key string) *XfYunServer {
@@ -45,15 +49,18 @@ func (x *XfYunServer) RequestPath() string {
 }
 
 type XfYunRequestBody struct {
-	Messages    []Message `json:"messages"`
-	Model       string    `json:"model"`
-	MaxTokens   int64     `json:"max_tokens,omitempty"`
-	Temperature float64   `json:"temperature,omitempty"`
-	Stream      bool      `json:"stream"`
+	Messages    []Message      `json:"messages"`
+	Model       string         `json:"model"`
+	MaxTokens   int64          `json:"max_tokens,omitempty"`
+	Temperature float64        `json:"temperature,omitempty"`
+	Stream      bool           `json:"stream"`
+	Tools       []FunctionTool `json:"tools,omitempty"`
+	ToolChoice  interface{}    `json:"tool_choice,omitempty"`
 }
 
-func (x *XfYunServer) build(data RequestData, isStream bool) ([]byte, error) {
-	if data.UserQuery == "" || data.Model == "" {
+func (x *XfYunServer) Build(data RequestData, isStream bool) ([]byte, error) {
+	messages := data.messages()
+	if len(messages) == 0 || data.Model == "" {
 		return []byte{}, errors.New("问题、模型为必传字段")
 	}
 
@@ -63,16 +70,9 @@ func (x *XfYunServer) build(data RequestData, isStream bool) ([]byte, error) {
 		return nil, err
 	}
 
-	if data.SystemQuery != "" {
-		request.Messages = append(request.Messages, Message{Role: MessageSystem, Content: data.SystemQuery})
-	}
-	if data.History != nil && len(data.History) > 0 {
-		for _, detail := range data.History {
-			request.Messages = append(request.Messages, Message{Role: MessageUSer, Content: detail[0]})
-			request.Messages = append(request.Messages, Message{Role: MessageAssistant, Content: detail[1]})
-		}
-	}
-	request.Messages = append(request.Messages, Message{Role: MessageUSer, Content: data.UserQuery})
+	request.Messages = messages
+	request.Tools = data.functionTools()
+	request.ToolChoice = data.toolChoice()
 
 	return json.Marshal(request)
 }
@@ -83,16 +83,14 @@ type XfYunChatResponse struct {
 	Sid     string `json:"sid"`
 	Choices []struct {
 		Message struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
+			Role      string         `json:"role"`
+			Content   string         `json:"content"`
+			ToolCalls ToolCallDeltas `json:"tool_calls"`
 		} `json:"message"`
-		Index int64 `json:"index"`
+		Index        int64  `json:"index"`
+		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int64 `json:"prompt_tokens"`
-		CompletionTokens int64 `json:"completion_tokens"`
-		TotalTokens      int64 `json:"total_tokens"`
-	} `json:"usage"`
+	Usage OpenAIUsage `json:"usage"`
 	Error struct {
 		Message string      `json:"message"`
 		Type    string      `json:"type"`
@@ -101,12 +99,12 @@ type XfYunChatResponse struct {
 	} `json:"error"`
 }
 
-func (x *XfYunServer) Chat(requestPath string, data []byte) (*Response, error) {
+func (x *XfYunServer) Chat(ctx context.Context, requestPath string, data []byte) (*Response, error) {
 	headers := map[string]string{"Authorization": "Bearer " + x.Conf.Key}
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
-	ret.RequestHeader, _ = json.Marshal(headers)
+	ret.RequestHeader = RedactHeaders(headers)
 
-	response, err := postBase(requestPath, string(data), headers)
+	response, err := x.post(ctx, requestPath, string(data), headers)
 	if err != nil {
 		return ret, err
 	}
@@ -120,28 +118,35 @@ func (x *XfYunServer) Chat(requestPath string, data []byte) (*Response, error) {
 		return ret, err
 	}
 
+	if err := statusError(x.Supplier(), response, retBytes, x.errorBody); err != nil {
+		return ret, err
+	}
+
 	retStruct := XfYunChatResponse{}
 	if err := json.Unmarshal(retBytes, &retStruct); err != nil {
-		return ret, err
+		return ret, newDecodeError(x.Supplier(), response, "", retBytes, err)
 	}
 
 	ret.RequestId = retStruct.Sid
-	ret.PromptTokens = retStruct.Usage.PromptTokens
-	ret.CompletionTokens = retStruct.Usage.CompletionTokens
+	ret.setUsage(retStruct.Usage.usage())
 
 	if retStruct.Message != "Success" {
 		if len(retStruct.Message) > 0 {
-			return ret, errors.New(retStruct.Message)
+			return ret, newAPIError(x.Supplier(), response, ret.RequestId, errorCode(retStruct.Code), retStruct.Message, xfYunRetryable)
 		} else {
-			return ret, errors.New(retStruct.Error.Message)
+			return ret, newAPIError(x.Supplier(), response, ret.RequestId, errorCode(retStruct.Error.Code, retStruct.Error.Type), retStruct.Error.Message, xfYunRetryable)
 		}
 	}
 
 	if len(retStruct.Choices) == 0 {
-		return ret, errors.New("无有效响应数据")
+		return ret, newEmptyError(x.Supplier(), response, ret.RequestId)
 	}
 
 	ret.ResponseText = retStruct.Choices[0].Message.Content
+	ret.finish(retStruct.Choices[0].FinishReason)
+	toolCalls := &toolCallBuilder{}
+	toolCalls.add(retStruct.Choices[0].Message.ToolCalls)
+	ret.ToolCalls = toolCalls.result()
 
 	return ret, nil
 }
@@ -154,16 +159,14 @@ type XfYunStreamResp struct {
 	Created int    `json:"created"`
 	Choices []struct {
 		Delta struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
+			Role      string         `json:"role"`
+			Content   string         `json:"content"`
+			ToolCalls ToolCallDeltas `json:"tool_calls"`
 		} `json:"delta"`
-		Index int64 `json:"index"`
+		Index        int64  `json:"index"`
+		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int64 `json:"prompt_tokens"`
-		CompletionTokens int64 `json:"completion_tokens"`
-		TotalTokens      int64 `json:"total_tokens"`
-	} `json:"usage"`
+	Usage OpenAIUsage `json:"usage"`
 	Error struct {
 		Message string      `json:"message"`
 		Type    string      `json:"type"`
@@ -173,6 +176,7 @@ type XfYunStreamResp struct {
 }
 
 type XfYunErrorInfo struct {
+	Code    int64  `json:"code"`
 	Message string `json:"message"`
 	Error   struct {
 		Message string      `json:"message"`
@@ -182,21 +186,52 @@ type XfYunErrorInfo struct {
 	} `json:"error"`
 }
 
-func (x *XfYunServer) ChatStream(requestPath string, data []byte, msgCh chan string, errChan chan error) (*Response, error) {
+// errorBody 解析错误响应, 不是错误结构时返回 nil
+func (x *XfYunServer) errorBody(response *http.Response, body []byte) *APIError {
+	errStruct := &XfYunErrorInfo{}
+	if err := json.Unmarshal(body, errStruct); err != nil {
+		return nil
+	}
+
+	if errStruct.Code != 0 {
+		return newAPIError(x.Supplier(), response, "", errorCode(errStruct.Code), errStruct.Message, xfYunRetryable)
+	}
+	if len(errStruct.Error.Message) > 0 {
+		return newAPIError(x.Supplier(), response, "", errorCode(errStruct.Error.Code, errStruct.Error.Type), errStruct.Error.Message, xfYunRetryable)
+	}
+
+	return nil
+}
+
+// xfYunRetryable 11202 QPS 超限、11203 并发超限可重试, 其余按 OpenAI 风格错误判断
+func xfYunRetryable(status int, code string) bool {
+	switch code {
+	case "11202", "11203":
+		return true
+	}
+
+	return openAIRetryable(status, code)
+}
+
+func (x *XfYunServer) ChatStream(ctx context.Context, requestPath string, data []byte, handler StreamHandler) (*Response, error) {
 	headers := map[string]string{"Authorization": "Bearer " + x.Conf.Key}
 	ret := &Response{RequestHeader: make([]byte, 0), RequestBody: data, ResponseData: make([][]byte, 0)}
-	ret.RequestHeader, _ = json.Marshal(headers)
+	ret.RequestHeader = RedactHeaders(headers)
 
-	response, err := postBase(requestPath, string(data), headers)
+	response, err := x.post(ctx, requestPath, string(data), headers)
 	if err != nil {
-		errChan <- err
 		return ret, err
 	}
 	defer func() {
 		_ = response.Body.Close()
 	}()
 
+	if err := readError(x.Supplier(), response, x.errorBody); err != nil {
+		return ret, err
+	}
+
 	reader := bufio.NewReader(response.Body)
+	toolCalls := &toolCallBuilder{}
 
 	for {
 		line, err := reader.ReadBytes('\n')
@@ -204,23 +239,23 @@ func (x *XfYunServer) ChatStream(requestPath string, data []byte, msgCh chan str
 		line = bytes.TrimSuffix(line, []byte("\n"))
 
 		if err != nil {
+			if ctx.Err() != nil {
+				return ret, ctx.Err()
+			}
 
 			if errors.Is(err, io.EOF) {
-				resErr := err
-				errStruct := &XfYunErrorInfo{}
-				if err := json.Unmarshal(line, errStruct); err == nil {
-					if len(errStruct.Message) > 0 {
-						resErr = errors.New(errStruct.Message)
-					} else {
-						resErr = errors.New(errStruct.Error.Message)
-					}
+				// 已收到结束原因, 上游直接断开不视为错误
+				if len(ret.FinishReason) > 0 {
+					break
 				}
 
-				errChan <- resErr
-				return ret, resErr
+				if apiErr := x.errorBody(response, line); apiErr != nil {
+					return ret, apiErr
+				}
+
+				return ret, err
 			}
 
-			errChan <- err
 			return ret, err
 		}
 
@@ -235,23 +270,20 @@ func (x *XfYunServer) ChatStream(requestPath string, data []byte, msgCh chan str
 		line = bytes.TrimPrefix(line, headerData)
 
 		if string(line) == "[DONE]" {
-			close(msgCh)
 			break
 		}
 
 		retStruct := XfYunStreamResp{}
 		if err := json.Unmarshal(line, &retStruct); err != nil {
-			errChan <- err
-			return ret, err
+			return ret, newDecodeError(x.Supplier(), response, ret.RequestId, line, err)
 		}
 
 		if retStruct.Message != "Success" {
 			if len(retStruct.Message) > 0 {
-				err = errors.New(retStruct.Message)
+				err = newAPIError(x.Supplier(), response, ret.RequestId, errorCode(retStruct.Code), retStruct.Message, xfYunRetryable)
 			} else {
-				err = errors.New(retStruct.Error.Message)
+				err = newAPIError(x.Supplier(), response, ret.RequestId, errorCode(retStruct.Error.Code, retStruct.Error.Type), retStruct.Error.Message, xfYunRetryable)
 			}
-			errChan <- err
 			return ret, err
 		}
 
@@ -259,16 +291,21 @@ func (x *XfYunServer) ChatStream(requestPath string, data []byte, msgCh chan str
 			continue
 		}
 		ret.ResponseText += retStruct.Choices[0].Delta.Content
-		msgCh <- retStruct.Choices[0].Delta.Content
+		toolCalls.add(retStruct.Choices[0].Delta.ToolCalls)
+		if err := handler(retStruct.Choices[0].Delta.Content); err != nil {
+			return ret, err
+		}
+
+		ret.finish(retStruct.Choices[0].FinishReason)
 
 		if retStruct.Usage.TotalTokens > 0 {
 			ret.RequestId = retStruct.Sid
-			ret.PromptTokens = retStruct.Usage.PromptTokens
-			ret.CompletionTokens = retStruct.Usage.CompletionTokens
-			close(msgCh)
+			ret.setUsage(retStruct.Usage.usage())
 			break
 		}
 	}
 
+	ret.ToolCalls = toolCalls.result()
+
 	return ret, nil
 }
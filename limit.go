@@ -0,0 +1,195 @@
+package pkg_ai
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+	"unicode"
+)
+
+var ErrorRateLimited = errors.New("超出客户端限流配置")
+
+// LimitConf 客户端限流配置, 各项为 0 时不限制
+type LimitConf struct {
+	RequestsPerMinute int   `json:"requests_per_minute"` // 每分钟请求数
+	TokensPerMinute   int64 `json:"tokens_per_minute"`   // 每分钟 token 数, 请求前按请求体预估输入 token, 请求后按实际消耗修正
+	MaxConcurrency    int   `json:"max_concurrency"`     // 最大并发请求数, 流式请求在读取结束前一直占用
+	FailFast          bool  `json:"fail_fast"`           // 超出限制时立即返回 ErrorRateLimited, 默认等待直到 ctx 取消或超时
+}
+
+func (l LimitConf) enabled() bool {
+	return l.RequestsPerMinute > 0 || l.TokensPerMinute > 0 || l.MaxConcurrency > 0
+}
+
+// limiter 按 LimitConf 限流, 为 nil 时不限制
+type limiter struct {
+	failFast bool
+	requests *tokenBucket
+	tokens   *tokenBucket
+	slots    chan struct{}
+}
+
+func newLimiter(conf LimitConf) *limiter {
+	if !conf.enabled() {
+		return nil
+	}
+
+	l := &limiter{failFast: conf.FailFast}
+	if conf.RequestsPerMinute > 0 {
+		l.requests = newTokenBucket(float64(conf.RequestsPerMinute))
+	}
+	if conf.TokensPerMinute > 0 {
+		l.tokens = newTokenBucket(float64(conf.TokensPerMinute))
+	}
+	if conf.MaxConcurrency > 0 {
+		l.slots = make(chan struct{}, conf.MaxConcurrency)
+	}
+
+	return l
+}
+
+// acquire 获取请求许可, 返回的 release 需在请求结束后调用, 用于释放并发数及按实际 token 消耗修正
+func (l *limiter) acquire(ctx context.Context, estimate int64) (func(response *Response), error) {
+	if l == nil {
+		return func(*Response) {}, nil
+	}
+
+	return l.take(ctx, estimate, l.failFast)
+}
+
+// try 不等待地获取请求许可, 超出限制时返回 false
+func (l *limiter) try(estimate int64) (func(response *Response), bool) {
+	if l == nil {
+		return func(*Response) {}, true
+	}
+
+	release, err := l.take(context.Background(), estimate, true)
+	return release, err == nil
+}
+
+func (l *limiter) take(ctx context.Context, estimate int64, failFast bool) (func(response *Response), error) {
+	if l.slots != nil {
+		if failFast {
+			select {
+			case l.slots <- struct{}{}:
+			default:
+				return nil, ErrorRateLimited
+			}
+		} else {
+			select {
+			case l.slots <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+	releaseSlot := func() {
+		if l.slots != nil {
+			<-l.slots
+		}
+	}
+
+	if err := l.requests.take(ctx, 1, failFast); err != nil {
+		releaseSlot()
+		return nil, err
+	}
+	if err := l.tokens.take(ctx, float64(estimate), failFast); err != nil {
+		// 未发出的请求归还已取出的请求数令牌
+		l.requests.adjust(-1)
+		releaseSlot()
+		return nil, err
+	}
+
+	return func(response *Response) {
+		releaseSlot()
+		if response != nil && response.PromptTokens+response.CompletionTokens > 0 {
+			l.tokens.adjust(float64(response.PromptTokens + response.CompletionTokens - estimate))
+		}
+	}, nil
+}
+
+// tokenBucket 令牌桶, 容量为每分钟配额, 匀速补充
+type tokenBucket struct {
+	lock     sync.Mutex
+	capacity float64
+	rate     float64 // 每秒补充数量
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(perMinute float64) *tokenBucket {
+	return &tokenBucket{capacity: perMinute, rate: perMinute / 60, tokens: perMinute, last: time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
+
+// take 取出 n 个令牌, 不足时等待补充; 超过容量的请求按容量计算, 避免永远无法满足
+func (b *tokenBucket) take(ctx context.Context, n float64, failFast bool) error {
+	if b == nil || n <= 0 {
+		return nil
+	}
+	if n > b.capacity {
+		n = b.capacity
+	}
+
+	for {
+		b.lock.Lock()
+		b.refill(time.Now())
+		if b.tokens >= n {
+			b.tokens -= n
+			b.lock.Unlock()
+			return nil
+		}
+		wait := time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+		b.lock.Unlock()
+
+		if failFast {
+			return ErrorRateLimited
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// adjust 按实际消耗修正令牌数, 实际消耗超出预估时允许透支, 由后续补充抵消
+func (b *tokenBucket) adjust(delta float64) {
+	if b == nil || delta == 0 {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(time.Now())
+	b.tokens -= delta
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+}
+
+// estimateTokens 粗略预估 token 数: 中日韩文字每字约 1 个 token, 其他字符每 4 个约 1 个 token
+func estimateTokens(payload []byte) int64 {
+	var cjk, other int64
+	for _, r := range string(payload) {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			cjk++
+		} else {
+			other++
+		}
+	}
+
+	return cjk + (other+3)/4
+}
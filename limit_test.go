@@ -0,0 +1,140 @@
+package pkg_ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimiterRequestsPerMinute(t *testing.T) {
+	upstream := newDeepSeekUpstream(t, func(r *http.Request) (int, string) {
+		return http.StatusOK, deepSeekReply
+	})
+
+	conf := &Config{}
+	client := NewClient(conf, WithDeepSeekConfig(upstream.URL, "sk"), WithLimit("DeepSeek", LimitConf{RequestsPerMinute: 1, FailFast: true}))
+	if len(conf.Limits) != 0 {
+		t.Errorf("conf.Limits modified: %v", conf.Limits)
+	}
+	server, err := client.NewServer(ImplementDeepSeek)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := server.Chat(RequestData{Model: "deepseek-chat", UserQuery: "你好"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Chat(RequestData{Model: "deepseek-chat", UserQuery: "你好"}); !errors.Is(err, ErrorRateLimited) {
+		t.Errorf("err = %v, want ErrorRateLimited", err)
+	}
+}
+
+func TestLimiterTokensRefundRequest(t *testing.T) {
+	l := newLimiter(LimitConf{RequestsPerMinute: 2, TokensPerMinute: 10, FailFast: true})
+
+	release, err := l.acquire(context.Background(), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release(nil)
+
+	// 超出 TPM 被拒绝的请求不占用 RPM
+	if _, err := l.acquire(context.Background(), 8); !errors.Is(err, ErrorRateLimited) {
+		t.Fatalf("err = %v, want ErrorRateLimited", err)
+	}
+	if _, err := l.acquire(context.Background(), 1); err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+}
+
+func TestLimiterTokensAdjust(t *testing.T) {
+	l := newLimiter(LimitConf{TokensPerMinute: 100, FailFast: true})
+
+	release, err := l.acquire(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 实际消耗超出预估, 按实际消耗扣减
+	release(&Response{PromptTokens: 60, CompletionTokens: 30})
+
+	if _, err := l.acquire(context.Background(), 20); !errors.Is(err, ErrorRateLimited) {
+		t.Errorf("err = %v, want ErrorRateLimited", err)
+	}
+	if _, err := l.acquire(context.Background(), 5); err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+}
+
+func TestLimiterConcurrency(t *testing.T) {
+	l := newLimiter(LimitConf{MaxConcurrency: 1, FailFast: true})
+
+	release, err := l.acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.acquire(context.Background(), 0); !errors.Is(err, ErrorRateLimited) {
+		t.Errorf("err = %v, want ErrorRateLimited", err)
+	}
+
+	release(nil)
+	if _, err := l.acquire(context.Background(), 0); err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+}
+
+func TestLimiterWaitCanceled(t *testing.T) {
+	l := newLimiter(LimitConf{RequestsPerMinute: 1})
+	if _, err := l.acquire(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(ctx, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	if n := estimateTokens([]byte("你好abcdefgh")); n != 4 {
+		t.Errorf("estimate = %d, want 4", n)
+	}
+}
+
+func TestLimiterSkipsThrottledCredential(t *testing.T) {
+	upstream := newDeepSeekUpstream(t, func(r *http.Request) (int, string) {
+		return http.StatusOK, deepSeekReply
+	})
+
+	client := NewClient(&Config{}, WithDeepSeekConfig(upstream.URL, "sk"), WithCredentialPool("deepseek", CredentialPool{
+		Credentials: []Credential{
+			{Alias: "a", Key: "sk-a", Limit: LimitConf{RequestsPerMinute: 1, FailFast: true}},
+			{Alias: "b", Key: "sk-b", Limit: LimitConf{RequestsPerMinute: 2, FailFast: true}},
+		},
+	}))
+	server, err := client.NewServer(ImplementDeepSeek)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a 用尽后轮到 a 的请求改用 b
+	aliases := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		response, err := server.Chat(RequestData{Model: "deepseek-chat", UserQuery: "你好"})
+		if err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+		aliases = append(aliases, response.KeyAlias)
+	}
+	if got := strings.Join(aliases, ","); got != "a,b,b" {
+		t.Errorf("aliases = %s, want a,b,b", got)
+	}
+
+	// 所有凭证均已达到限流
+	if _, err := server.Chat(RequestData{Model: "deepseek-chat", UserQuery: "你好"}); !errors.Is(err, ErrorRateLimited) {
+		t.Errorf("err = %v, want ErrorRateLimited", err)
+	}
+}
@@ -0,0 +1,63 @@
+package pkg_ai
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// LogMiddleware 记录请求日志的中间件: 开始时记录 Debug 日志, 结束时记录耗时、token 消耗、服务商及请求ID
+// 成功时为 Info 级别, 失败时为 Error 级别; 不记录请求及响应内容
+func LogMiddleware(logger *slog.Logger) Middleware {
+	return func(next ChatFunc) ChatFunc {
+		return func(ctx context.Context, call *Call) (*Response, error) {
+			model := payloadModel(call.Payload)
+			if call.Data != nil {
+				model = call.Data.Model
+			}
+			logger.DebugContext(ctx, "ai request start", slog.String("name", call.Name), slog.String("model", model), slog.Bool("stream", call.Stream))
+
+			start := time.Now()
+			response, err := next(ctx, call)
+			if response == nil {
+				response = &Response{}
+			}
+
+			attrs := []slog.Attr{
+				slog.String("name", call.Name),
+				slog.String("supplier", response.Supplier),
+				slog.String("model", model),
+				slog.Bool("stream", call.Stream),
+				slog.String("request_id", response.RequestId),
+				slog.Int64("latency_ms", time.Since(start).Milliseconds()),
+				slog.Int64("prompt_tokens", response.Usage.PromptTokens),
+				slog.Int64("completion_tokens", response.Usage.CompletionTokens),
+				slog.Int64("total_tokens", response.Usage.TotalTokens),
+			}
+			if len(response.FinishReason) > 0 {
+				attrs = append(attrs, slog.String("finish_reason", string(response.FinishReason)))
+			}
+			if len(response.KeyAlias) > 0 {
+				attrs = append(attrs, slog.String("key_alias", response.KeyAlias))
+			}
+			if response.CacheHit {
+				attrs = append(attrs, slog.Bool("cache_hit", true))
+			}
+
+			if err == nil {
+				logger.LogAttrs(ctx, slog.LevelInfo, "ai request finish", attrs...)
+				return response, err
+			}
+
+			attrs = append(attrs, slog.String("error", err.Error()))
+			var apiErr *APIError
+			if errors.As(err, &apiErr) {
+				attrs = append(attrs, slog.String("category", string(apiErr.Category)), slog.Int("status_code", apiErr.StatusCode), slog.String("code", apiErr.Code))
+			}
+			logger.LogAttrs(ctx, slog.LevelError, "ai request failed", attrs...)
+
+			return response, err
+		}
+	}
+}
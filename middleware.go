@@ -0,0 +1,88 @@
+package pkg_ai
+
+import "context"
+
+// Call 一次对话请求, 中间件可查看或修改其中的请求参数
+type Call struct {
+	Name    string        // 服务注册名称
+	Stream  bool          // 是否流式请求
+	Data    *RequestData  // 请求参数, 自定义请求体(CustomizeChat 等)时为 nil
+	Payload []byte        // 组装后的请求体, 可直接修改
+	Handler StreamHandler // 流式请求的增量回调, 可包装后替换以查看或修改推送的内容; 阻塞式请求时为 nil
+
+	build func(data RequestData, isStream bool) ([]byte, error)
+}
+
+// Build 修改 Data 后按新的参数重新组装 Payload, 自定义请求体时不做处理
+func (c *Call) Build() error {
+	if c.Data == nil || c.build == nil {
+		return nil
+	}
+
+	payload, err := c.build(*c.Data, c.Stream)
+	if err != nil {
+		return err
+	}
+	c.Payload = payload
+
+	return nil
+}
+
+// ChatFunc 发起对话请求
+type ChatFunc func(ctx context.Context, call *Call) (*Response, error)
+
+// Middleware 中间件, 包装 next 后返回, 可在请求前修改 Call, 请求后查看或修改 Response
+// 多个中间件按注册顺序由外到内执行, 同时作用于 Chat、ChatStream、Stream 及 Customize 系列方法
+//
+//	func Guard(next pkg_ai.ChatFunc) pkg_ai.ChatFunc {
+//		return func(ctx context.Context, call *pkg_ai.Call) (*pkg_ai.Response, error) {
+//			if call.Data != nil && strings.Contains(call.Data.UserQuery, "密码") {
+//				return &pkg_ai.Response{}, errors.New("包含敏感信息")
+//			}
+//			return next(ctx, call)
+//		}
+//	}
+type Middleware func(next ChatFunc) ChatFunc
+
+// Use 为当前服务追加中间件, 在 Client 配置的中间件之后(内层)执行; 需在发起请求前调用
+func (s *Server) Use(middlewares ...Middleware) {
+	s.middlewares = append(s.middlewares, middlewares...)
+}
+
+// newCall 按请求参数组装请求
+func (s *Server) newCall(data RequestData, isStream bool) (*Call, error) {
+	payload, err := s.client.Build(data, isStream)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Call{Name: s.Name, Stream: isStream, Data: &data, Payload: payload, build: s.client.Build}, nil
+}
+
+// intercept 经过中间件后调用 fun
+func (s *Server) intercept(ctx context.Context, call *Call, fun ChatFunc) (*Response, error) {
+	for index := len(s.middlewares) - 1; index >= 0; index-- {
+		fun = s.middlewares[index](fun)
+	}
+
+	response, err := fun(ctx, call)
+	if response == nil {
+		response = &Response{}
+	}
+
+	return response, err
+}
+
+// invoke 经过中间件发起阻塞式请求
+func (s *Server) invoke(ctx context.Context, call *Call) (*Response, error) {
+	return s.intercept(ctx, call, func(ctx context.Context, call *Call) (*Response, error) {
+		return s.chat(ctx, call.Payload)
+	})
+}
+
+// stream 经过中间件发起流式请求, 推送内容经 call.Handler 回调
+func (s *Server) stream(ctx context.Context, call *Call) (*Response, error) {
+	return s.intercept(ctx, call, func(ctx context.Context, call *Call) (*Response, error) {
+		return s.chatStream(ctx, call.Payload, call.Handler)
+	})
+}
@@ -1,5 +1,15 @@
 package pkg_ai
 
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
 type Options interface {
 	Apply(*Config)
 }
@@ -25,6 +35,59 @@ func WithBaiDuConfig(url, clientId, clientSecret string) WithConfig {
 	}
 }
 
+func WithChatGptConfig(url, key string) WithConfig {
+	return func(c *Config) {
+		c.ChatGptUrl = url
+		c.ChatGptKey = key
+	}
+}
+
+// WithChatGptOrganization 设置 OpenAI-Organization 及 OpenAI-Project 请求头
+func WithChatGptOrganization(organization, project string) WithConfig {
+	return func(c *Config) {
+		c.ChatGptOrganization = organization
+		c.ChatGptProject = project
+	}
+}
+
+// WithChatGptAzureConfig Azure OpenAI 配置, url 为资源地址(如 https://{resource}.openai.azure.com)
+func WithChatGptAzureConfig(url, key, deployment, apiVersion string) WithConfig {
+	return func(c *Config) {
+		c.ChatGptUrl = url
+		c.ChatGptKey = key
+		c.ChatGptAzureDeployment = deployment
+		c.ChatGptAzureApiVersion = apiVersion
+	}
+}
+
+// WithGeminiConfig url 为接口根地址, 如 https://generativelanguage.googleapis.com/v1beta
+func WithGeminiConfig(url, key string) WithConfig {
+	return func(c *Config) {
+		c.GeminiUrl = url
+		c.GeminiKey = key
+	}
+}
+
+// WithOpenAICompatibleConfig 追加 OpenAI 兼容服务配置, 同名(不区分大小写)配置后者覆盖前者
+func WithOpenAICompatibleConfig(conf OpenAICompatibleConf) WithConfig {
+	return func(c *Config) {
+		for index, item := range c.OpenAICompatible {
+			if strings.EqualFold(item.Name, conf.Name) {
+				c.OpenAICompatible[index] = conf
+				return
+			}
+		}
+		c.OpenAICompatible = append(c.OpenAICompatible, conf)
+	}
+}
+
+func WithDeepSeekConfig(url, key string) WithConfig {
+	return func(c *Config) {
+		c.DeepSeekUrl = url
+		c.DeepSeekKey = key
+	}
+}
+
 func WithGlmConfig(url, key string) WithConfig {
 	return func(c *Config) {
 		c.GlmUrl = url
@@ -82,3 +145,151 @@ func WithXfYunConfig(url, key string) WithConfig {
 		c.XfYunKey = key
 	}
 }
+
+// WithHttpConfig 设置全局 HTTP 请求配置
+func WithHttpConfig(conf HttpConf) WithConfig {
+	return func(c *Config) {
+		c.Http = conf
+	}
+}
+
+// WithHttpClient 全局使用自定义 http.Client
+func WithHttpClient(client *http.Client) WithConfig {
+	return func(c *Config) {
+		c.Http.Client = client
+	}
+}
+
+// WithTransport 全局使用自定义 Transport
+func WithTransport(transport http.RoundTripper) WithConfig {
+	return func(c *Config) {
+		c.Http.Transport = transport
+	}
+}
+
+// WithProxy 全局设置代理地址
+func WithProxy(proxy string) WithConfig {
+	return func(c *Config) {
+		c.Http.Proxy = proxy
+	}
+}
+
+// WithTimeout 全局设置建立连接、等待响应头及读取响应体间隔的超时时间, 为 0 时使用默认值, 小于 0 时不限制
+func WithTimeout(connect, responseHeader, streamIdle time.Duration) WithConfig {
+	return func(c *Config) {
+		c.Http.ConnectTimeout = connect
+		c.Http.ResponseHeaderTimeout = responseHeader
+		c.Http.StreamIdleTimeout = streamIdle
+	}
+}
+
+// WithProviderHttpConfig 按服务商名称单独设置 HTTP 请求配置, 名称与注册名称一致(不区分大小写)
+func WithProviderHttpConfig(name string, conf HttpConf) WithConfig {
+	return func(c *Config) {
+		if c.ProviderHttp == nil {
+			c.ProviderHttp = make(map[string]HttpConf)
+		}
+		c.ProviderHttp[strings.ToLower(name)] = conf
+	}
+}
+
+// WithProviderHttpClient 按服务商名称使用自定义 http.Client
+func WithProviderHttpClient(name string, client *http.Client) WithConfig {
+	return func(c *Config) {
+		if c.ProviderHttp == nil {
+			c.ProviderHttp = make(map[string]HttpConf)
+		}
+		conf := c.ProviderHttp[strings.ToLower(name)]
+		conf.Client = client
+		c.ProviderHttp[strings.ToLower(name)] = conf
+	}
+}
+
+// WithRetry 设置全局重试策略
+func WithRetry(conf RetryConf) WithConfig {
+	return func(c *Config) {
+		c.Retry = conf
+	}
+}
+
+// WithProviderRetry 按服务商名称单独设置重试策略, 名称与注册名称一致(不区分大小写)
+func WithProviderRetry(name string, conf RetryConf) WithConfig {
+	return func(c *Config) {
+		if c.ProviderRetry == nil {
+			c.ProviderRetry = make(map[string]RetryConf)
+		}
+		c.ProviderRetry[strings.ToLower(name)] = conf
+	}
+}
+
+// WithCredentialPool 按服务商名称配置凭证池, 名称与注册名称一致(不区分大小写)
+func WithCredentialPool(name string, pool CredentialPool) WithConfig {
+	return func(c *Config) {
+		if c.Credentials == nil {
+			c.Credentials = make(map[string]CredentialPool)
+		}
+		c.Credentials[strings.ToLower(name)] = pool
+	}
+}
+
+// WithLimit 按服务商名称配置客户端限流, 名称与注册名称一致(不区分大小写)
+func WithLimit(name string, conf LimitConf) WithConfig {
+	return func(c *Config) {
+		if c.Limits == nil {
+			c.Limits = make(map[string]LimitConf)
+		}
+		c.Limits[strings.ToLower(name)] = conf
+	}
+}
+
+// WithBreaker 按服务商名称配置熔断, 名称与注册名称一致(不区分大小写)
+func WithBreaker(name string, conf BreakerConf) WithConfig {
+	return func(c *Config) {
+		if c.Breakers == nil {
+			c.Breakers = make(map[string]BreakerConf)
+		}
+		c.Breakers[strings.ToLower(name)] = conf
+	}
+}
+
+// WithPriceTable 设置价格表, 配置后响应中附带预估费用
+func WithPriceTable(table *PriceTable) WithConfig {
+	return func(c *Config) {
+		c.Prices = table
+	}
+}
+
+// WithCache 开启响应缓存, 适用于 temperature 为 0 等结果确定的请求
+func WithCache(cache Cache, ttl time.Duration) WithConfig {
+	return func(c *Config) {
+		c.Cache = CacheConf{Cache: cache, TTL: ttl}
+	}
+}
+
+// WithMiddleware 追加中间件, 按追加顺序由外到内执行
+func WithMiddleware(middlewares ...Middleware) WithConfig {
+	return func(c *Config) {
+		c.Middlewares = append(c.Middlewares, middlewares...)
+	}
+}
+
+// WithLogger 设置日志, 记录每次请求的开始及结束, 请求头中的凭证已脱敏, 不记录请求及响应内容
+func WithLogger(logger *slog.Logger) WithConfig {
+	return func(c *Config) {
+		c.Logger = logger
+	}
+}
+
+// WithTracerProvider 设置 OpenTelemetry TracerProvider, 为每次请求创建 span, 并为 HTTP 请求及鉴权 token 获取创建子 span
+func WithTracerProvider(provider trace.TracerProvider) WithConfig {
+	return func(c *Config) {
+		c.TracerProvider = provider
+	}
+}
+
+// WithMeterProvider 设置 OpenTelemetry MeterProvider, 记录请求次数、耗时、首个 token 耗时及 token 消耗
+func WithMeterProvider(provider metric.MeterProvider) WithConfig {
+	return func(c *Config) {
+		c.MeterProvider = provider
+	}
+}
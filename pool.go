@@ -0,0 +1,170 @@
+package pkg_ai
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	PoolRoundRobin    = "round_robin"     // 加权轮询
+	PoolLeastInFlight = "least_in_flight" // 最少进行中请求
+
+	DefaultEjectDuration = time.Minute // 默认凭证暂停使用时长
+)
+
+// Credential 凭证池中的一组凭证
+type Credential struct {
+	Alias  string    `json:"alias"`  // 别名, 记录在 Response.KeyAlias 中, 请勿填写密钥本身
+	Url    string    `json:"url"`    // 请求地址, 为空时沿用服务商配置的地址
+	Key    string    `json:"key"`    // 密钥; 百度、混元、商汤等使用 ClientId/ClientSecret 的服务商填写 ClientId
+	Secret string    `json:"secret"` // 百度、混元、商汤等服务商的 ClientSecret
+	Weight int       `json:"weight"` // 权重, 小于等于 0 时为 1
+	Limit  LimitConf `json:"limit"`  // 该组凭证的客户端限流
+}
+
+func (c Credential) url(def string) string {
+	if len(c.Url) > 0 {
+		return c.Url
+	}
+
+	return def
+}
+
+// CredentialPool 同一服务商的多组凭证, 按策略选择, 鉴权失败或额度不足的凭证暂停使用一段时间
+type CredentialPool struct {
+	Credentials   []Credential  `json:"credentials"`
+	Strategy      string        `json:"strategy"`       // 选择策略【round_robin 、 least_in_flight】, 默认 round_robin
+	EjectDuration time.Duration `json:"eject_duration"` // 鉴权失败或额度不足后暂停使用的时长, 默认 1 分钟
+}
+
+type credentialEntry struct {
+	alias    string
+	provider Provider
+	weight   int
+	current  int // 平滑加权轮询的当前权重
+	inFlight int
+	ejected  time.Time // 暂停使用截止时间
+	limit    *limiter
+}
+
+type credentialPool struct {
+	lock     sync.Mutex
+	strategy string
+	eject    time.Duration
+	entries  []*credentialEntry
+}
+
+func newCredentialPool(name string, conf CredentialPool, build func(credential Credential) (Provider, error)) (*credentialPool, error) {
+	pool := &credentialPool{strategy: conf.Strategy, eject: conf.EjectDuration, entries: make([]*credentialEntry, 0, len(conf.Credentials))}
+	if pool.eject <= 0 {
+		pool.eject = DefaultEjectDuration
+	}
+
+	for index, credential := range conf.Credentials {
+		provider, err := build(credential)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := &credentialEntry{alias: credential.Alias, provider: provider, weight: credential.Weight, limit: newLimiter(credential.Limit)}
+		if len(entry.alias) == 0 {
+			entry.alias = name + "#" + strconv.Itoa(index+1)
+		}
+		if entry.weight <= 0 {
+			entry.weight = 1
+		}
+		pool.entries = append(pool.entries, entry)
+	}
+
+	return pool, nil
+}
+
+// acquire 选择一组凭证, skip 中的凭证不参与选择, 均被跳过时返回 nil; 所有凭证均暂停使用时选择最早恢复的凭证
+func (p *credentialPool) acquire(skip map[*credentialEntry]bool) *credentialEntry {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := time.Now()
+	active := false
+	var earliest *credentialEntry
+	candidates := make([]*credentialEntry, 0, len(p.entries))
+	for _, entry := range p.entries {
+		if now.After(entry.ejected) {
+			active = true
+			if !skip[entry] {
+				candidates = append(candidates, entry)
+			}
+		} else if !skip[entry] && (earliest == nil || entry.ejected.Before(earliest.ejected)) {
+			earliest = entry
+		}
+	}
+	if len(candidates) == 0 {
+		// 还有未暂停使用的凭证时不选择已暂停的凭证
+		if active || earliest == nil {
+			return nil
+		}
+		candidates = append(candidates, earliest)
+	}
+
+	var selected *credentialEntry
+	switch p.strategy {
+	case PoolLeastInFlight:
+		for _, entry := range candidates {
+			if selected == nil || entry.inFlight*selected.weight < selected.inFlight*entry.weight {
+				selected = entry
+			}
+		}
+	default:
+		// 平滑加权轮询
+		total := 0
+		for _, entry := range candidates {
+			entry.current += entry.weight
+			total += entry.weight
+			if selected == nil || entry.current > selected.current {
+				selected = entry
+			}
+		}
+		selected.current -= total
+	}
+
+	selected.inFlight++
+	return selected
+}
+
+// release 请求结束, 鉴权失败或额度不足时暂停使用该凭证
+func (p *credentialPool) release(entry *credentialEntry, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	entry.inFlight--
+	if credentialError(err) {
+		entry.ejected = time.Now().Add(p.eject)
+	}
+}
+
+// available 是否还有未暂停使用的凭证
+func (p *credentialPool) available() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := time.Now()
+	for _, entry := range p.entries {
+		if now.After(entry.ejected) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// credentialError 鉴权失败或额度不足等与凭证相关的错误, 不含 access_token 过期等刷新后可重试的错误
+func credentialError(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.retryable {
+		return false
+	}
+
+	return apiErr.Category == CategoryAuth || apiErr.Category == CategoryQuota
+}
@@ -0,0 +1,107 @@
+package pkg_ai
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCredentialPoolRoundRobin(t *testing.T) {
+	upstream := newDeepSeekUpstream(t, func(r *http.Request) (int, string) {
+		return http.StatusOK, deepSeekReply
+	})
+
+	client := NewClient(&Config{}, WithDeepSeekConfig(upstream.URL, "sk"), WithCredentialPool("deepseek", CredentialPool{
+		Credentials: []Credential{{Alias: "a", Key: "sk-a", Weight: 2}, {Alias: "b", Key: "sk-b"}},
+	}))
+	server, err := client.NewServer(ImplementDeepSeek)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliases := make([]string, 0, 6)
+	for i := 0; i < 6; i++ {
+		response, err := server.Chat(RequestData{Model: "deepseek-chat", UserQuery: "你好"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		aliases = append(aliases, response.KeyAlias)
+	}
+	if got := strings.Join(aliases, ","); got != "a,b,a,a,b,a" {
+		t.Errorf("aliases = %s", got)
+	}
+}
+
+func TestCredentialPoolEject(t *testing.T) {
+	var bad int32
+	upstream := newDeepSeekUpstream(t, func(r *http.Request) (int, string) {
+		if r.Header.Get("Authorization") == "Bearer sk-bad" {
+			atomic.AddInt32(&bad, 1)
+			return http.StatusUnauthorized, `{"error":{"message":"invalid api key","type":"authentication_error"}}`
+		}
+		return http.StatusOK, deepSeekReply
+	})
+
+	client := NewClient(&Config{}, WithDeepSeekConfig(upstream.URL, "sk"), WithRetry(RetryConf{MaxAttempts: 2, BaseDelay: time.Millisecond}),
+		WithCredentialPool("deepseek", CredentialPool{
+			Credentials:   []Credential{{Alias: "bad", Key: "sk-bad"}, {Alias: "good", Key: "sk-good"}},
+			EjectDuration: time.Minute,
+		}))
+	server, err := client.NewServer(ImplementDeepSeek)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 首次选中失效凭证, 暂停使用后换另一组凭证重试
+	for i := 0; i < 4; i++ {
+		response, err := server.Chat(RequestData{Model: "deepseek-chat", UserQuery: "你好"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.KeyAlias != "good" {
+			t.Errorf("alias = %s, want good", response.KeyAlias)
+		}
+	}
+	if bad != 1 {
+		t.Errorf("bad credential used %d times, want 1", bad)
+	}
+}
+
+func TestCredentialPoolLeastInFlight(t *testing.T) {
+	pool, err := newCredentialPool("deepseek", CredentialPool{
+		Credentials: []Credential{{Alias: "a"}, {Alias: "b"}},
+		Strategy:    PoolLeastInFlight,
+	}, func(credential Credential) (Provider, error) {
+		return newDeepSeekServer("", credential.Key), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := pool.acquire(nil)
+	second := pool.acquire(nil)
+	if first.alias == second.alias {
+		t.Fatalf("both requests use %s", first.alias)
+	}
+	pool.release(first, nil)
+	if third := pool.acquire(nil); third.alias != first.alias {
+		t.Errorf("third = %s, want %s", third.alias, first.alias)
+	}
+}
+
+func TestNewClientCopiesCredentials(t *testing.T) {
+	conf := &Config{Credentials: map[string]CredentialPool{
+		"deepseek": {Credentials: []Credential{{Alias: "a", Key: "sk-a"}}},
+	}}
+	client := NewClient(conf, WithCredentialPool("moonshot", CredentialPool{Credentials: []Credential{{Key: "sk-m"}}}))
+
+	if len(conf.Credentials) != 1 {
+		t.Errorf("conf.Credentials = %v", conf.Credentials)
+	}
+	conf.Credentials["deepseek"].Credentials[0].Key = "changed"
+	if key := client.Config().Credentials["deepseek"].Credentials[0].Key; key != "sk-a" {
+		t.Errorf("client credential key = %s, want sk-a", key)
+	}
+}
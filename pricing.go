@@ -0,0 +1,203 @@
+package pkg_ai
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	CurrencyCNY = "CNY"
+	CurrencyUSD = "USD"
+)
+
+// Price 模型单价, 按每 1K token 计价
+type Price struct {
+	Input       float64     `json:"input" yaml:"input"`               // 输入单价
+	Output      float64     `json:"output" yaml:"output"`             // 输出单价, 推理 token 按输出计价
+	CachedInput float64     `json:"cached_input" yaml:"cached_input"` // 命中缓存的输入单价, 为 0 时按输入单价计价
+	Currency    string      `json:"currency" yaml:"currency"`         // 币种【CNY 、 USD】, 为空时为 CNY
+	Tiers       []PriceTier `json:"tiers" yaml:"tiers"`               // 阶梯价格, 按输入 token 数匹配, 未匹配时使用上面的单价
+}
+
+// PriceTier 阶梯价格, 输入 token 数不超过 MaxPromptTokens 时使用该档单价, MaxPromptTokens 为 0 表示不限
+type PriceTier struct {
+	MaxPromptTokens int64   `json:"max_prompt_tokens" yaml:"max_prompt_tokens"`
+	Input           float64 `json:"input" yaml:"input"`
+	Output          float64 `json:"output" yaml:"output"`
+	CachedInput     float64 `json:"cached_input" yaml:"cached_input"`
+}
+
+// Cost 按价格表预估的费用
+type Cost struct {
+	Input    float64 `json:"input"`    // 未命中缓存的输入费用
+	Cached   float64 `json:"cached"`   // 命中缓存的输入费用
+	Output   float64 `json:"output"`   // 输出费用
+	Total    float64 `json:"total"`    // 总费用
+	Currency string  `json:"currency"` // 币种
+}
+
+// tier 按输入 token 数选出适用的单价
+func (p Price) tier(promptTokens int64) PriceTier {
+	tiers := append([]PriceTier(nil), p.Tiers...)
+	sort.SliceStable(tiers, func(i, j int) bool {
+		if tiers[i].MaxPromptTokens == 0 || tiers[j].MaxPromptTokens == 0 {
+			return tiers[j].MaxPromptTokens == 0 && tiers[i].MaxPromptTokens != 0
+		}
+		return tiers[i].MaxPromptTokens < tiers[j].MaxPromptTokens
+	})
+	for _, tier := range tiers {
+		if tier.MaxPromptTokens == 0 || promptTokens <= tier.MaxPromptTokens {
+			return tier
+		}
+	}
+
+	return PriceTier{Input: p.Input, Output: p.Output, CachedInput: p.CachedInput}
+}
+
+// estimate 按 token 消耗计算费用
+func (p Price) estimate(usage Usage) Cost {
+	tier := p.tier(usage.PromptTokens)
+
+	cached := usage.CachedPromptTokens
+	if cached > usage.PromptTokens {
+		cached = usage.PromptTokens
+	}
+	cachedPrice := tier.CachedInput
+	if cachedPrice == 0 {
+		cachedPrice = tier.Input
+	}
+
+	cost := Cost{
+		Input:    float64(usage.PromptTokens-cached) / 1000 * tier.Input,
+		Cached:   float64(cached) / 1000 * cachedPrice,
+		Output:   float64(usage.CompletionTokens) / 1000 * tier.Output,
+		Currency: p.Currency,
+	}
+	cost.Total = cost.Input + cost.Cached + cost.Output
+	if len(cost.Currency) == 0 {
+		cost.Currency = CurrencyCNY
+	}
+
+	return cost
+}
+
+// PriceTable 价格表, 服务商名称 => 模型 => 单价; 服务商名称不区分大小写, 模型以 * 结尾时按前缀匹配
+// 可在运行中通过 Load 重新加载, 已实例化的 Server 立即使用新价格
+type PriceTable struct {
+	lock   sync.RWMutex
+	prices map[string]map[string]Price
+}
+
+func NewPriceTable() *PriceTable {
+	return &PriceTable{prices: make(map[string]map[string]Price)}
+}
+
+// LoadPriceTable 从 JSON 或 YAML 文件加载价格表, 按扩展名 .yaml / .yml 识别 YAML, 其余按 JSON 解析
+func LoadPriceTable(path string) (*PriceTable, error) {
+	table := NewPriceTable()
+	if err := table.Load(path); err != nil {
+		return nil, err
+	}
+
+	return table, nil
+}
+
+// Load 从文件重新加载, 加载成功后整体替换原有价格
+func (t *PriceTable) Load(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	prices := make(map[string]map[string]Price)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &prices)
+	default:
+		err = json.Unmarshal(content, &prices)
+	}
+	if err != nil {
+		return errors.New("价格表解析失败: " + err.Error())
+	}
+
+	t.Replace(prices)
+
+	return nil
+}
+
+// Replace 整体替换价格
+func (t *PriceTable) Replace(prices map[string]map[string]Price) {
+	items := make(map[string]map[string]Price, len(prices))
+	for supplier, models := range prices {
+		key := strings.ToLower(supplier)
+		if items[key] == nil {
+			items[key] = make(map[string]Price, len(models))
+		}
+		for model, price := range models {
+			items[key][model] = price
+		}
+	}
+
+	t.lock.Lock()
+	t.prices = items
+	t.lock.Unlock()
+}
+
+// Set 设置单个模型的价格
+func (t *PriceTable) Set(supplier, model string, price Price) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	key := strings.ToLower(supplier)
+	if t.prices == nil {
+		t.prices = make(map[string]map[string]Price)
+	}
+	if t.prices[key] == nil {
+		t.prices[key] = make(map[string]Price)
+	}
+	t.prices[key][model] = price
+}
+
+// Lookup 查找模型价格, 精确匹配优先, 其次为最长的前缀匹配
+func (t *PriceTable) Lookup(supplier, model string) (Price, bool) {
+	if t == nil {
+		return Price{}, false
+	}
+
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	models := t.prices[strings.ToLower(supplier)]
+	if price, ok := models[model]; ok {
+		return price, true
+	}
+
+	var matched Price
+	length := -1
+	for key, price := range models {
+		prefix := strings.TrimSuffix(key, "*")
+		if prefix == key || !strings.HasPrefix(model, prefix) || len(prefix) <= length {
+			continue
+		}
+		matched, length = price, len(prefix)
+	}
+
+	return matched, length >= 0
+}
+
+// Estimate 按模型价格及 token 消耗预估费用, 未配置价格时返回 false
+func (t *PriceTable) Estimate(supplier, model string, usage Usage) (Cost, bool) {
+	price, ok := t.Lookup(supplier, model)
+	if !ok || usage.empty() {
+		return Cost{}, false
+	}
+
+	return price.estimate(usage), true
+}
@@ -0,0 +1,81 @@
+package pkg_ai
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// sensitiveHeaders 名称中包含以下关键字的请求头视为凭证
+var sensitiveHeaders = []string{"authorization", "key", "token", "secret", "signature", "cookie"}
+
+// sensitiveParams 视为凭证的查询参数, 如百度的 access_token、Gemini 的 key
+var sensitiveParams = map[string]bool{"access_token": true, "client_secret": true, "key": true, "api_key": true, "token": true, "signature": true}
+
+// RedactHeaders 序列化请求头并遮蔽其中的凭证, 第三方服务商记录 Response.RequestHeader 时可直接使用
+func RedactHeaders(headers map[string]string) []byte {
+	redacted := make(map[string]string, len(headers))
+	for key, value := range headers {
+		redacted[key] = value
+		name := strings.ToLower(key)
+		for _, keyword := range sensitiveHeaders {
+			if strings.Contains(name, keyword) {
+				redacted[key] = mask(value)
+				break
+			}
+		}
+	}
+
+	content, _ := json.Marshal(redacted)
+	return content
+}
+
+// RedactUrl 遮蔽地址查询参数中的凭证, 无法解析时原样返回
+func RedactUrl(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil || len(parsed.RawQuery) == 0 {
+		return rawUrl
+	}
+
+	query := parsed.Query()
+	changed := false
+	for key, values := range query {
+		if !sensitiveParams[strings.ToLower(key)] {
+			continue
+		}
+		for index, value := range values {
+			values[index] = mask(value)
+		}
+		changed = true
+	}
+	if !changed {
+		return rawUrl
+	}
+	parsed.RawQuery = strings.ReplaceAll(query.Encode(), "%2A", "*")
+
+	return parsed.String()
+}
+
+// mask 保留鉴权方案(如 Bearer)及前 4 个字符, 其余以 **** 代替
+func mask(value string) string {
+	scheme := ""
+	if index := strings.IndexByte(value, ' '); index > 0 {
+		scheme, value = value[:index+1], value[index+1:]
+	}
+	if len(value) <= 8 {
+		return scheme + "****"
+	}
+
+	return scheme + value[:4] + "****"
+}
+
+// redactError 遮蔽请求错误中地址携带的凭证
+func redactError(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+
+	return &url.Error{Op: urlErr.Op, URL: RedactUrl(urlErr.URL), Err: urlErr.Err}
+}
@@ -0,0 +1,115 @@
+package pkg_ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedactHeaders(t *testing.T) {
+	cases := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"Authorization", "Bearer sk-1234567890abcdef", "Bearer sk-1****"},
+		{"authorization", "Bearer short", "Bearer ****"},
+		{"api-key", "0123456789abcdef", "0123****"},
+		{"x-goog-api-key", "AIzaSyA-abcdefgh", "AIza****"},
+		{"X-TC-Token", "", "****"},
+		{"Cookie", "session=abcdefghijk", "sess****"},
+		{"Content-Type", "application/json", "application/json"},
+		{"X-Request-Id", "req-1234567890", "req-1234567890"},
+	}
+
+	headers := make(map[string]string, len(cases))
+	for _, item := range cases {
+		headers[item.name] = item.value
+	}
+	redacted := make(map[string]string)
+	if err := json.Unmarshal(RedactHeaders(headers), &redacted); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, item := range cases {
+		if got := redacted[item.name]; got != item.want {
+			t.Errorf("%s = %q, want %q", item.name, got, item.want)
+		}
+	}
+	// 不修改调用方的请求头
+	if headers["Authorization"] != "Bearer sk-1234567890abcdef" {
+		t.Errorf("headers modified: %v", headers)
+	}
+}
+
+func TestRedactUrl(t *testing.T) {
+	cases := []struct {
+		url  string
+		want string
+	}{
+		{
+			"https://generativelanguage.googleapis.com/v1beta/models/gemini-pro:streamGenerateContent?alt=sse&key=AIzaSyA-abcdefgh",
+			"https://generativelanguage.googleapis.com/v1beta/models/gemini-pro:streamGenerateContent?alt=sse&key=AIza****",
+		},
+		{
+			"https://aip.baidubce.com/oauth/2.0/token?client_id=ak-123456789&client_secret=sk-abcdefghijk&grant_type=client_credentials",
+			"https://aip.baidubce.com/oauth/2.0/token?client_id=ak-123456789&client_secret=sk-a****&grant_type=client_credentials",
+		},
+		{
+			"https://aip.baidubce.com/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/completions?access_token=24.abcdefghijklmn",
+			"https://aip.baidubce.com/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/completions?access_token=24.a****",
+		},
+		{"https://api.example.com/v1?API_KEY=short", "https://api.example.com/v1?API_KEY=****"},
+		// 无凭证参数或无法解析时原样返回
+		{"https://api.deepseek.com/chat/completions", "https://api.deepseek.com/chat/completions"},
+		{"https://api.example.com/v1?b=2&a=1", "https://api.example.com/v1?b=2&a=1"},
+		{"://bad url?key=secret", "://bad url?key=secret"},
+	}
+
+	for _, item := range cases {
+		if got := RedactUrl(item.url); got != item.want {
+			t.Errorf("RedactUrl(%s) = %s, want %s", item.url, got, item.want)
+		}
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	rawUrl := "https://generativelanguage.googleapis.com/v1beta/models/gemini-pro:generateContent?key=AIzaSyA-abcdefgh"
+	cause := &url.Error{Op: "Post", URL: rawUrl, Err: context.DeadlineExceeded}
+
+	cases := []struct {
+		name string
+		err  error
+	}{
+		{"url error", cause},
+		{"wrapped", fmt.Errorf("request: %w", cause)},
+	}
+	for _, item := range cases {
+		t.Run(item.name, func(t *testing.T) {
+			err := redactError(item.err)
+			if strings.Contains(err.Error(), "AIzaSyA-abcdefgh") || !strings.Contains(err.Error(), "key=AIza****") {
+				t.Errorf("err = %v", err)
+			}
+			// 保留原始错误以便 errors.Is 判断
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("errors.Is(%v, DeadlineExceeded) = false", err)
+			}
+		})
+	}
+
+	// 非 *url.Error 原样返回
+	if err := redactError(io.EOF); err != io.EOF {
+		t.Errorf("err = %v, want io.EOF", err)
+	}
+
+	// 网络层错误信息中不包含凭证
+	apiErr := newTransportError("gemini", cause)
+	if strings.Contains(apiErr.Message, "AIzaSyA-abcdefgh") || apiErr.Category != CategoryTimeout {
+		t.Errorf("err = %+v", apiErr)
+	}
+}
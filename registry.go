@@ -0,0 +1,237 @@
+package pkg_ai
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ProviderFactory 根据所属 Client 的配置实例化服务商, 配置缺失时返回 ErrorNoConfig
+type ProviderFactory func(conf Config) (Provider, error)
+
+type registration struct {
+	name        string
+	implementId int8
+	factory     ProviderFactory
+	credential  func(conf *Config, credential Credential) // 将凭证写入配置, 为空时不支持凭证池
+}
+
+var (
+	registryLock sync.RWMutex
+	registryName = make(map[string]*registration) // 名称(小写) => 注册信息
+	registryId   = make(map[int8]*registration)   // 实现ID => 注册信息
+)
+
+// Register 按名称注册服务商, 名称不区分大小写, 重复注册时覆盖原有实现
+// 覆盖内置服务商时保留其实现ID及凭证池支持; 新增的服务商不支持凭证池
+func Register(name string, factory ProviderFactory) {
+	RegisterImplement(0, name, factory)
+}
+
+// RegisterImplement 按名称注册服务商并绑定实现ID, 之后可通过 NewServer(implementId) 实例化; implementId 为 0 时仅按名称注册
+func RegisterImplement(implementId int8, name string, factory ProviderFactory) {
+	register(implementId, name, factory, nil)
+}
+
+func register(implementId int8, name string, factory ProviderFactory, credential func(conf *Config, credential Credential)) {
+	if len(name) == 0 {
+		panic("pkg_ai: Register name is empty")
+	}
+	if factory == nil {
+		panic("pkg_ai: Register factory is nil")
+	}
+
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	// 按名称覆盖已有实现时沿用原实现ID及凭证池支持, 新的 factory 收到的配置已写入凭证
+	key := strings.ToLower(name)
+	if old, ok := registryName[key]; ok {
+		if implementId == 0 {
+			implementId = old.implementId
+		} else if old.implementId != 0 && old.implementId != implementId && registryId[old.implementId] == old {
+			delete(registryId, old.implementId)
+		}
+		if credential == nil {
+			credential = old.credential
+		}
+	}
+
+	item := &registration{name: name, implementId: implementId, factory: factory, credential: credential}
+	registryName[key] = item
+	if implementId != 0 {
+		registryId[implementId] = item
+	}
+}
+
+// Providers 已注册的服务商名称, 按字母排序
+func Providers() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	names := make([]string, 0, len(registryName))
+	for _, item := range registryName {
+		names = append(names, item.name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func lookupName(name string) (*registration, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	item, ok := registryName[strings.ToLower(name)]
+	return item, ok
+}
+
+func lookupId(implementId int8) (*registration, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	item, ok := registryId[implementId]
+	return item, ok
+}
+
+func (r *registration) newServer(c *Client) (*Server, error) {
+	return c.newServer(r.implementId, r.name, func(credential *Credential) (Provider, error) {
+		if credential == nil {
+			return r.factory(c.config)
+		}
+		if r.credential == nil {
+			return nil, errors.New("服务商【" + r.name + "】不支持凭证池")
+		}
+
+		conf := c.config
+		r.credential(&conf, *credential)
+		return r.factory(conf)
+	})
+}
+
+func init() {
+	register(ImplementMoonshot, "moonshot", func(conf Config) (Provider, error) {
+		if len(conf.MoonshotKey) == 0 || len(conf.MoonshotUrl) == 0 {
+			return nil, ErrorNoConfig
+		}
+		return newMoonshotServer(conf.MoonshotUrl, conf.MoonshotKey), nil
+	}, func(conf *Config, credential Credential) {
+		conf.MoonshotUrl, conf.MoonshotKey = credential.url(conf.MoonshotUrl), credential.Key
+	})
+
+	register(ImplementMinimaxi, "minimaxi", func(conf Config) (Provider, error) {
+		if len(conf.MinimaxiUrl) == 0 || len(conf.MinimaxiKey) == 0 {
+			return nil, ErrorNoConfig
+		}
+		return newMinimaxiServer(conf.MinimaxiUrl, conf.MinimaxiKey), nil
+	}, func(conf *Config, credential Credential) {
+		conf.MinimaxiUrl, conf.MinimaxiKey = credential.url(conf.MinimaxiUrl), credential.Key
+	})
+
+	register(ImplementVolc, "volc", func(conf Config) (Provider, error) {
+		if len(conf.VolcUrl) == 0 || len(conf.VolcKey) == 0 {
+			return nil, ErrorNoConfig
+		}
+		return newVolcServer(conf.VolcUrl, conf.VolcKey), nil
+	}, func(conf *Config, credential Credential) {
+		conf.VolcUrl, conf.VolcKey = credential.url(conf.VolcUrl), credential.Key
+	})
+
+	register(ImplementBaidu, "baidubce", func(conf Config) (Provider, error) {
+		if len(conf.BaiDuUrl) == 0 || len(conf.BaiDuClientId) == 0 || len(conf.BaiDuClientSecret) == 0 {
+			return nil, ErrorNoConfig
+		}
+		return newBaiDuServer(conf.BaiDuUrl, conf.BaiDuClientId, conf.BaiDuClientSecret), nil
+	}, func(conf *Config, credential Credential) {
+		conf.BaiDuUrl, conf.BaiDuClientId, conf.BaiDuClientSecret = credential.url(conf.BaiDuUrl), credential.Key, credential.Secret
+	})
+
+	register(ImplementQwen, "qwen", func(conf Config) (Provider, error) {
+		if len(conf.QwenUrl) == 0 || len(conf.QwenKey) == 0 {
+			return nil, ErrorNoConfig
+		}
+		return newQwenServer(conf.QwenUrl, conf.QwenKey), nil
+	}, func(conf *Config, credential Credential) {
+		conf.QwenUrl, conf.QwenKey = credential.url(conf.QwenUrl), credential.Key
+	})
+
+	register(ImplementHunyuan, "hunyuan", func(conf Config) (Provider, error) {
+		if len(conf.HunyuanUrl) == 0 || len(conf.HunyuanClientId) == 0 || len(conf.HunyuanClientSecret) == 0 {
+			return nil, ErrorNoConfig
+		}
+		return newHunyuanServer(conf.HunyuanUrl, conf.HunyuanClientId, conf.HunyuanClientSecret), nil
+	}, func(conf *Config, credential Credential) {
+		conf.HunyuanUrl, conf.HunyuanClientId, conf.HunyuanClientSecret = credential.url(conf.HunyuanUrl), credential.Key, credential.Secret
+	})
+
+	register(ImplementGlm, "bigmodel", func(conf Config) (Provider, error) {
+		if len(conf.GlmUrl) == 0 || len(conf.GlmKey) == 0 {
+			return nil, ErrorNoConfig
+		}
+		return newGlmServer(conf.GlmUrl, conf.GlmKey), nil
+	}, func(conf *Config, credential Credential) {
+		conf.GlmUrl, conf.GlmKey = credential.url(conf.GlmUrl), credential.Key
+	})
+
+	register(ImplementXfYun, "xfyun", func(conf Config) (Provider, error) {
+		if len(conf.XfYunUrl) == 0 || len(conf.XfYunKey) == 0 {
+			return nil, ErrorNoConfig
+		}
+		return newXfYunServer(conf.XfYunUrl, conf.XfYunKey), nil
+	}, func(conf *Config, credential Credential) {
+		conf.XfYunUrl, conf.XfYunKey = credential.url(conf.XfYunUrl), credential.Key
+	})
+
+	register(ImplementBaiChuan, "baichuan", func(conf Config) (Provider, error) {
+		if len(conf.BaiChuanUrl) == 0 || len(conf.BaiChuanKey) == 0 {
+			return nil, ErrorNoConfig
+		}
+		return newBaiChuanServer(conf.BaiChuanUrl, conf.BaiChuanKey), nil
+	}, func(conf *Config, credential Credential) {
+		conf.BaiChuanUrl, conf.BaiChuanKey = credential.url(conf.BaiChuanUrl), credential.Key
+	})
+
+	register(ImplementSensenova, "sensenova", func(conf Config) (Provider, error) {
+		if len(conf.SensenovaUrl) == 0 || len(conf.SensenovaClientId) == 0 || len(conf.SensenovaClientSecret) == 0 {
+			return nil, ErrorNoConfig
+		}
+		return newSensenovaServer(conf.SensenovaUrl, conf.SensenovaClientId, conf.SensenovaClientSecret), nil
+	}, func(conf *Config, credential Credential) {
+		conf.SensenovaUrl, conf.SensenovaClientId, conf.SensenovaClientSecret = credential.url(conf.SensenovaUrl), credential.Key, credential.Secret
+	})
+
+	register(ImplementDeepSeek, "deepSeek", func(conf Config) (Provider, error) {
+		if len(conf.DeepSeekUrl) == 0 || len(conf.DeepSeekKey) == 0 {
+			return nil, ErrorNoConfig
+		}
+		return newDeepSeekServer(conf.DeepSeekUrl, conf.DeepSeekKey), nil
+	}, func(conf *Config, credential Credential) {
+		conf.DeepSeekUrl, conf.DeepSeekKey = credential.url(conf.DeepSeekUrl), credential.Key
+	})
+
+	register(ImplementChatGpt, "chatGpt", func(conf Config) (Provider, error) {
+		if len(conf.ChatGptUrl) == 0 || len(conf.ChatGptKey) == 0 {
+			return nil, ErrorNoConfig
+		}
+		return newChatGptServer(ChatGptConf{
+			Url:             conf.ChatGptUrl,
+			Key:             conf.ChatGptKey,
+			Organization:    conf.ChatGptOrganization,
+			Project:         conf.ChatGptProject,
+			AzureDeployment: conf.ChatGptAzureDeployment,
+			AzureApiVersion: conf.ChatGptAzureApiVersion,
+		}), nil
+	}, func(conf *Config, credential Credential) {
+		conf.ChatGptUrl, conf.ChatGptKey = credential.url(conf.ChatGptUrl), credential.Key
+	})
+
+	register(ImplementGemini, "gemini", func(conf Config) (Provider, error) {
+		if len(conf.GeminiUrl) == 0 || len(conf.GeminiKey) == 0 {
+			return nil, ErrorNoConfig
+		}
+		return newGeminiServer(conf.GeminiUrl, conf.GeminiKey), nil
+	}, func(conf *Config, credential Credential) {
+		conf.GeminiUrl, conf.GeminiKey = credential.url(conf.GeminiUrl), credential.Key
+	})
+}
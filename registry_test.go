@@ -0,0 +1,67 @@
+package pkg_ai
+
+import (
+	"testing"
+)
+
+// restoreRegistration 测试结束后恢复被覆盖的注册信息
+func restoreRegistration(t *testing.T, name string) {
+	t.Helper()
+
+	old, ok := lookupName(name)
+	if !ok {
+		t.Fatalf("%s not registered", name)
+	}
+	t.Cleanup(func() {
+		registryLock.Lock()
+		defer registryLock.Unlock()
+		registryName[name] = old
+		registryId[old.implementId] = old
+	})
+}
+
+func TestRegisterOverrideKeepsImplement(t *testing.T) {
+	restoreRegistration(t, "moonshot")
+
+	keys := make([]string, 0)
+	Register("Moonshot", func(conf Config) (Provider, error) {
+		keys = append(keys, conf.MoonshotKey)
+		return newMoonshotServer(conf.MoonshotUrl, conf.MoonshotKey), nil
+	})
+
+	client := NewClient(&Config{}, WithMoonshotConfig("http://127.0.0.1", "sk"), WithCredentialPool("moonshot", CredentialPool{
+		Credentials: []Credential{{Key: "sk-a"}, {Key: "sk-b"}},
+	}))
+	server, err := client.NewServer(ImplementMoonshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.ImplementId != ImplementMoonshot || server.pool == nil {
+		t.Errorf("implement = %d, pooled = %v", server.ImplementId, server.pool != nil)
+	}
+	// 首组凭证用于默认实例, 之后每组凭证各实例化一次
+	if len(keys) != 3 || keys[0] != "sk-a" || keys[1] != "sk-a" || keys[2] != "sk-b" {
+		t.Errorf("factory keys = %v", keys)
+	}
+}
+
+func TestRegisterNewProviderWithoutPool(t *testing.T) {
+	name := "registry-test"
+	t.Cleanup(func() {
+		registryLock.Lock()
+		defer registryLock.Unlock()
+		delete(registryName, name)
+	})
+
+	Register(name, func(conf Config) (Provider, error) {
+		return newMoonshotServer("http://127.0.0.1", "sk"), nil
+	})
+
+	if _, err := NewClient(&Config{}).NewServerByName("Registry-Test"); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(&Config{}, WithCredentialPool(name, CredentialPool{Credentials: []Credential{{Key: "sk-a"}}}))
+	if _, err := client.NewServerByName(name); err == nil {
+		t.Error("want error for credential pool on provider without credential support")
+	}
+}
@@ -0,0 +1,91 @@
+package pkg_ai
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+const (
+	DefaultRetryBaseDelay = 500 * time.Millisecond // 默认首次重试等待时间
+	DefaultRetryMaxDelay  = 10 * time.Second       // 默认单次重试等待时间上限
+)
+
+// RetryConf 重试策略, 仅对限流、服务端错误、网络错误等可重试的错误生效
+type RetryConf struct {
+	MaxAttempts int           `json:"max_attempts"` // 最大尝试次数(含首次请求), 小于等于 1 时不重试
+	BaseDelay   time.Duration `json:"base_delay"`   // 首次重试前的等待时间, 之后每次翻倍并叠加随机抖动
+	MaxDelay    time.Duration `json:"max_delay"`    // 单次等待时间上限; 上游要求的 Retry-After 超过该值时不再重试
+}
+
+// retryable 判断错误是否可重试, 并返回上游要求的最短等待时间
+func retryable(err error) (bool, time.Duration) {
+	if errors.Is(err, ErrorStreamIdleTimeout) {
+		return true, 0
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.retryable, apiErr.retryAfter
+	}
+
+	return false, 0
+}
+
+// delay 第 attempt 次重试前的等待时间, 返回 false 表示不再重试
+func (r RetryConf) delay(attempt int, retryAfter time.Duration) (time.Duration, bool) {
+	base, max := r.BaseDelay, r.MaxDelay
+	if base <= 0 {
+		base = DefaultRetryBaseDelay
+	}
+	if max <= 0 {
+		max = DefaultRetryMaxDelay
+	}
+	if retryAfter > max {
+		return 0, false
+	}
+
+	wait := max
+	if attempt <= 30 {
+		if next := base << (attempt - 1); next > 0 && next < max {
+			wait = next
+		}
+	}
+	// 等待时间在 [wait/2, wait) 之间随机, 避免多个请求同时重试
+	wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+
+	if wait < retryAfter {
+		wait = retryAfter
+	}
+
+	return wait, true
+}
+
+// do 按重试策略执行请求, allow 返回 false 时(如流式请求已推送数据)不再重试
+func (r RetryConf) do(ctx context.Context, fun func() (*Response, error), allow func() bool) (*Response, error) {
+	for attempt := 1; ; attempt++ {
+		response, err := fun()
+		if err == nil || attempt >= r.MaxAttempts || ctx.Err() != nil {
+			return response, err
+		}
+		if allow != nil && !allow() {
+			return response, err
+		}
+
+		ok, retryAfter := retryable(err)
+		if !ok {
+			return response, err
+		}
+		wait, ok := r.delay(attempt, retryAfter)
+		if !ok {
+			return response, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return response, err
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package pkg_ai
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const deepSeekReply = `{"id":"ds-1","choices":[{"message":{"role":"assistant","content":"你好"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`
+
+// newDeepSeekUpstream 模拟 DeepSeek 接口, reply 按请求返回状态码及响应体
+func newDeepSeekUpstream(t *testing.T, reply func(r *http.Request) (int, string)) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, body := reply(r)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// failTimes 前 n 次请求返回 status, 之后返回正常响应
+func failTimes(n int32, status int, hits *int32) func(r *http.Request) (int, string) {
+	return func(r *http.Request) (int, string) {
+		if atomic.AddInt32(hits, 1) <= n {
+			return status, `{"error":{"message":"upstream failed","type":"error"}}`
+		}
+		return http.StatusOK, deepSeekReply
+	}
+}
+
+func TestRetryRecovers(t *testing.T) {
+	var hits int32
+	upstream := newDeepSeekUpstream(t, failTimes(2, http.StatusServiceUnavailable, &hits))
+
+	client := NewClient(&Config{}, WithDeepSeekConfig(upstream.URL, "sk"), WithRetry(RetryConf{MaxAttempts: 3, BaseDelay: time.Millisecond}))
+	server, err := client.NewServer(ImplementDeepSeek)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := server.Chat(RequestData{Model: "deepseek-chat", UserQuery: "你好"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.ResponseText != "你好" || hits != 3 {
+		t.Errorf("response = %q, hits = %d", response.ResponseText, hits)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	var hits int32
+	upstream := newDeepSeekUpstream(t, failTimes(10, http.StatusServiceUnavailable, &hits))
+
+	client := NewClient(&Config{}, WithDeepSeekConfig(upstream.URL, "sk"), WithRetry(RetryConf{MaxAttempts: 2, BaseDelay: time.Millisecond}))
+	server, err := client.NewServer(ImplementDeepSeek)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.Chat(RequestData{Model: "deepseek-chat", UserQuery: "你好"})
+	apiErr := &APIError{}
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("err = %v", err)
+	}
+	if hits != 2 {
+		t.Errorf("hits = %d, want 2", hits)
+	}
+}
+
+func TestRetrySkipsInvalidRequest(t *testing.T) {
+	var hits int32
+	upstream := newDeepSeekUpstream(t, failTimes(10, http.StatusBadRequest, &hits))
+
+	client := NewClient(&Config{}, WithDeepSeekConfig(upstream.URL, "sk"), WithRetry(RetryConf{MaxAttempts: 3, BaseDelay: time.Millisecond}))
+	server, err := client.NewServer(ImplementDeepSeek)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := server.Chat(RequestData{Model: "deepseek-chat", UserQuery: "你好"}); err == nil {
+		t.Fatal("want error")
+	}
+	if hits != 1 {
+		t.Errorf("hits = %d, want 1", hits)
+	}
+}
+
+func TestRetryAfterExceedsMaxDelay(t *testing.T) {
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = io.WriteString(w, `{"error":{"message":"rate limited","type":"rate_limit"}}`)
+	}))
+	defer upstream.Close()
+
+	client := NewClient(&Config{}, WithDeepSeekConfig(upstream.URL, "sk"), WithRetry(RetryConf{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Second}))
+	server, err := client.NewServer(ImplementDeepSeek)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := server.Chat(RequestData{Model: "deepseek-chat", UserQuery: "你好"}); err == nil {
+		t.Fatal("want error")
+	}
+	if hits != 1 {
+		t.Errorf("hits = %d, want 1", hits)
+	}
+}
+
+func TestProviderRetry(t *testing.T) {
+	var hits int32
+	upstream := newDeepSeekUpstream(t, failTimes(1, http.StatusBadGateway, &hits))
+
+	conf := &Config{}
+	client := NewClient(conf, WithDeepSeekConfig(upstream.URL, "sk"), WithProviderRetry("DeepSeek", RetryConf{MaxAttempts: 2, BaseDelay: time.Millisecond}))
+	if len(conf.ProviderRetry) != 0 {
+		t.Errorf("conf.ProviderRetry modified: %v", conf.ProviderRetry)
+	}
+
+	server, err := client.NewServer(ImplementDeepSeek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Chat(RequestData{Model: "deepseek-chat", UserQuery: "你好"}); err != nil {
+		t.Fatal(err)
+	}
+	if hits != 2 {
+		t.Errorf("hits = %d, want 2", hits)
+	}
+}
@@ -1,43 +1,70 @@
 package pkg_ai
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"log/slog"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
-	once    sync.Once
-	config  Config // 全局配置
-	hasInit bool   // 配置是否初始化
+	defaultLock   sync.RWMutex
+	defaultClient *Client // 默认实例, 由 Init 初始化
 )
 
 type Config struct {
-	MoonshotUrl           string `json:"moonshot_url"`
-	MoonshotKey           string `json:"moonshot_key"`
-	MinimaxiUrl           string `json:"minimaxi_url"`
-	MinimaxiKey           string `json:"minimaxi_key"`
-	VolcUrl               string `json:"volc_url"`
-	VolcKey               string `json:"volc_key"`
-	BaiDuUrl              string `json:"bai_du_url"`
-	BaiDuClientId         string `json:"bai_du_client_id"`
-	BaiDuClientSecret     string `json:"bai_du_client_secret"`
-	QwenUrl               string `json:"qwen_url"`
-	QwenKey               string `json:"qwen_key"`
-	HunyuanUrl            string `json:"hunyuan_url"`
-	HunyuanClientId       string `json:"hunyuan_client_id"`
-	HunyuanClientSecret   string `json:"hunyuan_client_secret"`
-	GlmUrl                string `json:"glm_url"`
-	GlmKey                string `json:"glm_key"`
-	XfYunUrl              string `json:"xf_yun_url"`
-	XfYunKey              string `json:"xf_yun_key"`
-	BaiChuanUrl           string `json:"bai_chuan_url"`
-	BaiChuanKey           string `json:"bai_chuan_key"`
-	SensenovaUrl          string `json:"sensenova_url"`
-	SensenovaClientId     string `json:"sensenova_client_id"`
-	SensenovaClientSecret string `json:"sensenova_client_secret"`
-	DeepSeekUrl           string `json:"deep_seek_url"`
-	DeepSeekKey           string `json:"deep_seek_key"`
+	MoonshotUrl            string                    `json:"moonshot_url"`
+	MoonshotKey            string                    `json:"moonshot_key"`
+	MinimaxiUrl            string                    `json:"minimaxi_url"`
+	MinimaxiKey            string                    `json:"minimaxi_key"`
+	VolcUrl                string                    `json:"volc_url"`
+	VolcKey                string                    `json:"volc_key"`
+	BaiDuUrl               string                    `json:"bai_du_url"`
+	BaiDuClientId          string                    `json:"bai_du_client_id"`
+	BaiDuClientSecret      string                    `json:"bai_du_client_secret"`
+	QwenUrl                string                    `json:"qwen_url"`
+	QwenKey                string                    `json:"qwen_key"`
+	HunyuanUrl             string                    `json:"hunyuan_url"`
+	HunyuanClientId        string                    `json:"hunyuan_client_id"`
+	HunyuanClientSecret    string                    `json:"hunyuan_client_secret"`
+	GlmUrl                 string                    `json:"glm_url"`
+	GlmKey                 string                    `json:"glm_key"`
+	XfYunUrl               string                    `json:"xf_yun_url"`
+	XfYunKey               string                    `json:"xf_yun_key"`
+	BaiChuanUrl            string                    `json:"bai_chuan_url"`
+	BaiChuanKey            string                    `json:"bai_chuan_key"`
+	SensenovaUrl           string                    `json:"sensenova_url"`
+	SensenovaClientId      string                    `json:"sensenova_client_id"`
+	SensenovaClientSecret  string                    `json:"sensenova_client_secret"`
+	DeepSeekUrl            string                    `json:"deep_seek_url"`
+	DeepSeekKey            string                    `json:"deep_seek_key"`
+	ChatGptUrl             string                    `json:"chat_gpt_url"`
+	ChatGptKey             string                    `json:"chat_gpt_key"`
+	ChatGptOrganization    string                    `json:"chat_gpt_organization"`
+	ChatGptProject         string                    `json:"chat_gpt_project"`
+	ChatGptAzureDeployment string                    `json:"chat_gpt_azure_deployment"` // 配置后按 Azure OpenAI 方式请求
+	ChatGptAzureApiVersion string                    `json:"chat_gpt_azure_api_version"`
+	GeminiUrl              string                    `json:"gemini_url"` // 接口根地址, 如 https://generativelanguage.googleapis.com/v1beta
+	GeminiKey              string                    `json:"gemini_key"`
+	OpenAICompatible       []OpenAICompatibleConf    `json:"open_ai_compatible"` // OpenAI 兼容接口, 按 Name 区分
+	Http                   HttpConf                  `json:"http"`               // 全局 HTTP 请求配置
+	ProviderHttp           map[string]HttpConf       `json:"provider_http"`      // 按服务商名称单独设置的 HTTP 请求配置, 未设置的项沿用全局配置
+	Credentials            map[string]CredentialPool `json:"credentials"`        // 按服务商名称配置的凭证池, 配置后忽略该服务商的单组凭证
+	Limits                 map[string]LimitConf      `json:"limits"`             // 按服务商名称配置的客户端限流, 凭证池中每组凭证可单独配置
+	Retry                  RetryConf                 `json:"retry"`              // 全局重试策略, 默认不重试
+	ProviderRetry          map[string]RetryConf      `json:"provider_retry"`     // 按服务商名称单独设置的重试策略
+	Breakers               map[string]BreakerConf    `json:"breakers"`           // 按服务商名称配置的熔断, 未配置的服务商不熔断
+	Prices                 *PriceTable               `json:"-"`                  // 价格表, 配置后按 token 消耗预估每次请求的费用
+	Cache                  CacheConf                 `json:"cache"`              // 响应缓存, 默认不缓存
+	Middlewares            []Middleware              `json:"-"`                  // 中间件, 作用于当前实例创建的所有服务
+	Logger                 *slog.Logger              `json:"-"`                  // 日志, 配置后记录每次请求的开始及结束
+	TracerProvider         trace.TracerProvider      `json:"-"`                  // OpenTelemetry 链路, 未配置时不记录
+	MeterProvider          metric.MeterProvider      `json:"-"`                  // OpenTelemetry 指标, 未配置时不记录
 }
 
 type RequestData struct {
@@ -45,6 +72,7 @@ type RequestData struct {
 	UserQuery         string      `json:"user_query"`                    // 用户提示词
 	SystemQuery       string      `json:"system_query,omitempty"`        // 系统提示词
 	History           [][2]string `json:"history,omitempty"`             // 历史对话
+	Messages          []Message   `json:"messages,omitempty"`            // 完整消息列表, 位于 History 之后、UserQuery 之前
 	MaxTokens         int64       `json:"max_tokens,omitempty"`          // 聊天完成时生成的最大 token 数
 	Temperature       float64     `json:"temperature,omitempty"`         // 使用什么采样温度
 	TopP              float64     `json:"top_p,omitempty"`               // 另一种采样方法
@@ -54,41 +82,119 @@ type RequestData struct {
 	ResponseFormat    string      `json:"response_format,omitempty"`     // 响应格式【text 、 json_object】
 	Stop              []string    `json:"stop,omitempty"`                // 停止词
 	MaskSensitiveInfo bool        `json:"mask_sensitive_info,omitempty"` // 对输出中易涉及隐私问题的文本信息进行打码
+	Tools             []Tool      `json:"tools,omitempty"`               // 可供模型调用的工具
+	ToolChoice        string      `json:"tool_choice,omitempty"`         // 工具选择策略【auto 、 none 、 required】, 其他值视为强制调用的函数名
+}
+
+// messages 展开为完整消息列表, 依次为 SystemQuery、History、Messages、UserQuery
+func (r RequestData) messages() []Message {
+	messages := make([]Message, 0, len(r.History)*2+len(r.Messages)+2)
+
+	if r.SystemQuery != "" {
+		messages = append(messages, Message{Role: MessageSystem, Content: r.SystemQuery})
+	}
+	for _, detail := range r.History {
+		messages = append(messages, Message{Role: MessageUSer, Content: detail[0]})
+		messages = append(messages, Message{Role: MessageAssistant, Content: detail[1]})
+	}
+	messages = append(messages, r.Messages...)
+	if r.UserQuery != "" {
+		messages = append(messages, Message{Role: MessageUSer, Content: r.UserQuery})
+	}
+
+	return messages
+}
+
+// functionTools 转换为 OpenAI 风格的工具定义
+func (r RequestData) functionTools() []FunctionTool {
+	if len(r.Tools) == 0 {
+		return nil
+	}
+
+	tools := make([]FunctionTool, 0, len(r.Tools))
+	for _, tool := range r.Tools {
+		tools = append(tools, FunctionTool{Type: "function", Function: tool})
+	}
+
+	return tools
+}
+
+// toolChoice 转换为 OpenAI 风格的 tool_choice 参数
+func (r RequestData) toolChoice() interface{} {
+	switch r.ToolChoice {
+	case "":
+		return nil
+	case "auto", "none", "required":
+		return r.ToolChoice
+	default:
+		return map[string]interface{}{"type": "function", "function": map[string]string{"name": r.ToolChoice}}
+	}
 }
 
 type Response struct {
-	RequestHeader    []byte   `json:"request_header"`    // 请求header头部信息
-	RequestBody      []byte   `json:"request_body"`      // 请求body体信息
-	ResponseData     [][]byte `json:"response_data"`     // 响应原始数据
-	PromptTokens     int64    `json:"prompt_tokens"`     // 输入提示词token
-	CompletionTokens int64    `json:"completion_tokens"` // 响应token
-	ResponseText     string   `json:"response_text"`     // 整理后的响应结果
-	SpendTime        int64    `json:"spend_time"`        // 请求耗时
-	RequestId        string   `json:"request_id"`        // 请求唯一ID
+	RequestHeader    []byte                 `json:"request_header"`          // 请求header头部信息, 其中的凭证已脱敏
+	RequestBody      []byte                 `json:"request_body"`            // 请求body体信息
+	ResponseData     [][]byte               `json:"response_data"`           // 响应原始数据
+	PromptTokens     int64                  `json:"prompt_tokens"`           // 输入提示词token
+	CompletionTokens int64                  `json:"completion_tokens"`       // 响应token
+	Usage            Usage                  `json:"usage"`                   // token 消耗明细, PromptTokens、CompletionTokens 与其一致
+	Cost             *Cost                  `json:"cost,omitempty"`          // 按价格表预估的费用, 未配置价格时为 nil
+	ResponseText     string                 `json:"response_text"`           // 整理后的响应结果
+	SpendTime        int64                  `json:"spend_time"`              // 请求耗时(毫秒), 失败时同样记录
+	StreamStats      *StreamStats           `json:"stream_stats,omitempty"`  // 流式请求的首个 token 耗时、内容间隔及输出速度, 阻塞式请求时为 nil
+	RequestId        string                 `json:"request_id"`              // 请求唯一ID
+	ToolCalls        []ToolCall             `json:"tool_calls,omitempty"`    // 模型发起的工具调用
+	FinishReason     FinishReason           `json:"finish_reason,omitempty"` // 结束原因
+	Metadata         map[string]interface{} `json:"metadata,omitempty"`      // 服务商特有的响应信息, 如原始结束原因、百度 is_truncated 等
+	Supplier         string                 `json:"supplier"`                // 实际提供服务的服务商
+	KeyAlias         string                 `json:"key_alias,omitempty"`     // 配置凭证池时, 处理本次请求的凭证别名
+	CacheHit         bool                   `json:"cache_hit,omitempty"`     // 是否命中响应缓存, 命中时不产生费用, Cost 为 nil
 }
 
-type Ability interface {
-	build(data RequestData, isStream bool) ([]byte, error)
-	Chat(requestPath string, data []byte) (*Response, error)
-	ChatStream(requestPath string, data []byte, msgCh chan string, errChan chan error) (*Response, error)
-	Supplier() string
-	RequestPath() string
+// Provider 服务商实现, 第三方实现后通过 Register 注册即可使用
+type Provider interface {
+	Build(data RequestData, isStream bool) ([]byte, error)                                                     // 组装请求body参数
+	Chat(ctx context.Context, requestPath string, data []byte) (*Response, error)                              // 阻塞式请求
+	ChatStream(ctx context.Context, requestPath string, data []byte, handler StreamHandler) (*Response, error) // 流式请求, 每段增量文本回调一次 handler
+	Supplier() string                                                                                          // 服务商标识
+	RequestPath() string                                                                                       // 请求地址
 }
 
+// Ability 兼容旧名称, 等同于 Provider
+type Ability = Provider
+
+// Init 初始化默认实例, 重复调用时以最后一次的配置替换默认实例, 已实例化的 Server 不受影响
 func Init(conf *Config, options ...Options) {
 	for _, option := range options {
 		option.Apply(conf)
 	}
 
-	once.Do(func() {
-		config = *conf
-		hasInit = true
-	})
+	client := NewClient(conf)
+
+	defaultLock.Lock()
+	defaultClient = client
+	defaultLock.Unlock()
+}
+
+// Default 默认实例, 未调用 Init 时返回 nil
+func Default() *Client {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+
+	return defaultClient
 }
 
 type Server struct {
-	client      Ability
-	ImplementId int8 `json:"implement_id"`
+	client      Provider
+	ImplementId int8   `json:"implement_id"`
+	Name        string `json:"name"` // 注册名称
+	retry       RetryConf
+	pool        *credentialPool
+	limit       *limiter
+	breaker     *breaker
+	prices      *PriceTable
+	cache       *responseCache
+	middlewares []Middleware
 }
 
 const (
@@ -105,6 +211,8 @@ const (
 	ImplementChatGpt   int8 = 11 // chatGpt
 	ImplementGemini    int8 = 12 // gemini
 	ImplementDeepSeek  int8 = 13 // deepSeek
+
+	ImplementOpenAICompatible int8 = 14 // OpenAI 兼容接口, 通过 NewOpenAICompatibleServer 按名称实例化
 )
 
 var (
@@ -113,144 +221,336 @@ var (
 	ErrorNoImplement = errors.New("未定义实现")
 )
 
+// NewServer 使用默认实例按实现ID实例化服务
 func NewServer(implementId int8) (*Server, error) {
-	if !hasInit {
+	client := Default()
+	if client == nil {
 		return nil, ErrorNoInit
 	}
 
-	var client Ability
+	return client.NewServer(implementId)
+}
 
-	switch implementId {
-	case ImplementMoonshot:
-		if len(config.MoonshotKey) == 0 || len(config.MoonshotUrl) == 0 {
-			return nil, ErrorNoConfig
-		}
+// NewServerByName 使用默认实例按注册名称实例化服务
+func NewServerByName(name string) (*Server, error) {
+	client := Default()
+	if client == nil {
+		return nil, ErrorNoInit
+	}
+
+	return client.NewServerByName(name)
+}
 
-		client = newMoonshotServer(config.MoonshotUrl, config.MoonshotKey)
+// NewOpenAICompatibleServer 使用默认实例按名称实例化 OpenAI 兼容服务
+func NewOpenAICompatibleServer(name string) (*Server, error) {
+	client := Default()
+	if client == nil {
+		return nil, ErrorNoInit
+	}
 
-	case ImplementMinimaxi:
-		if len(config.MinimaxiUrl) == 0 || len(config.MinimaxiKey) == 0 {
-			return nil, ErrorNoConfig
-		}
+	return client.NewOpenAICompatibleServer(name)
+}
 
-		client = newMinimaxiServer(config.MinimaxiUrl, config.MinimaxiKey)
+// Chat 阻塞式对话
+func (s *Server) Chat(data RequestData) (*Response, error) {
+	return s.ChatContext(context.Background(), data)
+}
 
-	case ImplementVolc:
-		if len(config.VolcUrl) == 0 || len(config.VolcKey) == 0 {
-			return nil, ErrorNoConfig
+// ChatContext 阻塞式对话, ctx 取消或超时后中断请求
+func (s *Server) ChatContext(ctx context.Context, data RequestData) (*Response, error) {
+	return timer(func() (*Response, error) {
+		if err := ctx.Err(); err != nil {
+			return &Response{}, err
 		}
 
-		client = newVolcServer(config.VolcUrl, config.VolcKey)
-
-	case ImplementBaidu:
-		if len(config.BaiDuUrl) == 0 || len(config.BaiDuClientId) == 0 || len(config.BaiDuClientSecret) == 0 {
-			return nil, ErrorNoConfig
+		call, err := s.newCall(data, false)
+		if err != nil {
+			return &Response{}, err
 		}
 
-		client = newBaiDuServer(config.BaiDuUrl, config.BaiDuClientId, config.BaiDuClientSecret)
+		return s.invoke(ctx, call)
+	})
+}
+
+// ChatStream 流式对话
+func (s *Server) ChatStream(data RequestData, msgCh chan string, errChan chan error) (*Response, error) {
+	return s.ChatStreamContext(context.Background(), data, msgCh, errChan)
+}
 
-	case ImplementQwen:
-		if len(config.QwenUrl) == 0 || len(config.QwenKey) == 0 {
-			return nil, ErrorNoConfig
+// ChatStreamContext 流式对话, ctx 取消或超时后中断读取并关闭响应体, 取消原因会推送到 errChan
+// 请求结束后 msgCh 总会被关闭, 出错时先推送错误再关闭 msgCh; 推荐使用 Stream 方法
+func (s *Server) ChatStreamContext(ctx context.Context, data RequestData, msgCh chan string, errChan chan error) (*Response, error) {
+	return timer(func() (*Response, error) {
+		streamTimer := newStreamTimer()
+		call, err := s.newCall(data, true)
+		if err != nil {
+			sendErr(ctx, errChan, err)
+			close(msgCh)
+			return &Response{StreamStats: streamTimer.stats(0)}, err
 		}
 
-		client = newQwenServer(config.QwenUrl, config.QwenKey)
+		return s.streamChannel(ctx, call, msgCh, errChan)
+	})
+}
 
-	case ImplementHunyuan:
-		if len(config.HunyuanUrl) == 0 || len(config.HunyuanClientId) == 0 || len(config.HunyuanClientSecret) == 0 {
-			return nil, ErrorNoConfig
-		}
+// Stream 流式对话, 返回流式响应读取器, 读取结束或中途放弃时需调用 Close
+func (s *Server) Stream(ctx context.Context, data RequestData) (*Stream, error) {
+	call, err := s.newCall(data, true)
+	if err != nil {
+		return nil, err
+	}
 
-		client = newHunyuanServer(config.HunyuanUrl, config.HunyuanClientId, config.HunyuanClientSecret)
+	return s.streamReader(ctx, call), nil
+}
 
-	case ImplementGlm:
-		if len(config.GlmUrl) == 0 || len(config.GlmKey) == 0 {
-			return nil, ErrorNoConfig
-		}
+// CustomizeChat 自定义参数阻塞式对话, 用户自己实现请求的body参数
+func (s *Server) CustomizeChat(payload []byte) (*Response, error) {
+	return s.CustomizeChatContext(context.Background(), payload)
+}
 
-		client = newQwenServer(config.GlmUrl, config.GlmKey)
+// CustomizeChatContext 自定义参数阻塞式对话, ctx 取消或超时后中断请求
+func (s *Server) CustomizeChatContext(ctx context.Context, payload []byte) (*Response, error) {
+	return timer(func() (*Response, error) {
+		return s.invoke(ctx, &Call{Name: s.Name, Payload: payload})
+	})
+}
 
-	case ImplementXfYun:
-		if len(config.XfYunUrl) == 0 || len(config.XfYunKey) == 0 {
-			return nil, ErrorNoConfig
-		}
+// CustomizeChatStream 自定义参数流式对话, 用户自己实现请求的body参数
+func (s *Server) CustomizeChatStream(payload []byte, msgCh chan string, errChan chan error) (*Response, error) {
+	return s.CustomizeChatStreamContext(context.Background(), payload, msgCh, errChan)
+}
+
+// CustomizeChatStreamContext 自定义参数流式对话, ctx 取消或超时后中断读取
+func (s *Server) CustomizeChatStreamContext(ctx context.Context, payload []byte, msgCh chan string, errChan chan error) (*Response, error) {
+	return s.streamChannel(ctx, &Call{Name: s.Name, Stream: true, Payload: payload}, msgCh, errChan)
+}
+
+// CustomizeStream 自定义参数流式对话, 返回流式响应读取器
+func (s *Server) CustomizeStream(ctx context.Context, payload []byte) *Stream {
+	return s.streamReader(ctx, &Call{Name: s.Name, Stream: true, Payload: payload})
+}
+
+// streamChannel 流式请求, 增量内容推送到 msgCh
+func (s *Server) streamChannel(ctx context.Context, call *Call, msgCh chan string, errChan chan error) (*Response, error) {
+	call.Handler = func(msg string) error {
+		return sendMsg(ctx, msgCh, msg)
+	}
 
-		client = newXfYunServer(config.XfYunUrl, config.XfYunKey)
+	response, err := s.stream(ctx, call)
+	if err != nil {
+		sendErr(ctx, errChan, err)
+	}
+	close(msgCh)
+
+	return response, err
+}
 
-	case ImplementBaiChuan:
-		if len(config.BaiChuanUrl) == 0 || len(config.BaiChuanKey) == 0 {
-			return nil, ErrorNoConfig
+// streamReader 流式请求, 返回流式响应读取器
+func (s *Server) streamReader(ctx context.Context, call *Call) *Stream {
+	return newStream(ctx, func(ctx context.Context, handler StreamHandler) (*Response, error) {
+		call.Handler = handler
+		return s.stream(ctx, call)
+	})
+}
+
+func (s *Server) chatStream(ctx context.Context, payload []byte, handler StreamHandler) (*Response, error) {
+	return timer(func() (*Response, error) {
+		if err := ctx.Err(); err != nil {
+			return &Response{StreamStats: newStreamTimer().stats(0)}, err
 		}
 
-		client = newBaiChuanServer(config.BaiChuanUrl, config.BaiChuanKey)
+		key := ""
+		if s.cache != nil {
+			key = s.cache.key(s.Name, payload)
+			if response, chunks, ok := s.cache.get(ctx, key); ok {
+				streamTimer := newStreamTimer()
+				err := replay(chunks, func(msg string) error {
+					streamTimer.chunk()
+					return handler(msg)
+				})
+				response = s.supplied(response)
+				response.StreamStats = streamTimer.stats(response.Usage.CompletionTokens)
+				return response, err
+			}
+		}
 
-	case ImplementSensenova:
-		if len(config.SensenovaUrl) == 0 || len(config.SensenovaClientId) == 0 || len(config.SensenovaClientSecret) == 0 {
-			return nil, ErrorNoConfig
+		// 已推送数据后不再重试, 避免调用方收到重复内容
+		emitted := false
+		chunks := make([]string, 0)
+		streamTimer := newStreamTimer()
+		response, err := s.retry.do(ctx, func() (*Response, error) {
+			return s.call(ctx, payload, func(client Provider) (*Response, error) {
+				return client.ChatStream(ctx, client.RequestPath(), payload, func(msg string) error {
+					if len(msg) > 0 {
+						emitted = true
+						chunks = append(chunks, msg)
+						streamTimer.chunk()
+					}
+					return handler(msg)
+				})
+			})
+		}, func() bool {
+			return !emitted
+		})
+		if err == nil && s.cache != nil {
+			s.cache.set(ctx, key, response, chunks)
 		}
 
-		client = newSensenovaServer(config.SensenovaUrl, config.SensenovaClientId, config.SensenovaClientSecret)
+		response = s.priced(s.supplied(response), payload)
+		response.StreamStats = streamTimer.stats(response.Usage.CompletionTokens)
+
+		return response, err
+	})
+}
 
-	case ImplementDeepSeek:
-		if len(config.DeepSeekUrl) == 0 || len(config.DeepSeekKey) == 0 {
-			return nil, ErrorNoConfig
+func (s *Server) chat(ctx context.Context, payload []byte) (*Response, error) {
+	key := ""
+	if s.cache != nil {
+		key = s.cache.key(s.Name, payload)
+		if response, _, ok := s.cache.get(ctx, key); ok {
+			return s.supplied(response), nil
 		}
+	}
 
-		client = newDeepSeekServer(config.DeepSeekUrl, config.DeepSeekKey)
+	response, err := s.retry.do(ctx, func() (*Response, error) {
+		return s.call(ctx, payload, func(client Provider) (*Response, error) {
+			return client.Chat(ctx, client.RequestPath(), payload)
+		})
+	}, nil)
+	if err == nil && s.cache != nil {
+		s.cache.set(ctx, key, response, nil)
+	}
 
-	default:
-		return nil, ErrorNoImplement
+	return s.priced(s.supplied(response), payload), err
+}
+
+// call 熔断时直接返回 ErrorCircuitOpen, 否则发起请求并记录结果
+func (s *Server) call(ctx context.Context, payload []byte, fun func(client Provider) (*Response, error)) (*Response, error) {
+	probe, err := s.breaker.allow()
+	if err != nil {
+		return &Response{}, err
 	}
 
-	return &Server{client: client, ImplementId: implementId}, nil
+	response, err := s.dispatch(ctx, payload, fun)
+	s.breaker.record(probe, err, ctx.Err() == nil && !errors.Is(err, ErrorRateLimited))
+
+	return response, err
 }
 
-// Chat 阻塞式对话
-func (s *Server) Chat(data RequestData) (*Response, error) {
-	return timer(func() (*Response, error) {
-		payload, err := s.client.build(data, false)
+// dispatch 按限流配置获取许可后, 使用凭证池中选出的凭证发起请求, 未配置凭证池时使用默认凭证
+func (s *Server) dispatch(ctx context.Context, payload []byte, fun func(client Provider) (*Response, error)) (*Response, error) {
+	estimate := int64(0)
+	if s.limit != nil || s.pool != nil {
+		estimate = estimateTokens(payload)
+	}
+
+	if s.pool == nil {
+		release, err := s.limit.acquire(ctx, estimate)
 		if err != nil {
 			return &Response{}, err
 		}
+		response, err := fun(s.client)
+		release(response)
+		return response, err
+	}
 
-		return s.client.Chat(s.client.RequestPath(), payload)
-	})
+	// 先选出凭证再获取服务商整体的许可, 等待凭证限流期间不占用服务商的并发数
+	entry, releaseEntry, err := s.selectEntry(ctx, estimate)
+	if err != nil {
+		return &Response{KeyAlias: entry.alias}, err
+	}
+	release, err := s.limit.acquire(ctx, estimate)
+	if err != nil {
+		releaseEntry(nil)
+		s.pool.release(entry, nil)
+		return &Response{KeyAlias: entry.alias}, err
+	}
+
+	response, err := fun(entry.provider)
+	releaseEntry(response)
+	release(response)
+	s.pool.release(entry, err)
+
+	// 凭证相关的错误在还有其他可用凭证时可换一组凭证重试
+	var apiErr *APIError
+	if credentialError(err) && s.pool.available() && errors.As(err, &apiErr) {
+		apiErr.retryable = true
+	}
+
+	if response == nil {
+		response = &Response{}
+	}
+	response.KeyAlias = entry.alias
+
+	return response, err
 }
 
-// ChatStream 流式对话
-func (s *Server) ChatStream(data RequestData, msgCh chan string, errChan chan error) (*Response, error) {
-	return timer(func() (*Response, error) {
-		payload, err := s.client.build(data, true)
-		if err != nil {
-			return &Response{}, err
+// selectEntry 按选择策略依次尝试各组凭证, 跳过已达到自身限流的凭证; 均已达到限流时按选择策略等待或直接返回
+func (s *Server) selectEntry(ctx context.Context, estimate int64) (*credentialEntry, func(response *Response), error) {
+	skip := make(map[*credentialEntry]bool)
+	for {
+		entry := s.pool.acquire(skip)
+		if entry == nil {
+			break
 		}
+		if release, ok := entry.limit.try(estimate); ok {
+			return entry, release, nil
+		}
+		s.pool.release(entry, nil)
+		skip[entry] = true
+	}
 
-		return s.client.ChatStream(s.client.RequestPath(), payload, msgCh, errChan)
-	})
+	entry := s.pool.acquire(nil)
+	release, err := entry.limit.acquire(ctx, estimate)
+	if err != nil {
+		s.pool.release(entry, nil)
+		return entry, nil, err
+	}
+
+	return entry, release, nil
 }
 
-// CustomizeChat 自定义参数阻塞式对话, 用户自己实现请求的body参数
-func (s *Server) CustomizeChat(payload []byte) (*Response, error) {
-	return timer(func() (*Response, error) {
-		return s.client.Chat(s.client.RequestPath(), payload)
-	})
+// supplied 记录实际提供服务的服务商
+func (s *Server) supplied(response *Response) *Response {
+	if response == nil {
+		response = &Response{}
+	}
+	response.Supplier = s.client.Supplier()
+
+	return response
 }
 
-// CustomizeChatStream 自定义参数流式对话, 用户自己实现请求的body参数
-func (s *Server) CustomizeChatStream(payload []byte, msgCh chan string, errChan chan error) (*Response, error) {
-	return timer(func() (*Response, error) {
-		return s.client.ChatStream(s.client.RequestPath(), payload, msgCh, errChan)
-	})
+// priced 按价格表预估费用, 模型取自请求体的 model 字段
+func (s *Server) priced(response *Response, payload []byte) *Response {
+	if s.prices == nil {
+		return response
+	}
+
+	if cost, ok := s.prices.Estimate(s.Name, payloadModel(payload), response.Usage); ok {
+		response.Cost = &cost
+	}
+
+	return response
+}
+
+// payloadModel 请求体中的 model 字段
+func payloadModel(payload []byte) string {
+	request := struct {
+		Model string `json:"model"`
+	}{}
+	_ = json.Unmarshal(payload, &request)
+
+	return request.Model
 }
 
 func timer(fun func() (*Response, error)) (*Response, error) {
 	start := time.Now()
 
 	response, err := fun()
-
-	if errors.Is(err, nil) {
-		response.SpendTime = time.Now().Sub(start).Milliseconds()
+	if response == nil {
+		response = &Response{}
 	}
+	response.SpendTime = time.Since(start).Milliseconds()
 
 	return response, err
 }
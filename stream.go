@@ -0,0 +1,90 @@
+package pkg_ai
+
+import (
+	"context"
+	"sync"
+)
+
+// StreamHandler 流式数据回调, 每收到一段增量文本调用一次, 返回错误时中断读取
+type StreamHandler func(msg string) error
+
+// Stream 流式响应读取器
+//
+//	stream, err := server.Stream(ctx, data)
+//	if err != nil {
+//		return err
+//	}
+//	defer stream.Close()
+//
+//	for stream.Next() {
+//		fmt.Print(stream.Current())
+//	}
+//	if err := stream.Err(); err != nil {
+//		return err
+//	}
+//	res := stream.Response()
+type Stream struct {
+	cancel   context.CancelFunc
+	msgCh    chan string
+	current  string
+	err      error
+	response *Response
+	once     sync.Once
+}
+
+func newStream(ctx context.Context, fun func(ctx context.Context, handler StreamHandler) (*Response, error)) *Stream {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Stream{cancel: cancel, msgCh: make(chan string)}
+
+	go func() {
+		response, err := fun(ctx, func(msg string) error {
+			return sendMsg(ctx, s.msgCh, msg)
+		})
+		if response == nil {
+			response = &Response{}
+		}
+
+		s.response, s.err = response, err
+		close(s.msgCh)
+		cancel()
+	}()
+
+	return s
+}
+
+// Next 读取下一段增量文本, 流结束、出错或被关闭时返回 false
+func (s *Stream) Next() bool {
+	msg, ok := <-s.msgCh
+	if !ok {
+		return false
+	}
+
+	s.current = msg
+	return true
+}
+
+// Current 当前增量文本
+func (s *Stream) Current() string {
+	return s.current
+}
+
+// Err 流读取过程中的错误, 需在 Next 返回 false 之后调用
+func (s *Stream) Err() error {
+	return s.err
+}
+
+// Response 完整响应数据(含token消耗), 需在 Next 返回 false 之后调用
+func (s *Stream) Response() *Response {
+	return s.response
+}
+
+// Close 中断请求并释放资源, 可重复调用
+func (s *Stream) Close() error {
+	s.once.Do(func() {
+		s.cancel()
+		for range s.msgCh {
+		}
+	})
+
+	return nil
+}
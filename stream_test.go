@@ -0,0 +1,302 @@
+package pkg_ai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const deepSeekStreamReply = "data: {\"id\":\"ds-1\",\"choices\":[{\"delta\":{\"content\":\"你\"}}]}\n\n" +
+	"data: {\"id\":\"ds-1\",\"choices\":[{\"delta\":{\"content\":\"好\"}}]}\n\n" +
+	"data: {\"id\":\"ds-1\",\"choices\":[{\"delta\":{\"content\":\"!\"},\"finish_reason\":\"stop\"}],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":3,\"total_tokens\":6}}\n\n" +
+	"data: [DONE]\n\n"
+
+func newStreamServer(t *testing.T, reply func(r *http.Request) (int, string)) *Server {
+	t.Helper()
+
+	upstream := newDeepSeekUpstream(t, reply)
+	server, err := NewClient(&Config{}, WithDeepSeekConfig(upstream.URL, "sk")).NewServer(ImplementDeepSeek)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return server
+}
+
+func TestStreamRead(t *testing.T) {
+	server := newStreamServer(t, func(r *http.Request) (int, string) {
+		return http.StatusOK, deepSeekStreamReply
+	})
+
+	stream, err := server.Stream(context.Background(), RequestData{Model: "deepseek-chat", UserQuery: "你好"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	chunks := make([]string, 0)
+	for stream.Next() {
+		chunks = append(chunks, stream.Current())
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(chunks, "|"); got != "你|好|!" {
+		t.Errorf("chunks = %s", got)
+	}
+	response := stream.Response()
+	if response.ResponseText != "你好!" || response.FinishReason != FinishStop || response.Usage.TotalTokens != 6 || response.RequestId != "ds-1" {
+		t.Errorf("response = %+v", response)
+	}
+	if stream.Next() {
+		t.Error("Next after end = true")
+	}
+}
+
+func TestStreamError(t *testing.T) {
+	server := newStreamServer(t, func(r *http.Request) (int, string) {
+		return http.StatusInternalServerError, `{"error":{"message":"internal error","type":"server_error"}}`
+	})
+
+	stream, err := server.Stream(context.Background(), RequestData{Model: "deepseek-chat", UserQuery: "你好"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	if stream.Next() {
+		t.Errorf("Next = true, current = %q", stream.Current())
+	}
+	apiErr := &APIError{}
+	if !errors.As(stream.Err(), &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("err = %v", stream.Err())
+	}
+	if stream.Response() == nil {
+		t.Error("response = nil")
+	}
+}
+
+func TestStreamBuildError(t *testing.T) {
+	server := newStreamServer(t, func(r *http.Request) (int, string) {
+		return http.StatusOK, deepSeekStreamReply
+	})
+
+	if stream, err := server.Stream(context.Background(), RequestData{Model: "deepseek-chat"}); err == nil || stream != nil {
+		t.Errorf("stream = %v, err = %v", stream, err)
+	}
+}
+
+func TestStreamCloseCancelsRequest(t *testing.T) {
+	canceled := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "data: {\"id\":\"ds-1\",\"choices\":[{\"delta\":{\"content\":\"你\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		close(canceled)
+	}))
+	defer upstream.Close()
+
+	server, err := NewClient(&Config{}, WithDeepSeekConfig(upstream.URL, "sk")).NewServer(ImplementDeepSeek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := server.Stream(context.Background(), RequestData{Model: "deepseek-chat", UserQuery: "你好"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !stream.Next() || stream.Current() != "你" {
+		t.Fatalf("first chunk = %q, err = %v", stream.Current(), stream.Err())
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		_ = stream.Close()
+		_ = stream.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked")
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("upstream request not canceled")
+	}
+
+	// Close 等待生产者退出, 之后结果可读
+	if stream.Next() {
+		t.Error("Next after Close = true")
+	}
+	if !errors.Is(stream.Err(), context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", stream.Err())
+	}
+}
+
+// collectChannel 读取 ChatStreamContext 推送的内容, msgCh 未在超时前关闭时测试失败
+func collectChannel(t *testing.T, fun func(msgCh chan string, errChan chan error) (*Response, error)) ([]string, []error, *Response, error) {
+	t.Helper()
+
+	msgCh, errChan := make(chan string), make(chan error, 1)
+	type result struct {
+		response *Response
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		response, err := fun(msgCh, errChan)
+		done <- result{response, err}
+	}()
+
+	chunks := make([]string, 0)
+	timeout := time.After(2 * time.Second)
+	for open := true; open; {
+		select {
+		case msg, ok := <-msgCh:
+			if ok {
+				chunks = append(chunks, msg)
+			}
+			open = ok
+		case <-timeout:
+			t.Fatal("msgCh not closed")
+		}
+	}
+
+	ret := <-done
+	errs := make([]error, 0)
+	for len(errChan) > 0 {
+		errs = append(errs, <-errChan)
+	}
+
+	return chunks, errs, ret.response, ret.err
+}
+
+func TestChatStreamContextClosesChannel(t *testing.T) {
+	server := newStreamServer(t, func(r *http.Request) (int, string) {
+		if strings.Contains(r.Header.Get("Authorization"), "bad") {
+			return http.StatusUnauthorized, `{"error":{"message":"invalid api key","type":"authentication_error"}}`
+		}
+		return http.StatusOK, deepSeekStreamReply
+	})
+	data := RequestData{Model: "deepseek-chat", UserQuery: "你好"}
+
+	t.Run("success", func(t *testing.T) {
+		chunks, errs, response, err := collectChannel(t, func(msgCh chan string, errChan chan error) (*Response, error) {
+			return server.ChatStreamContext(context.Background(), data, msgCh, errChan)
+		})
+		if err != nil || len(errs) != 0 {
+			t.Fatalf("err = %v, errs = %v", err, errs)
+		}
+		if strings.Join(chunks, "") != "你好!" || response.ResponseText != "你好!" {
+			t.Errorf("chunks = %q, response = %q", chunks, response.ResponseText)
+		}
+	})
+
+	t.Run("build error", func(t *testing.T) {
+		_, errs, response, err := collectChannel(t, func(msgCh chan string, errChan chan error) (*Response, error) {
+			return server.ChatStreamContext(context.Background(), RequestData{Model: "deepseek-chat"}, msgCh, errChan)
+		})
+		if err == nil || len(errs) != 1 || errs[0] != err {
+			t.Errorf("err = %v, errs = %v", err, errs)
+		}
+		// 请求参数有误时同样记录耗时及流式统计
+		if response == nil || response.StreamStats == nil || response.StreamStats.Chunks != 0 {
+			t.Errorf("response = %+v", response)
+		}
+	})
+
+	t.Run("upstream error", func(t *testing.T) {
+		bad, err := NewClient(&Config{}, WithDeepSeekConfig(server.client.RequestPath(), "bad")).NewServer(ImplementDeepSeek)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, errs, _, err := collectChannel(t, func(msgCh chan string, errChan chan error) (*Response, error) {
+			return bad.ChatStreamContext(context.Background(), data, msgCh, errChan)
+		})
+		if !errors.Is(err, ErrorAuth) || len(errs) != 1 {
+			t.Errorf("err = %v, errs = %v", err, errs)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, _, response, err := collectChannel(t, func(msgCh chan string, errChan chan error) (*Response, error) {
+			return server.ChatStreamContext(ctx, data, msgCh, errChan)
+		})
+		if !errors.Is(err, context.Canceled) || response.StreamStats == nil {
+			t.Errorf("err = %v, response = %+v", err, response)
+		}
+	})
+}
+
+func TestStreamTimerStats(t *testing.T) {
+	start := time.Now().Add(-time.Second)
+	streamTimer := &streamTimer{
+		start:  start,
+		first:  start.Add(100 * time.Millisecond),
+		chunks: 6,
+		intervals: []time.Duration{
+			40 * time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond, 30 * time.Millisecond, 20 * time.Millisecond,
+		},
+	}
+
+	stats := streamTimer.stats(90)
+	if stats.FirstTokenTime != 100 || stats.Chunks != 6 || stats.Duration < 1000 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if stats.IntervalAvg != 40 || stats.IntervalP50 != 30 || stats.IntervalP95 != 100 || stats.IntervalMax != 100 {
+		t.Errorf("intervals = %+v", stats)
+	}
+	// 90 个 token 在首个 token 之后约 0.9 秒内输出
+	if stats.TokensPerSecond < 80 || stats.TokensPerSecond > 100 {
+		t.Errorf("tokens per second = %f", stats.TokensPerSecond)
+	}
+
+	empty := newStreamTimer().stats(90)
+	if empty.FirstTokenTime != 0 || empty.Chunks != 0 || empty.TokensPerSecond != 0 || empty.IntervalMax != 0 {
+		t.Errorf("empty stats = %+v", empty)
+	}
+}
+
+func TestStreamStats(t *testing.T) {
+	delay := 30 * time.Millisecond
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, line := range strings.SplitAfter(deepSeekStreamReply, "\n\n") {
+			time.Sleep(delay)
+			_, _ = io.WriteString(w, line)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer upstream.Close()
+
+	server, err := NewClient(&Config{}, WithDeepSeekConfig(upstream.URL, "sk")).NewServer(ImplementDeepSeek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, response, err := collectChannel(t, func(msgCh chan string, errChan chan error) (*Response, error) {
+		return server.ChatStreamContext(context.Background(), RequestData{Model: "deepseek-chat", UserQuery: "你好"}, msgCh, errChan)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := response.StreamStats
+	if stats == nil || stats.Chunks != 3 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if stats.FirstTokenTime < delay.Milliseconds() || stats.Duration < 3*delay.Milliseconds() || response.SpendTime < stats.Duration {
+		t.Errorf("stats = %+v, spend time = %d", stats, response.SpendTime)
+	}
+	if stats.IntervalAvg < float64(delay.Milliseconds()) || stats.IntervalMax < stats.IntervalP50 || stats.TokensPerSecond <= 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
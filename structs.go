@@ -1,15 +1,161 @@
 package pkg_ai
 
+import (
+	"bytes"
+	"encoding/json"
+)
+
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	Name       string     `json:"name,omitempty"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`   // assistant 消息发起的工具调用
+	ToolCallId string     `json:"tool_call_id,omitempty"` // tool 消息对应的工具调用ID
 }
 
 type HunyuanMessage struct {
-	Role    string `json:"Role"`
-	Content string `json:"Content"`
+	Role       string            `json:"Role"`
+	Content    string            `json:"Content"`
+	ToolCalls  []HunyuanToolCall `json:"ToolCalls,omitempty"`
+	ToolCallId string            `json:"ToolCallId,omitempty"`
+}
+
+type HunyuanTool struct {
+	Type     string              `json:"Type"`
+	Function HunyuanToolFunction `json:"Function"`
+}
+
+type HunyuanToolFunction struct {
+	Name        string `json:"Name"`
+	Parameters  string `json:"Parameters"`
+	Description string `json:"Description,omitempty"`
+}
+
+type HunyuanToolCall struct {
+	Id       string                  `json:"Id"`
+	Type     string                  `json:"Type"`
+	Index    int                     `json:"Index,omitempty"`
+	Function HunyuanToolCallFunction `json:"Function"`
+}
+
+type HunyuanToolCallFunction struct {
+	Name      string `json:"Name"`
+	Arguments string `json:"Arguments"`
 }
 
 type StreamOptions struct {
 	IncludeUsage bool `json:"include_usage"`
 }
+
+// Tool 统一的工具(函数)定义
+type Tool struct {
+	Name        string      `json:"name"`                  // 函数名称
+	Description string      `json:"description,omitempty"` // 函数用途描述
+	Parameters  interface{} `json:"parameters,omitempty"`  // 参数的 JSON Schema, 可传 map 或 json.RawMessage
+}
+
+// parametersJSON 以 JSON 字符串形式返回参数定义, 供要求字符串格式的厂商使用
+func (t Tool) parametersJSON() (string, error) {
+	switch parameters := t.Parameters.(type) {
+	case nil:
+		return `{"type":"object","properties":{}}`, nil
+	case string:
+		return parameters, nil
+	case []byte:
+		return string(parameters), nil
+	case json.RawMessage:
+		return string(parameters), nil
+	}
+
+	parameters, err := json.Marshal(t.Parameters)
+	if err != nil {
+		return "", err
+	}
+
+	return string(parameters), nil
+}
+
+// FunctionTool OpenAI 风格的工具定义
+type FunctionTool struct {
+	Type     string `json:"type"`
+	Function Tool   `json:"function"`
+}
+
+// ToolCall 模型发起的工具调用
+type ToolCall struct {
+	Id       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // JSON 格式的调用参数
+}
+
+// ToolCallDelta 流式响应中的工具调用增量, 同一 Index 的参数需要拼接
+type ToolCallDelta struct {
+	Index    int              `json:"index"`
+	Id       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallDeltas 工具调用增量列表, 兼容以单个对象而非数组返回 tool_calls 的厂商
+type ToolCallDeltas []ToolCallDelta
+
+func (t *ToolCallDeltas) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("{")) {
+		delta := ToolCallDelta{}
+		if err := json.Unmarshal(data, &delta); err != nil {
+			return err
+		}
+		*t = ToolCallDeltas{delta}
+		return nil
+	}
+
+	deltas := make([]ToolCallDelta, 0)
+	if err := json.Unmarshal(data, &deltas); err != nil {
+		return err
+	}
+	*t = deltas
+	return nil
+}
+
+// toolCallBuilder 按 Index 拼接流式返回的工具调用
+type toolCallBuilder struct {
+	calls []ToolCall
+	index map[int]int
+}
+
+func (t *toolCallBuilder) add(deltas []ToolCallDelta) {
+	if t.index == nil {
+		t.index = make(map[int]int)
+	}
+
+	for _, delta := range deltas {
+		pos, ok := t.index[delta.Index]
+		if !ok {
+			pos = len(t.calls)
+			t.index[delta.Index] = pos
+			t.calls = append(t.calls, ToolCall{Type: "function"})
+		}
+
+		call := &t.calls[pos]
+		if len(delta.Id) > 0 {
+			call.Id = delta.Id
+		}
+		if len(delta.Type) > 0 {
+			call.Type = delta.Type
+		}
+		if len(call.Function.Name) == 0 {
+			call.Function.Name = delta.Function.Name
+		}
+		call.Function.Arguments += delta.Function.Arguments
+	}
+}
+
+func (t *toolCallBuilder) result() []ToolCall {
+	return t.calls
+}
@@ -0,0 +1,107 @@
+package pkg_ai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestStreamToolCallDeltas(t *testing.T) {
+	// 两个工具调用的参数拆分在多个数据块中, 且交替返回
+	reply := "data: {\"id\":\"ds-1\",\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"call_1\",\"type\":\"function\",\"function\":{\"name\":\"get_weather\",\"arguments\":\"\"}}]}}]}\n\n" +
+		"data: {\"id\":\"ds-1\",\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"{\\\"city\\\":\"}}]}}]}\n\n" +
+		"data: {\"id\":\"ds-1\",\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":1,\"id\":\"call_2\",\"type\":\"function\",\"function\":{\"name\":\"get_time\",\"arguments\":\"{\\\"zone\\\"\"}}]}}]}\n\n" +
+		"data: {\"id\":\"ds-1\",\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"\\\"北京\\\"}\"}}]}}]}\n\n" +
+		"data: {\"id\":\"ds-1\",\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":1,\"function\":{\"arguments\":\":\\\"+8\\\"}\"}}]},\"finish_reason\":\"tool_calls\"}]}\n\n" +
+		"data: [DONE]\n\n"
+	server := newStreamServer(t, func(r *http.Request) (int, string) {
+		return http.StatusOK, reply
+	})
+
+	stream, err := server.Stream(context.Background(), RequestData{
+		Model:     "deepseek-chat",
+		UserQuery: "北京天气和时间",
+		Tools:     []Tool{{Name: "get_weather"}, {Name: "get_time"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	for stream.Next() {
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	response := stream.Response()
+	want := []ToolCall{
+		{Id: "call_1", Type: "function", Function: ToolCallFunction{Name: "get_weather", Arguments: `{"city":"北京"}`}},
+		{Id: "call_2", Type: "function", Function: ToolCallFunction{Name: "get_time", Arguments: `{"zone":"+8"}`}},
+	}
+	if len(response.ToolCalls) != len(want) {
+		t.Fatalf("tool calls = %+v", response.ToolCalls)
+	}
+	for index, call := range want {
+		if response.ToolCalls[index] != call {
+			t.Errorf("tool call %d = %+v, want %+v", index, response.ToolCalls[index], call)
+		}
+	}
+	if response.FinishReason != FinishToolCalls {
+		t.Errorf("finish reason = %q", response.FinishReason)
+	}
+}
+
+func TestToolCallDeltasSingleObject(t *testing.T) {
+	deltas := ToolCallDeltas{}
+	if err := json.Unmarshal([]byte(`{"index":0,"id":"call_1","function":{"name":"f","arguments":"{}"}}`), &deltas); err != nil {
+		t.Fatal(err)
+	}
+
+	builder := &toolCallBuilder{}
+	builder.add(deltas)
+	calls := builder.result()
+	if len(calls) != 1 || calls[0].Id != "call_1" || calls[0].Type != "function" || calls[0].Function.Arguments != "{}" {
+		t.Errorf("tool calls = %+v", calls)
+	}
+}
+
+func TestMinimaxiBuildTools(t *testing.T) {
+	server := newMinimaxiServer("", "")
+	data := RequestData{
+		Model:      "abab6.5s-chat",
+		UserQuery:  "北京天气",
+		Tools:      []Tool{{Name: "get_weather", Parameters: map[string]interface{}{"type": "object"}}, {Name: "get_time"}},
+		ToolChoice: "get_weather",
+	}
+
+	payload, err := server.Build(data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := MinimaxiRequestBody{}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(request.Tools) != 2 {
+		t.Fatalf("tools = %+v", request.Tools)
+	}
+	if request.Tools[0].Function.Parameters != `{"type":"object"}` {
+		t.Errorf("parameters = %s", request.Tools[0].Function.Parameters)
+	}
+	if request.Tools[1].Function.Parameters != `{"type":"object","properties":{}}` {
+		t.Errorf("default parameters = %s", request.Tools[1].Function.Parameters)
+	}
+	// minimaxi 不支持强制调用指定函数, 降级为 auto
+	if request.ToolChoice != "auto" {
+		t.Errorf("tool_choice = %s, want auto", request.ToolChoice)
+	}
+
+	data.ToolChoice = "none"
+	payload, _ = server.Build(data, false)
+	_ = json.Unmarshal(payload, &request)
+	if request.ToolChoice != "none" {
+		t.Errorf("tool_choice = %s, want none", request.ToolChoice)
+	}
+}
@@ -0,0 +1,202 @@
+package pkg_ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+const instrumentationName = "github.com/juxiaoming/pkg_ai"
+
+// genAISystems 服务商对应的 gen_ai.system 取值, 未列出的使用注册名称(小写)
+var genAISystems = map[string]string{
+	"chatgpt": "openai",
+	"gemini":  "gcp.gemini",
+}
+
+// telemetry OpenTelemetry 链路及指标, 未配置时使用 noop 实现
+type telemetry struct {
+	enabled  bool
+	tracer   trace.Tracer
+	requests metric.Int64Counter
+	duration metric.Float64Histogram
+	ttft     metric.Float64Histogram
+	tokens   metric.Int64Histogram
+}
+
+func newTelemetry(tracerProvider trace.TracerProvider, meterProvider metric.MeterProvider) *telemetry {
+	t := &telemetry{enabled: tracerProvider != nil || meterProvider != nil}
+	if tracerProvider == nil {
+		tracerProvider = tracenoop.NewTracerProvider()
+	}
+	if meterProvider == nil {
+		meterProvider = metricnoop.NewMeterProvider()
+	}
+
+	t.tracer = tracerProvider.Tracer(instrumentationName)
+	meter := meterProvider.Meter(instrumentationName)
+
+	// 创建失败时返回的仍是可用的 noop 实现, 忽略错误; 分桶与 GenAI 语义约定的建议值一致
+	seconds := metric.WithExplicitBucketBoundaries(0.01, 0.02, 0.04, 0.08, 0.16, 0.32, 0.64, 1.28, 2.56, 5.12, 10.24, 20.48, 40.96, 81.92)
+	t.requests, _ = meter.Int64Counter("gen_ai.client.requests", metric.WithUnit("{request}"), metric.WithDescription("对话请求次数"))
+	t.duration, _ = meter.Float64Histogram("gen_ai.client.operation.duration", metric.WithUnit("s"), metric.WithDescription("对话请求耗时"), seconds)
+	t.ttft, _ = meter.Float64Histogram("gen_ai.client.time_to_first_token", metric.WithUnit("s"), metric.WithDescription("流式请求首个 token 耗时"), seconds)
+	t.tokens, _ = meter.Int64Histogram("gen_ai.client.token.usage", metric.WithUnit("{token}"), metric.WithDescription("token 消耗"),
+		metric.WithExplicitBucketBoundaries(1, 4, 16, 64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216, 67108864))
+
+	return t
+}
+
+// genAISystem gen_ai.system 属性值
+func genAISystem(name string) string {
+	name = strings.ToLower(name)
+	if system, ok := genAISystems[name]; ok {
+		return system
+	}
+
+	return name
+}
+
+// middleware 为对话请求创建 span 并记录指标, 作为 Server 的内置中间件
+func (t *telemetry) middleware(next ChatFunc) ChatFunc {
+	return func(ctx context.Context, call *Call) (*Response, error) {
+		model := payloadModel(call.Payload)
+		if call.Data != nil {
+			model = call.Data.Model
+		}
+		base := []attribute.KeyValue{
+			attribute.String("gen_ai.operation.name", "chat"),
+			attribute.String("gen_ai.system", genAISystem(call.Name)),
+			attribute.String("gen_ai.request.model", model),
+		}
+
+		ctx, span := t.tracer.Start(ctx, strings.TrimSpace("chat "+model), trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(base...))
+		defer span.End()
+		span.SetAttributes(attribute.Bool("gen_ai.request.stream", call.Stream))
+		if call.Data != nil {
+			if call.Data.MaxTokens > 0 {
+				span.SetAttributes(attribute.Int64("gen_ai.request.max_tokens", call.Data.MaxTokens))
+			}
+			if call.Data.Temperature > 0 {
+				span.SetAttributes(attribute.Float64("gen_ai.request.temperature", call.Data.Temperature))
+			}
+		}
+
+		start := time.Now()
+		if call.Stream && call.Handler != nil {
+			handler, first := call.Handler, true
+			call.Handler = func(msg string) error {
+				if first && len(msg) > 0 {
+					first = false
+					t.ttft.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(base...))
+					span.AddEvent("gen_ai.first_token")
+				}
+				return handler(msg)
+			}
+		}
+
+		response, err := next(ctx, call)
+		if response == nil {
+			response = &Response{}
+		}
+
+		span.SetAttributes(
+			attribute.String("gen_ai.response.id", response.RequestId),
+			attribute.Int64("gen_ai.usage.input_tokens", response.Usage.PromptTokens),
+			attribute.Int64("gen_ai.usage.output_tokens", response.Usage.CompletionTokens),
+		)
+		if len(response.FinishReason) > 0 {
+			span.SetAttributes(attribute.StringSlice("gen_ai.response.finish_reasons", []string{string(response.FinishReason)}))
+		}
+		if response.CacheHit {
+			span.SetAttributes(attribute.Bool("pkg_ai.cache_hit", true))
+		}
+
+		attrs := base
+		if err != nil {
+			errorType := errorType(err)
+			attrs = append(attrs[:len(base):len(base)], attribute.String("error.type", errorType))
+			span.SetAttributes(attribute.String("error.type", errorType))
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		t.requests.Add(ctx, 1, metric.WithAttributes(attrs...))
+		t.duration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attrs...))
+		if !response.Usage.empty() {
+			input := append(base[:len(base):len(base)], attribute.String("gen_ai.token.type", "input"))
+			output := append(base[:len(base):len(base)], attribute.String("gen_ai.token.type", "output"))
+			t.tokens.Record(ctx, response.Usage.PromptTokens, metric.WithAttributes(input...))
+			t.tokens.Record(ctx, response.Usage.CompletionTokens, metric.WithAttributes(output...))
+		}
+
+		return response, err
+	}
+}
+
+// errorType error.type 属性值, 上游错误使用错误分类
+func errorType(err error) string {
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &apiErr):
+		return string(apiErr.Category)
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return string(CategoryTimeout)
+	case errors.Is(err, ErrorCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, ErrorRateLimited):
+		return "rate_limited"
+	default:
+		return "_OTHER"
+	}
+}
+
+// startSpan 创建子 span, 如 HTTP 请求、鉴权 token 获取
+func (t *telemetry) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindInternal), trace.WithAttributes(attrs...))
+}
+
+// endSpan 结束 span, 出错时记录错误
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// httpSpan 为 HTTP 请求创建 span, 地址中的凭证已脱敏
+func (t *telemetry) httpSpan(ctx context.Context, method string, requestUrl string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.String("http.request.method", method),
+		attribute.String("url.full", RedactUrl(requestUrl)),
+	}
+	if parsed, err := url.Parse(requestUrl); err == nil {
+		attrs = append(attrs, attribute.String("server.address", parsed.Hostname()))
+	}
+
+	return t.tracer.Start(ctx, method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// endHttpSpan 记录响应状态码并结束 span
+func endHttpSpan(span trace.Span, response *http.Response, err error) {
+	if response != nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", response.StatusCode))
+		if response.StatusCode >= http.StatusBadRequest && err == nil {
+			span.SetStatus(codes.Error, response.Status)
+		}
+	}
+	endSpan(span, err)
+}
@@ -0,0 +1,288 @@
+package pkg_ai
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// rewriteTransport 将所有请求转发到测试服务, 用于覆盖百度 token 等固定地址
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (r rewriteTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request = request.Clone(request.Context())
+	request.URL.Scheme = r.target.Scheme
+	request.URL.Host = r.target.Host
+	request.Host = r.target.Host
+
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+func newTelemetryUpstream(t *testing.T) (*httptest.Server, Options) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.URL.Path == "/oauth/2.0/token":
+			_, _ = io.WriteString(w, `{"access_token":"baidu-token","expires_in":2592000}`)
+		case r.URL.Path == "/baidu" && strings.Contains(string(body), `"stream":true`):
+			_, _ = io.WriteString(w, "data: {\"id\":\"as-1\",\"result\":\"你\"}\n\n"+
+				"data: {\"id\":\"as-1\",\"result\":\"好\",\"is_end\":true,\"finish_reason\":\"stop\",\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":2,\"total_tokens\":5}}\n\n")
+		case r.URL.Path == "/baidu":
+			_, _ = io.WriteString(w, `{"id":"as-1","result":"你好","finish_reason":"stop","usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`)
+		case r.URL.Path == "/sensenova":
+			_, _ = io.WriteString(w, `{"data":{"id":"ss-1","usage":{"prompt_tokens":4,"completion_tokens":1,"total_tokens":5},"choices":[{"message":"好","finish_reason":"stop"}]}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	target, _ := url.Parse(server.URL)
+	return server, WithTransport(rewriteTransport{target: target})
+}
+
+func newTelemetryClient(t *testing.T) (*Client, *tracetest.InMemoryExporter, *sdkmetric.ManualReader) {
+	t.Helper()
+
+	upstream, transport := newTelemetryUpstream(t)
+	exporter := tracetest.NewInMemoryExporter()
+	reader := sdkmetric.NewManualReader()
+	client := NewClient(&Config{},
+		WithBaiDuConfig(upstream.URL+"/baidu", "client-id", "client-secret"),
+		WithSensenovaConfig(upstream.URL+"/sensenova", "ak", "sk"),
+		transport,
+		WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))),
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+	)
+
+	return client, exporter, reader
+}
+
+func spanNamed(spans tracetest.SpanStubs, name string) (tracetest.SpanStub, bool) {
+	for _, span := range spans {
+		if span.Name == name {
+			return span, true
+		}
+	}
+
+	return tracetest.SpanStub{}, false
+}
+
+func childrenOf(spans tracetest.SpanStubs, parent trace.SpanContext) []string {
+	names := make([]string, 0)
+	for _, span := range spans {
+		if span.Parent.SpanID() == parent.SpanID() {
+			names = append(names, span.Name)
+		}
+	}
+
+	return names
+}
+
+func attributeOf(attrs []attribute.KeyValue, key string) (attribute.Value, bool) {
+	for _, attr := range attrs {
+		if string(attr.Key) == key {
+			return attr.Value, true
+		}
+	}
+
+	return attribute.Value{}, false
+}
+
+func TestTelemetryBaiduSpans(t *testing.T) {
+	client, exporter, _ := newTelemetryClient(t)
+	server, err := client.NewServer(ImplementBaidu)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := server.Chat(RequestData{Model: "ernie-4.0", UserQuery: "你好"}); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	chat, ok := spanNamed(spans, "chat ernie-4.0")
+	if !ok {
+		t.Fatalf("chat span not found: %v", spans)
+	}
+	if chat.SpanKind != trace.SpanKindClient {
+		t.Errorf("chat span kind = %v", chat.SpanKind)
+	}
+
+	children := childrenOf(spans, chat.SpanContext)
+	if strings.Join(children, ",") != "baidubce token,POST" && strings.Join(children, ",") != "POST,baidubce token" {
+		t.Fatalf("chat span children = %v", children)
+	}
+	token, _ := spanNamed(spans, "baidubce token")
+	if names := childrenOf(spans, token.SpanContext); len(names) != 1 || names[0] != "POST" {
+		t.Errorf("token span children = %v", names)
+	}
+
+	for _, span := range spans {
+		if value, ok := attributeOf(span.Attributes, "url.full"); ok && strings.Contains(value.AsString(), "client-secret") {
+			t.Errorf("url.full not redacted: %s", value.AsString())
+		}
+	}
+
+	want := map[string]attribute.Value{
+		"gen_ai.operation.name":          attribute.StringValue("chat"),
+		"gen_ai.system":                  attribute.StringValue("baidubce"),
+		"gen_ai.request.model":           attribute.StringValue("ernie-4.0"),
+		"gen_ai.response.id":             attribute.StringValue("as-1"),
+		"gen_ai.usage.input_tokens":      attribute.Int64Value(3),
+		"gen_ai.usage.output_tokens":     attribute.Int64Value(2),
+		"gen_ai.response.finish_reasons": attribute.StringSliceValue([]string{"stop"}),
+	}
+	for key, expect := range want {
+		value, ok := attributeOf(chat.Attributes, key)
+		if !ok || value.Emit() != expect.Emit() {
+			t.Errorf("%s = %q, want %q", key, value.Emit(), expect.Emit())
+		}
+	}
+}
+
+func TestTelemetrySensenovaSpans(t *testing.T) {
+	client, exporter, _ := newTelemetryClient(t)
+	server, err := client.NewServer(ImplementSensenova)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := server.Chat(RequestData{Model: "SenseChat-5", UserQuery: "你好"}); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	chat, ok := spanNamed(spans, "chat SenseChat-5")
+	if !ok {
+		t.Fatalf("chat span not found: %v", spans)
+	}
+	children := strings.Join(childrenOf(spans, chat.SpanContext), ",")
+	if children != "sensenova token,POST" {
+		t.Errorf("chat span children = %s", children)
+	}
+	if value, _ := attributeOf(chat.Attributes, "gen_ai.system"); value.AsString() != "sensenova" {
+		t.Errorf("gen_ai.system = %s", value.AsString())
+	}
+}
+
+func TestTelemetryMetrics(t *testing.T) {
+	client, _, reader := newTelemetryClient(t)
+	server, err := client.NewServer(ImplementBaidu)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := server.Chat(RequestData{Model: "ernie-4.0", UserQuery: "你好"}); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := server.Stream(context.Background(), RequestData{Model: "ernie-4.0", UserQuery: "你好"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for stream.Next() {
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := metricdata.ResourceMetrics{}
+	if err := reader.Collect(context.Background(), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]uint64)
+	sums := make(map[string]int64)
+	for _, scope := range data.ScopeMetrics {
+		for _, item := range scope.Metrics {
+			switch value := item.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, point := range value.DataPoints {
+					counts[item.Name] += uint64(point.Value)
+				}
+			case metricdata.Histogram[float64]:
+				for _, point := range value.DataPoints {
+					counts[item.Name] += point.Count
+				}
+			case metricdata.Histogram[int64]:
+				for _, point := range value.DataPoints {
+					counts[item.Name] += point.Count
+					sums[item.Name] += point.Sum
+				}
+			}
+		}
+	}
+
+	want := map[string]uint64{
+		"gen_ai.client.requests":            2,
+		"gen_ai.client.operation.duration":  2,
+		"gen_ai.client.time_to_first_token": 1,
+		"gen_ai.client.token.usage":         4, // 每次请求记录输入、输出各一次
+	}
+	for name, count := range want {
+		if counts[name] != count {
+			t.Errorf("%s count = %d, want %d", name, counts[name], count)
+		}
+	}
+	if sums["gen_ai.client.token.usage"] != 10 {
+		t.Errorf("token usage sum = %d, want 10", sums["gen_ai.client.token.usage"])
+	}
+}
+
+func TestTelemetryDisabled(t *testing.T) {
+	upstream, transport := newTelemetryUpstream(t)
+
+	// 未配置时不使用全局 Provider
+	exporter := tracetest.NewInMemoryExporter()
+	reader := sdkmetric.NewManualReader()
+	tracerProvider, meterProvider := otel.GetTracerProvider(), otel.GetMeterProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(tracerProvider)
+		otel.SetMeterProvider(meterProvider)
+	})
+
+	client := NewClient(&Config{}, WithBaiDuConfig(upstream.URL+"/baidu", "client-id", "client-secret"), transport)
+	if client.telemetry.enabled {
+		t.Fatal("telemetry enabled without providers")
+	}
+	server, err := client.NewServer(ImplementBaidu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(server.middlewares) != 0 {
+		t.Errorf("middlewares = %d, want 0", len(server.middlewares))
+	}
+
+	if _, err := server.Chat(RequestData{Model: "ernie-4.0", UserQuery: "你好"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Errorf("spans recorded: %v", spans)
+	}
+	data := metricdata.ResourceMetrics{}
+	if err := reader.Collect(context.Background(), &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data.ScopeMetrics) != 0 {
+		t.Errorf("metrics recorded: %v", data.ScopeMetrics)
+	}
+}
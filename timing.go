@@ -0,0 +1,94 @@
+package pkg_ai
+
+import (
+	"sort"
+	"time"
+)
+
+// StreamStats 流式请求的耗时统计, 耗时单位均为毫秒; 请求失败时同样记录已收到的部分
+type StreamStats struct {
+	FirstTokenTime  int64   `json:"first_token_time"`  // 首个 token 耗时, 未收到内容时为 0
+	Duration        int64   `json:"duration"`          // 流式请求总耗时
+	Chunks          int64   `json:"chunks"`            // 收到的非空增量内容段数
+	IntervalAvg     float64 `json:"interval_avg"`      // 相邻两段内容的平均间隔
+	IntervalP50     float64 `json:"interval_p50"`      // 相邻两段内容间隔的中位数
+	IntervalP95     float64 `json:"interval_p95"`      // 相邻两段内容间隔的 95 分位
+	IntervalMax     float64 `json:"interval_max"`      // 相邻两段内容的最大间隔
+	TokensPerSecond float64 `json:"tokens_per_second"` // 输出速度, 按首个 token 至结束的时长计算, 无 token 消耗数据时为 0
+}
+
+// streamTimer 记录流式请求各段内容的到达时间, 仅在推送回调中调用, 无需加锁
+type streamTimer struct {
+	start     time.Time
+	first     time.Time
+	last      time.Time
+	chunks    int64
+	intervals []time.Duration
+}
+
+func newStreamTimer() *streamTimer {
+	return &streamTimer{start: time.Now()}
+}
+
+// chunk 收到一段非空内容
+func (t *streamTimer) chunk() {
+	now := time.Now()
+	if t.chunks == 0 {
+		t.first = now
+	} else {
+		t.intervals = append(t.intervals, now.Sub(t.last))
+	}
+	t.last = now
+	t.chunks++
+}
+
+// stats 结束时汇总, completionTokens 为输出 token 数
+func (t *streamTimer) stats(completionTokens int64) *StreamStats {
+	end := time.Now()
+	stats := &StreamStats{Duration: end.Sub(t.start).Milliseconds(), Chunks: t.chunks}
+	if t.chunks == 0 {
+		return stats
+	}
+	stats.FirstTokenTime = t.first.Sub(t.start).Milliseconds()
+
+	if generation := end.Sub(t.first).Seconds(); completionTokens > 0 && generation > 0 {
+		stats.TokensPerSecond = float64(completionTokens) / generation
+	}
+
+	if len(t.intervals) == 0 {
+		return stats
+	}
+
+	intervals := append([]time.Duration(nil), t.intervals...)
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i] < intervals[j]
+	})
+
+	total := time.Duration(0)
+	for _, interval := range intervals {
+		total += interval
+	}
+	stats.IntervalAvg = milliseconds(total / time.Duration(len(intervals)))
+	stats.IntervalP50 = milliseconds(percentile(intervals, 0.5))
+	stats.IntervalP95 = milliseconds(percentile(intervals, 0.95))
+	stats.IntervalMax = milliseconds(intervals[len(intervals)-1])
+
+	return stats
+}
+
+// percentile 已排序数据的分位值(最近秩法)
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	index := int(float64(len(sorted))*p+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+
+	return sorted[index]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -1,36 +1,34 @@
 package pkg_ai
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
-	"net/http"
-	"strings"
 )
 
-func postBase(url string, payload string, headers map[string]string) (resp *http.Response, err error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", url, strings.NewReader(payload))
-	if err != nil {
-		return
-	}
-	for index, val := range headers {
-		req.Header.Set(index, val)
+// sendMsg 推送流式数据, 上下文取消时放弃推送并返回取消原因
+func sendMsg(ctx context.Context, msgCh chan string, msg string) error {
+	select {
+	case msgCh <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-
-	return client.Do(req)
 }
 
-func getBase(requestUrl string, headers map[string]string) (resp *http.Response, err error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", requestUrl, nil)
-	if err != nil {
+// sendErr 推送错误信息, 上下文取消时放弃推送, 避免无人接收时永久阻塞
+func sendErr(ctx context.Context, errChan chan error, err error) {
+	select {
+	case errChan <- err:
 		return
+	default:
 	}
-	for index, val := range headers {
-		req.Header.Set(index, val)
+
+	select {
+	case errChan <- err:
+	case <-ctx.Done():
 	}
-	return client.Do(req)
 }
 
 func sha256hex(s string) string {
@@ -0,0 +1,79 @@
+package pkg_ai
+
+// Usage token 消耗明细
+type Usage struct {
+	PromptTokens       int64            `json:"prompt_tokens"`                  // 输入 token
+	CompletionTokens   int64            `json:"completion_tokens"`              // 输出 token, 含推理 token
+	TotalTokens        int64            `json:"total_tokens"`                   // 总 token
+	CachedPromptTokens int64            `json:"cached_prompt_tokens,omitempty"` // 输入中命中缓存的 token
+	ReasoningTokens    int64            `json:"reasoning_tokens,omitempty"`     // 推理(思考)过程消耗的 token
+	Extra              map[string]int64 `json:"extra,omitempty"`                // 服务商特有的计数, 如商汤 knowledge_tokens、DeepSeek prompt_cache_miss_tokens
+}
+
+func (u Usage) empty() bool {
+	return u.PromptTokens == 0 && u.CompletionTokens == 0 && u.TotalTokens == 0
+}
+
+func (u *Usage) extra(key string, value int64) {
+	if value == 0 {
+		return
+	}
+	if u.Extra == nil {
+		u.Extra = make(map[string]int64)
+	}
+	u.Extra[key] = value
+}
+
+// setUsage 记录 token 消耗, 同步更新 PromptTokens、CompletionTokens; 为空时忽略, 避免覆盖已记录的数据
+func (r *Response) setUsage(usage Usage) {
+	if usage.empty() {
+		return
+	}
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+
+	r.Usage = usage
+	r.PromptTokens = usage.PromptTokens
+	r.CompletionTokens = usage.CompletionTokens
+}
+
+// OpenAIUsage OpenAI 风格的 usage 结构, 兼容各服务商的扩展字段
+type OpenAIUsage struct {
+	PromptTokens          int64 `json:"prompt_tokens"`
+	CompletionTokens      int64 `json:"completion_tokens"`
+	TotalTokens           int64 `json:"total_tokens"`
+	CachedTokens          int64 `json:"cached_tokens"`            // 月之暗面上下文缓存
+	PromptCacheHitTokens  int64 `json:"prompt_cache_hit_tokens"`  // DeepSeek 缓存命中
+	PromptCacheMissTokens int64 `json:"prompt_cache_miss_tokens"` // DeepSeek 缓存未命中
+	KnowledgeTokens       int64 `json:"knowledge_tokens"`         // 商汤知识库
+	SearchCount           int64 `json:"search_count"`             // 百川搜索增强次数
+	PromptTokensDetails   struct {
+		CachedTokens int64 `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+	CompletionTokensDetails struct {
+		ReasoningTokens int64 `json:"reasoning_tokens"`
+	} `json:"completion_tokens_details"`
+}
+
+func (u *OpenAIUsage) usage() Usage {
+	if u == nil {
+		return Usage{}
+	}
+
+	usage := Usage{
+		PromptTokens:       u.PromptTokens,
+		CompletionTokens:   u.CompletionTokens,
+		TotalTokens:        u.TotalTokens,
+		CachedPromptTokens: u.PromptTokensDetails.CachedTokens,
+		ReasoningTokens:    u.CompletionTokensDetails.ReasoningTokens,
+	}
+	if usage.CachedPromptTokens == 0 {
+		usage.CachedPromptTokens = u.CachedTokens + u.PromptCacheHitTokens
+	}
+	usage.extra("prompt_cache_miss_tokens", u.PromptCacheMissTokens)
+	usage.extra("knowledge_tokens", u.KnowledgeTokens)
+	usage.extra("search_count", u.SearchCount)
+
+	return usage
+}